@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/connectors/device"
+)
+
+// Handler apply a config to the device.
+type Handler interface {
+	Apply(config []byte) error
+}
+
+// HandlerFunc adapt a function to a Handler.
+type HandlerFunc func(config []byte) error
+
+// Apply call the function.
+func (handler HandlerFunc) Apply(config []byte) error {
+	return handler(config)
+}
+
+// SchemaVersionField is the JSON config field holding the config schema version.
+const SchemaVersionField = "schemaVersion"
+
+// Status is the outcome of a config, reported in the device state.
+type Status int
+
+const (
+	// Applied configs are the running device config.
+	Applied Status = 1 + iota
+	// RolledBack configs failed, the previous config is running again.
+	RolledBack
+	// Failed configs could not be applied and nothing could be restored.
+	Failed
+)
+
+var statusName = [...]string{
+	"applied",
+	"rolledBack",
+	"failed",
+}
+
+func (status Status) String() string {
+	return statusName[status-1]
+}
+
+// MarshalJSON write the status name.
+func (status Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(status.String())
+}
+
+// UnmarshalJSON read a status name.
+func (status *Status) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for i, statusName := range statusName {
+		if statusName == name {
+			*status = Status(i + 1)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown config status %q", name)
+}
+
+// AppliedConfig is the last successfully applied config, persisted in the agent state file.
+type AppliedConfig struct {
+	SchemaVersion string    `json:"schemaVersion,omitempty"`
+	Digest        string    `json:"digest"`
+	Config        []byte    `json:"config"`
+	AppliedAt     time.Time `json:"appliedAt"`
+}
+
+// ReportKey is the device state key holding the Report, the rest of the state is the running config so the
+// device twin compares it with the desired one, e.g. iotctl device twin --ignore agent.
+const ReportKey = "agent"
+
+// Report is published in the device state, under ReportKey, after every config.
+type Report struct {
+	Status Status `json:"status"`
+	// Digest identify the received config, the SHA-256 of its bytes.
+	Digest        string `json:"digest"`
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+	// RunningDigest identify the config the device runs after this one was handled.
+	RunningDigest string    `json:"runningDigest,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// Agent apply the configs pushed to a device with the handler of their schema version and report the
+// outcome as the device state, so devices converge to the configs set from the admin side.
+type Agent struct {
+	client    device.DeviceClient
+	statePath string
+	mutex     sync.Mutex
+	handlers  map[string]Handler
+	last      *AppliedConfig
+	// running is the digest of the config a handler applied in this process.
+	running string
+	// SchemaVersionOf extract the schema version of a config, the SchemaVersionField of a JSON object by
+	// default. Configs without version use the handler registered for "".
+	SchemaVersionOf func(config []byte) (string, error)
+}
+
+// New create an Agent for client, the last applied config is read from statePath when it exists.
+func New(client device.DeviceClient, statePath string) (*Agent, error) {
+	agent := &Agent{client: client, statePath: statePath, handlers: map[string]Handler{}, SchemaVersionOf: JSONSchemaVersion}
+
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return agent, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	agent.last = &AppliedConfig{}
+	if err = json.Unmarshal(data, agent.last); err != nil {
+		return nil, fmt.Errorf("agent state %s: %s", statePath, err.Error())
+	}
+	return agent, nil
+}
+
+// JSONSchemaVersion returns the SchemaVersionField of a JSON config, empty when missing.
+func JSONSchemaVersion(config []byte) (string, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(config, &document); err != nil {
+		return "", fmt.Errorf("config is not a JSON object: %s", err.Error())
+	}
+
+	switch version := document[SchemaVersionField].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return version, nil
+	case float64:
+		return fmt.Sprint(version), nil
+	}
+	return "", fmt.Errorf("config %s must be a string or a number", SchemaVersionField)
+}
+
+// Handle register the handler of a config schema version, "" handles the configs without version.
+func (agent *Agent) Handle(schemaVersion string, handler Handler) {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	agent.handlers[schemaVersion] = handler
+}
+
+// Start subscribe to the device configs, the broker delivers the current config right away.
+func (agent *Agent) Start() error {
+	return agent.client.OnConfig(func(config []byte) {
+		agent.Apply(config)
+	})
+}
+
+// LastApplied returns the running config, nil before the first one.
+func (agent *Agent) LastApplied() *AppliedConfig {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	return agent.last
+}
+
+// Apply handle a config and report the outcome. A config already applied by this process, e.g. redelivered
+// on reconnect, is only reported again. After a restart the first delivery is applied again, the device does
+// not run it yet. When the handler fails, the last applied config, persisted across restarts, is applied again.
+func (agent *Agent) Apply(config []byte) Report {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+
+	sum := sha256.Sum256(config)
+	report := Report{Digest: hex.EncodeToString(sum[:]), Time: time.Now().UTC()}
+
+	if agent.running == report.Digest {
+		report.Status, report.SchemaVersion, report.RunningDigest = Applied, agent.last.SchemaVersion, report.Digest
+		return agent.report(report)
+	}
+
+	schemaVersion, err := agent.SchemaVersionOf(config)
+	report.SchemaVersion = schemaVersion
+	if err == nil {
+		err = agent.applyWith(schemaVersion, config)
+	}
+
+	if err == nil {
+		report.Status, report.RunningDigest = Applied, report.Digest
+		agent.running = report.Digest
+		agent.last = &AppliedConfig{SchemaVersion: schemaVersion, Digest: report.Digest, Config: config, AppliedAt: report.Time}
+		if persistErr := agent.persist(); persistErr != nil {
+			log.Errorln("agent state not saved, the config will be applied again after a restart: " + persistErr.Error())
+		}
+		return agent.report(report)
+	}
+
+	report.Status, report.Error = Failed, err.Error()
+	log.Errorln("config " + report.Digest + " failed: " + err.Error())
+	// the last config is only applied again when it is not the failing one, e.g. applied again after a restart
+	if agent.last != nil && agent.last.Digest != report.Digest {
+		if rollbackErr := agent.applyWith(agent.last.SchemaVersion, agent.last.Config); rollbackErr != nil {
+			report.Error += ", rollback failed: " + rollbackErr.Error()
+			log.Errorln("rollback to config " + agent.last.Digest + " failed: " + rollbackErr.Error())
+		} else {
+			report.Status, report.RunningDigest = RolledBack, agent.last.Digest
+			agent.running = agent.last.Digest
+		}
+	}
+
+	return agent.report(report)
+}
+
+func (agent *Agent) applyWith(schemaVersion string, config []byte) error {
+	handler, exist := agent.handlers[schemaVersion]
+	if !exist {
+		return fmt.Errorf("no handler for config schema version %q", schemaVersion)
+	}
+	return handler.Apply(config)
+}
+
+func (agent *Agent) report(report Report) Report {
+	state, err := agent.state(report)
+	if err == nil {
+		err = agent.client.ReportState(state)
+	}
+	if err != nil {
+		log.Errorln("config state not reported: " + err.Error())
+	}
+	return report
+}
+
+// state is the running config, when it is a JSON object, with the report under ReportKey. Without running
+// config the state only holds the report.
+func (agent *Agent) state(report Report) ([]byte, error) {
+	document := map[string]json.RawMessage{}
+	if agent.last != nil && len(report.RunningDigest) > 0 && report.RunningDigest == agent.last.Digest {
+		if err := json.Unmarshal(agent.last.Config, &document); err != nil || document == nil {
+			document = map[string]json.RawMessage{}
+		}
+	}
+
+	reportData, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	document[ReportKey] = reportData
+
+	return json.Marshal(document)
+}
+
+// persist write the state file through a temporary file, a crash never leaves a truncated state.
+func (agent *Agent) persist() error {
+	data, err := json.Marshal(agent.last)
+	if err != nil {
+		return err
+	}
+
+	temporary, err := ioutil.TempFile(filepath.Dir(agent.statePath), filepath.Base(agent.statePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = temporary.Write(data); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err = temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	return os.Rename(temporary.Name(), agent.statePath)
+}
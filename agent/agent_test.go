@@ -0,0 +1,169 @@
+package agent_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/agent"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/twin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type AgentTestSuite struct {
+	suite.Suite
+	dir     string
+	client  *fakeClient
+	running []string
+}
+
+type fakeClient struct {
+	device.DeviceClient
+	states    []agent.Report
+	rawStates [][]byte
+	config    func(config []byte)
+}
+
+func (client *fakeClient) ReportState(state []byte) error {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(state, &document); err != nil {
+		return err
+	}
+	var report agent.Report
+	if err := json.Unmarshal(document[agent.ReportKey], &report); err != nil {
+		return err
+	}
+	client.states = append(client.states, report)
+	client.rawStates = append(client.rawStates, state)
+	return nil
+}
+
+func (client *fakeClient) OnConfig(handler func(config []byte)) error {
+	client.config = handler
+	return nil
+}
+
+func (suite *AgentTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "agent")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	suite.dir = dir
+	suite.client = &fakeClient{}
+	suite.running = nil
+}
+
+func (suite *AgentTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *AgentTestSuite) newAgent() *agent.Agent {
+	deviceAgent, err := agent.New(suite.client, filepath.Join(suite.dir, "agent.json"))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	apply := func(config []byte) error {
+		var document map[string]interface{}
+		json.Unmarshal(config, &document)
+		if document["fan"] == "broken" {
+			return errors.New("fan unavailable")
+		}
+		suite.running = append(suite.running, string(config))
+		return nil
+	}
+	deviceAgent.Handle("1", agent.HandlerFunc(apply))
+	deviceAgent.Handle("2", agent.HandlerFunc(apply))
+	return deviceAgent
+}
+
+func (suite *AgentTestSuite) TestApplyAndReport() {
+	deviceAgent := suite.newAgent()
+	assert.NoError(suite.T(), deviceAgent.Start(), "UnexpectedError")
+
+	suite.client.config([]byte(`{"schemaVersion":1,"fan":"on"}`))
+	assert.Len(suite.T(), suite.client.states, 1)
+	assert.Equal(suite.T(), agent.Applied, suite.client.states[0].Status)
+	assert.Equal(suite.T(), "1", suite.client.states[0].SchemaVersion)
+	assert.Equal(suite.T(), suite.client.states[0].Digest, suite.client.states[0].RunningDigest)
+	assert.Equal(suite.T(), "1", deviceAgent.LastApplied().SchemaVersion)
+}
+
+func (suite *AgentTestSuite) TestStateIsRunningConfig() {
+	config := `{"schemaVersion":"1","fan":"on"}`
+	suite.newAgent().Apply([]byte(config))
+
+	var state map[string]interface{}
+	assert.NoError(suite.T(), json.Unmarshal(suite.client.rawStates[0], &state), "UnexpectedError")
+	assert.Equal(suite.T(), "on", state["fan"])
+	assert.Equal(suite.T(), "1", state["schemaVersion"])
+
+	// the device twin sees the applied config in sync once the agent report is ignored
+	now := time.Now().UTC()
+	result, err := twin.Compute(
+		&cloudiot.Device{Id: "device-1", LastConfigAckTime: now.Format(time.RFC3339Nano), LastStateTime: now.Format(time.RFC3339Nano)},
+		[]*cloudiot.DeviceConfig{{Version: 1, CloudUpdateTime: now.Add(-time.Minute).Format(time.RFC3339Nano), BinaryData: base64.StdEncoding.EncodeToString([]byte(config))}},
+		[]*cloudiot.DeviceState{{UpdateTime: now.Format(time.RFC3339Nano), BinaryData: base64.StdEncoding.EncodeToString(suite.client.rawStates[0])}},
+		now, twin.Options{Ignore: []string{agent.ReportKey}})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), twin.InSync, result.Status)
+}
+
+func (suite *AgentTestSuite) TestRollback() {
+	deviceAgent := suite.newAgent()
+	first := deviceAgent.Apply([]byte(`{"schemaVersion":"1","fan":"on"}`))
+
+	report := deviceAgent.Apply([]byte(`{"schemaVersion":"2","fan":"broken"}`))
+	assert.Equal(suite.T(), agent.RolledBack, report.Status)
+	assert.Equal(suite.T(), "fan unavailable", report.Error)
+	assert.Equal(suite.T(), first.Digest, report.RunningDigest)
+	assert.Equal(suite.T(), []string{`{"schemaVersion":"1","fan":"on"}`, `{"schemaVersion":"1","fan":"on"}`}, suite.running)
+
+	report = deviceAgent.Apply([]byte(`{"schemaVersion":"3"}`))
+	assert.Equal(suite.T(), agent.RolledBack, report.Status)
+	assert.Contains(suite.T(), report.Error, "no handler")
+}
+
+func (suite *AgentTestSuite) TestFailureWithoutPreviousConfig() {
+	report := suite.newAgent().Apply([]byte(`not json`))
+	assert.Equal(suite.T(), agent.Failed, report.Status)
+	assert.Empty(suite.T(), report.RunningDigest)
+	assert.Len(suite.T(), suite.client.states, 1)
+}
+
+func (suite *AgentTestSuite) TestLastAppliedSurviveRestart() {
+	config := []byte(`{"schemaVersion":"1","fan":"on"}`)
+	suite.newAgent().Apply(config)
+
+	restarted := suite.newAgent()
+	assert.NotNil(suite.T(), restarted.LastApplied())
+	assert.Equal(suite.T(), config, restarted.LastApplied().Config)
+
+	// the broker delivers the current config again on subscribe, the restarted device applies it again
+	report := restarted.Apply(config)
+	assert.Equal(suite.T(), agent.Applied, report.Status)
+	assert.Len(suite.T(), suite.running, 2)
+	assert.Len(suite.T(), suite.client.states, 2)
+
+	// later deliveries of the running config are only reported
+	report = restarted.Apply(config)
+	assert.Equal(suite.T(), agent.Applied, report.Status)
+	assert.Len(suite.T(), suite.running, 2)
+	assert.Len(suite.T(), suite.client.states, 3)
+}
+
+func (suite *AgentTestSuite) TestRollbackAfterRestart() {
+	suite.newAgent().Apply([]byte(`{"schemaVersion":"1","fan":"on"}`))
+
+	report := suite.newAgent().Apply([]byte(`{"schemaVersion":"2","fan":"broken"}`))
+	assert.Equal(suite.T(), agent.RolledBack, report.Status)
+	assert.Equal(suite.T(), []string{`{"schemaVersion":"1","fan":"on"}`, `{"schemaVersion":"1","fan":"on"}`}, suite.running)
+}
+
+func TestAgentTestSuite(t *testing.T) {
+	suite.Run(t, new(AgentTestSuite))
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ActorEnv overrides the actor recorded by DefaultActor, e.g. with the CI job or the ticket owner.
+const ActorEnv = "IOT_ACTOR"
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor"`
+	Action   string            `json:"action"`
+	Registry string            `json:"registry,omitempty"`
+	Device   string            `json:"device,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// Log is an append only JSONL audit log, safe for concurrent use.
+type Log struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	file   *os.File
+}
+
+// Open append to the audit log file at path, created only readable by its owner.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{writer: file, file: file}, nil
+}
+
+// NewLog write the audit log to writer.
+func NewLog(writer io.Writer) *Log {
+	return &Log{writer: writer}
+}
+
+// Record append an entry, stamped with the current time when it has none. Entries of a file are synced
+// to disk before Record returns.
+func (auditLog *Log) Record(entry Entry) error {
+	if len(entry.Actor) == 0 || len(entry.Action) == 0 {
+		return fmt.Errorf("audit entries require an actor and an action")
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	if _, err = auditLog.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if auditLog.file != nil {
+		return auditLog.file.Sync()
+	}
+	return nil
+}
+
+// Close the audit log file.
+func (auditLog *Log) Close() error {
+	if auditLog.file != nil {
+		return auditLog.file.Close()
+	}
+	return nil
+}
+
+// Read returns every entry of an audit log.
+func Read(reader io.Reader) (entries []Entry, err error) {
+	decoder := json.NewDecoder(reader)
+	for {
+		var entry Entry
+		if err = decoder.Decode(&entry); err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// DefaultActor returns the ActorEnv value, user@host otherwise.
+func DefaultActor() string {
+	if actor := os.Getenv(ActorEnv); len(actor) > 0 {
+		return actor
+	}
+
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+package audit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuditTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *AuditTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	suite.dir = dir
+}
+
+func (suite *AuditTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *AuditTestSuite) TestOpenAppends() {
+	path := filepath.Join(suite.dir, "logs", "audit.jsonl")
+	at := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, reason := range []string{"first", "second"} {
+		auditLog, err := audit.Open(path)
+		assert.NoError(suite.T(), err, "UnexpectedError")
+		err = auditLog.Record(audit.Entry{Time: at, Actor: "oncall", Action: "config.rollback", Device: "device-1", Reason: reason})
+		assert.NoError(suite.T(), err, "UnexpectedError")
+		assert.NoError(suite.T(), auditLog.Close(), "UnexpectedError")
+	}
+
+	file, err := os.Open(path)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer file.Close()
+	entries, err := audit.Read(file)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), entries, 2)
+	assert.Equal(suite.T(), "first", entries[0].Reason)
+	assert.Equal(suite.T(), "second", entries[1].Reason)
+	assert.Equal(suite.T(), at, entries[1].Time)
+}
+
+func (suite *AuditTestSuite) TestRecordRequiresActor() {
+	auditLog, err := audit.Open(filepath.Join(suite.dir, "audit.jsonl"))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer auditLog.Close()
+	assert.Error(suite.T(), auditLog.Record(audit.Entry{Action: "config.rollback"}))
+}
+
+func (suite *AuditTestSuite) TestDefaultActor() {
+	os.Setenv(audit.ActorEnv, "ci-job")
+	defer os.Unsetenv(audit.ActorEnv)
+	assert.Equal(suite.T(), "ci-job", audit.DefaultActor())
+}
+
+func TestAuditTestSuite(t *testing.T) {
+	suite.Run(t, new(AuditTestSuite))
+}
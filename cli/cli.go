@@ -0,0 +1,302 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pjgg/iotPlayground/audit"
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/registry"
+	"github.com/pjgg/iotPlayground/keystore"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"google.golang.org/api/googleapi"
+)
+
+// Exit codes returned by Run, API errors are mapped from their HTTP status.
+const (
+	ExitOK = iota
+	ExitError
+	ExitUsage
+	ExitConfiguration
+	ExitNotFound
+	ExitAlreadyExists
+	ExitPermissionDenied
+	ExitInvalidArgument
+	ExitFailedPrecondition
+	ExitUnavailable
+)
+
+// App is the iotctl command line, connectors are created through factories so they can be replaced.
+type App struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// RegistryConnector returns the registry admin connector of a configuration profile.
+	RegistryConnector func(profile string) (registry.HTTPIotRegistryConnectorInterface, error)
+	// DeviceConnector returns the device admin connector of a configuration profile, bound to registryID.
+	DeviceConnector func(profile, registryID string) (device.HTTPIotDeviceConnectorInterface, error)
+	// MQTTConnector returns a connected device telemetry connector of a configuration profile, acting as deviceID.
+	MQTTConnector func(profile, registryID, deviceID string) (device.MQTTIotDeviceConnectorInterface, error)
+	// AuditLog returns the audit log of a configuration profile.
+	AuditLog func(profile string) (*audit.Log, error)
+}
+
+// NewApp create an App on the process standard streams and the Cloud IoT connectors.
+func NewApp() *App {
+	return &App{
+		Stdin:             os.Stdin,
+		Stdout:            os.Stdout,
+		Stderr:            os.Stderr,
+		RegistryConnector: registry.NewProfileHTTPIotRegistryConnector,
+		DeviceConnector:   device.NewProfileDeviceHTTPIotConnector,
+		MQTTConnector: func(profile, registryID, deviceID string) (device.MQTTIotDeviceConnectorInterface, error) {
+			return device.NewProfileMQTTIotConnector(profile, registryID, deviceID, nil)
+		},
+		AuditLog: func(profile string) (*audit.Log, error) {
+			conf, err := configuration.LoadProfile(profile)
+			if err != nil {
+				return nil, err
+			}
+			return audit.Open(conf.AuditLogPath)
+		},
+	}
+}
+
+// command is a leaf of the command tree, e.g. "registry iam set".
+type command struct {
+	path  string
+	args  string
+	short string
+	flags func(flags *pflag.FlagSet)
+	run   func(ctx *commandContext, args []string) error
+	// watch reload the configuration file while the command runs, for long running commands.
+	watch bool
+}
+
+// commandContext hold what a command needs once its flags are parsed.
+type commandContext struct {
+	app     *App
+	flags   *pflag.FlagSet
+	printer *printer
+	profile string
+}
+
+// usageError is a wrong invocation, reported with the command usage.
+type usageError struct {
+	message string
+}
+
+func (err *usageError) Error() string {
+	return err.message
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+var commands []*command
+
+func register(newCommands ...*command) {
+	commands = append(commands, newCommands...)
+}
+
+// Run execute the command line and returns the process exit code.
+func (app *App) Run(args []string) int {
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		if len(args) > 0 && args[0] != "help" && args[0] != "--help" && args[0] != "-h" {
+			fmt.Fprintf(app.Stderr, "unknown command %q\n\n", strings.Join(args, " "))
+			app.usage()
+			return ExitUsage
+		}
+		app.usage()
+		return ExitOK
+	}
+
+	flags := pflag.NewFlagSet("iotctl "+cmd.path, pflag.ContinueOnError)
+	flags.SetOutput(app.Stderr)
+	output := flags.StringP("output", "o", "table", "output format: table, json or yaml")
+	configPath := flags.String("config", "", "configuration file, config.yaml in the working directory or ~/.iotctl by default")
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	if err := configuration.BindFlags(flags); err != nil {
+		fmt.Fprintln(app.Stderr, err.Error())
+		return ExitError
+	}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		// configuration keys are accepted as --<key> but only the profile is worth listing
+		if strings.Contains(flag.Name, ".") {
+			flags.MarkHidden(flag.Name)
+		}
+	})
+	flags.Usage = func() {
+		fmt.Fprintf(app.Stderr, "Usage: iotctl %s %s\n\n%s\n\nFlags:\n%s\nEvery configuration key can be set with --<key>, e.g. --gcloud.region=us-central1.\n",
+			cmd.path, cmd.args, cmd.short, flags.FlagUsages())
+	}
+
+	if err := flags.Parse(rest); err != nil {
+		if err == pflag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	printer, err := newPrinter(*output, app.Stdout)
+	if err != nil {
+		fmt.Fprintln(app.Stderr, err.Error())
+		return ExitUsage
+	}
+
+	if err = readConfigFile(*configPath); err != nil {
+		fmt.Fprintln(app.Stderr, err.Error())
+		return ExitConfiguration
+	}
+	if cmd.watch {
+		configuration.Watch()
+	}
+
+	ctx := &commandContext{app: app, flags: flags, printer: printer, profile: configuration.ActiveProfile()}
+	if err = cmd.run(ctx, flags.Args()); err != nil {
+		fmt.Fprintln(app.Stderr, "error: "+err.Error())
+		if _, isUsage := err.(*usageError); isUsage {
+			flags.Usage()
+		}
+		return ExitCode(err)
+	}
+
+	return ExitOK
+}
+
+// ExitCode map an error to the process exit code.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	switch typedErr := err.(type) {
+	case *usageError:
+		return ExitUsage
+	case *configuration.ValidationError:
+		return ExitConfiguration
+	case *googleapi.Error:
+		switch {
+		case typedErr.Code == 400:
+			return ExitInvalidArgument
+		case typedErr.Code == 401 || typedErr.Code == 403:
+			return ExitPermissionDenied
+		case typedErr.Code == 404:
+			return ExitNotFound
+		case typedErr.Code == 409:
+			return ExitAlreadyExists
+		case typedErr.Code == 412:
+			return ExitFailedPrecondition
+		case typedErr.Code == 429 || typedErr.Code >= 500:
+			return ExitUnavailable
+		}
+	}
+
+	if err == keystore.ErrWrongPassphrase {
+		return ExitConfiguration
+	}
+
+	return ExitError
+}
+
+func findCommand(args []string) (*command, []string) {
+	var found *command
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.path)
+		if len(words) > len(args) || (found != nil && len(words) <= len(strings.Fields(found.path))) {
+			continue
+		}
+		matches := true
+		for i, word := range words {
+			if args[i] != word {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = cmd
+		}
+	}
+
+	if found == nil {
+		return nil, args
+	}
+	return found, args[len(strings.Fields(found.path)):]
+}
+
+func (app *App) usage() {
+	fmt.Fprintln(app.Stderr, "Usage: iotctl <command> [arguments] [flags]\n\nCommands:")
+
+	sorted := make([]*command, len(commands))
+	copy(sorted, commands)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+	for _, cmd := range sorted {
+		fmt.Fprintf(app.Stderr, "  %-24s %s\n", cmd.path, cmd.short)
+	}
+
+	fmt.Fprintln(app.Stderr, "\nRun iotctl <command> --help for the command flags.")
+}
+
+// readConfigFile read the given file, or config.yaml from the working directory or ~/.iotctl. A missing
+// default file is not an error, ENV and flags may hold the whole configuration.
+func readConfigFile(path string) error {
+	if len(path) > 0 {
+		viper.SetConfigFile(path)
+		return viper.ReadInConfig()
+	}
+
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("$HOME/.iotctl")
+	err := viper.ReadInConfig()
+	if _, notFound := err.(viper.ConfigFileNotFoundError); notFound {
+		return nil
+	}
+	return err
+}
+
+// requireArgs check the positional arguments count.
+func requireArgs(args []string, names ...string) error {
+	if len(args) != len(names) {
+		return usagef("expected %d argument(s) %s, got %d", len(names), strings.Join(names, " "), len(args))
+	}
+	return nil
+}
+
+// requireFlag returns a string flag value, a usage error when it is empty.
+func (ctx *commandContext) requireFlag(name string) (string, error) {
+	value, _ := ctx.flags.GetString(name)
+	if len(value) == 0 {
+		return "", usagef("missing required flag --%s", name)
+	}
+	return value, nil
+}
+
+func (ctx *commandContext) registries() (registry.HTTPIotRegistryConnectorInterface, error) {
+	return ctx.app.RegistryConnector(ctx.profile)
+}
+
+func (ctx *commandContext) devices() (device.HTTPIotDeviceConnectorInterface, error) {
+	registryID, err := ctx.requireFlag("registry")
+	if err != nil {
+		return nil, err
+	}
+	return ctx.app.DeviceConnector(ctx.profile, registryID)
+}
+
+func (ctx *commandContext) mqtt(deviceID string) (device.MQTTIotDeviceConnectorInterface, error) {
+	registryID, err := ctx.requireFlag("registry")
+	if err != nil {
+		return nil, err
+	}
+	return ctx.app.MQTTConnector(ctx.profile, registryID, deviceID)
+}
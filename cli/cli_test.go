@@ -0,0 +1,341 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pjgg/iotPlayground/audit"
+	"github.com/pjgg/iotPlayground/cli"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/registry"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+	"google.golang.org/api/googleapi"
+)
+
+type CliTestSuite struct {
+	suite.Suite
+	app    *cli.App
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+	mqtt   *fakeMQTTConnector
+	audit  *bytes.Buffer
+}
+
+type fakeRegistryConnector struct {
+	registry.HTTPIotRegistryConnectorInterface
+}
+
+func (fake *fakeRegistryConnector) ListRegistries() ([]*cloudiot.DeviceRegistry, error) {
+	return []*cloudiot.DeviceRegistry{{Id: "registry-a"}, {Id: "registry-b"}}, nil
+}
+
+func (fake *fakeRegistryConnector) GetRegistry(registryID string) (*cloudiot.DeviceRegistry, error) {
+	return nil, &googleapi.Error{Code: 404, Message: "registry " + registryID + " not found"}
+}
+
+type fakeDeviceConnector struct {
+	device.HTTPIotDeviceConnectorInterface
+	patched *cloudiot.Device
+	field   string
+}
+
+func (fake *fakeDeviceConnector) PatchDevice(deviceID string, newDevice *cloudiot.Device, field string) (*cloudiot.Device, error) {
+	fake.patched, fake.field = newDevice, field
+	newDevice.Id = deviceID
+	return newDevice, nil
+}
+
+func (fake *fakeDeviceConnector) ListDevices() ([]*cloudiot.Device, error) {
+	return []*cloudiot.Device{{Id: "device-1"}}, nil
+}
+
+func (fake *fakeDeviceConnector) GetDevice(deviceID string) (*cloudiot.Device, error) {
+	return &cloudiot.Device{Id: deviceID, LastConfigAckTime: "2018-03-01T12:01:00Z", LastStateTime: "2018-03-01T12:02:00Z"}, nil
+}
+
+func (fake *fakeDeviceConnector) GetDeviceConfigs(deviceID string) ([]*cloudiot.DeviceConfig, error) {
+	return []*cloudiot.DeviceConfig{
+		{Version: 4, CloudUpdateTime: "2018-03-01T12:00:00Z", BinaryData: "eyJmYW4iOiJvbiJ9"},
+		{Version: 3, CloudUpdateTime: "2018-02-01T12:00:00Z", BinaryData: "eyJmYW4iOiJvZmYifQ=="},
+	}, nil
+}
+
+func (fake *fakeDeviceConnector) GetDeviceStates(deviceID string) ([]*cloudiot.DeviceState, error) {
+	return []*cloudiot.DeviceState{{UpdateTime: "2018-03-01T12:02:00Z", BinaryData: "eyJmYW4iOiJvZmYifQ=="}}, nil
+}
+
+func (fake *fakeDeviceConnector) SetDeviceConfig(deviceID string, configData string) (*cloudiot.DeviceConfig, error) {
+	return &cloudiot.DeviceConfig{Version: 2, BinaryData: configData}, nil
+}
+
+func (fake *fakeDeviceConnector) SetDeviceConfigVersion(deviceID string, configData string, versionToUpdate int64) (*cloudiot.DeviceConfig, error) {
+	if versionToUpdate != 4 {
+		return nil, &googleapi.Error{Code: 409, Message: "the config version does not match"}
+	}
+	return &cloudiot.DeviceConfig{Version: 5, BinaryData: configData}, nil
+}
+
+func (fake *fakeDeviceConnector) CreateDeviceFrom(deviceDef *cloudiot.Device) (*cloudiot.Device, error) {
+	if deviceDef.Id == "sensor-2" {
+		return nil, &googleapi.Error{Code: 409, Message: "device already exists"}
+	}
+	return deviceDef, nil
+}
+
+// doneToken is an already completed MQTT token.
+type doneToken struct {
+	mqtt.Token
+}
+
+func (token doneToken) Wait() bool {
+	return true
+}
+
+func (token doneToken) Error() error {
+	return nil
+}
+
+type fakeMQTTConnector struct {
+	device.MQTTIotDeviceConnectorInterface
+	topics   []string
+	messages []string
+	qos      []connectors.QoS
+}
+
+func (fake *fakeMQTTConnector) PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) mqtt.Token {
+	fake.topics = append(fake.topics, topicName)
+	fake.messages = append(fake.messages, msg)
+	fake.qos = append(fake.qos, delivery)
+	return doneToken{}
+}
+
+func (fake *fakeMQTTConnector) PublishTelemetry(toDeviceID string, message *telemetry.Telemetry, delivery connectors.QoS) (mqtt.Token, error) {
+	message.Session = "session"
+	envelope, err := message.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return fake.PublishMsg(toDeviceID, message.Topic(), string(envelope), delivery), nil
+}
+
+func (fake *fakeMQTTConnector) PublishState(toDeviceID, state string, delivery connectors.QoS) mqtt.Token {
+	return fake.PublishMsg(toDeviceID, "state", state, delivery)
+}
+
+func (fake *fakeMQTTConnector) SubscribeConfig(toDeviceID string, handler func(config []byte)) mqtt.Token {
+	go handler([]byte("{\"fan\":\"on\"}"))
+	return doneToken{}
+}
+
+func (fake *fakeMQTTConnector) SubscribeCommands(toDeviceID string, handler func(subfolder string, command []byte)) mqtt.Token {
+	go handler("reboot", []byte("now"))
+	return doneToken{}
+}
+
+func (suite *CliTestSuite) SetupTest() {
+	viper.Reset()
+	suite.stdout = &bytes.Buffer{}
+	suite.stderr = &bytes.Buffer{}
+	deviceConnector := &fakeDeviceConnector{}
+	suite.mqtt = &fakeMQTTConnector{}
+	suite.audit = &bytes.Buffer{}
+	suite.app = &cli.App{
+		Stdin:  bytes.NewBufferString("{\"fan\":\"on\"}"),
+		Stdout: suite.stdout,
+		Stderr: suite.stderr,
+		RegistryConnector: func(profile string) (registry.HTTPIotRegistryConnectorInterface, error) {
+			return &fakeRegistryConnector{}, nil
+		},
+		DeviceConnector: func(profile, registryID string) (device.HTTPIotDeviceConnectorInterface, error) {
+			return deviceConnector, nil
+		},
+		MQTTConnector: func(profile, registryID, deviceID string) (device.MQTTIotDeviceConnectorInterface, error) {
+			return suite.mqtt, nil
+		},
+		AuditLog: func(profile string) (*audit.Log, error) {
+			return audit.NewLog(suite.audit), nil
+		},
+	}
+}
+
+func (suite *CliTestSuite) TearDownTest() {
+	viper.Reset()
+}
+
+func (suite *CliTestSuite) TestRegistryListJSON() {
+	code := suite.app.Run([]string{"registry", "list", "-o", "json"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+
+	var registries []cloudiot.DeviceRegistry
+	assert.NoError(suite.T(), json.Unmarshal(suite.stdout.Bytes(), &registries), "UnexpectedError")
+	assert.Len(suite.T(), registries, 2)
+	assert.Equal(suite.T(), "registry-b", registries[1].Id)
+}
+
+func (suite *CliTestSuite) TestRegistryListTable() {
+	code := suite.app.Run([]string{"registry", "list"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "registry-a")
+}
+
+func (suite *CliTestSuite) TestNotFoundExitCode() {
+	code := suite.app.Run([]string{"registry", "get", "missing"})
+	assert.Equal(suite.T(), cli.ExitNotFound, code)
+	assert.Contains(suite.T(), suite.stderr.String(), "not found")
+}
+
+func (suite *CliTestSuite) TestUnknownCommand() {
+	assert.Equal(suite.T(), cli.ExitUsage, suite.app.Run([]string{"registry", "rename"}))
+	assert.Equal(suite.T(), cli.ExitUsage, suite.app.Run([]string{"gateway"}))
+}
+
+func (suite *CliTestSuite) TestMissingRegistry() {
+	code := suite.app.Run([]string{"device", "list"})
+	assert.Equal(suite.T(), cli.ExitUsage, code)
+	assert.Contains(suite.T(), suite.stderr.String(), "--registry")
+}
+
+func (suite *CliTestSuite) TestDevicePatch() {
+	code := suite.app.Run([]string{"device", "patch", "device-1", "--registry", "registry-a", "--blocked", "true", "--metadata", "site=lab", "-o", "yaml"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "blocked: true")
+	assert.Contains(suite.T(), suite.stdout.String(), "site: lab")
+
+	code = suite.app.Run([]string{"device", "patch", "device-1", "--registry", "registry-a", "--blocked", "maybe"})
+	assert.Equal(suite.T(), cli.ExitUsage, code)
+}
+
+func (suite *CliTestSuite) TestDeviceConfigSetFromStdin() {
+	code := suite.app.Run([]string{"device", "config", "set", "device-1", "--registry", "registry-a", "--file", "-", "-o", "json"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "\"version\": \"2\"")
+}
+
+func (suite *CliTestSuite) TestPublishStdinLinesAsState() {
+	suite.app.Stdin = bytes.NewBufferString("{\"temp\":20}\n\n{\"temp\":21}\n")
+	code := suite.app.Run([]string{"publish", "device-1", "--registry", "registry-a", "--state", "--qos", "0"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Equal(suite.T(), []string{"state", "state"}, suite.mqtt.topics)
+	assert.Equal(suite.T(), []string{"{\"temp\":20}", "{\"temp\":21}"}, suite.mqtt.messages)
+	assert.Equal(suite.T(), connectors.AtMostOnce, suite.mqtt.qos[0])
+}
+
+func (suite *CliTestSuite) TestPublishTelemetry() {
+	code := suite.app.Run([]string{"publish", "device-1", "hello", "--registry", "registry-a", "--subfolder", "alerts"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Equal(suite.T(), []string{"events/alerts"}, suite.mqtt.topics)
+
+	event, err := telemetry.Decode([]byte(suite.mqtt.messages[0]))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), "hello", string(event.Payload))
+	assert.Contains(suite.T(), suite.stdout.String(), "events/alerts")
+
+	code = suite.app.Run([]string{"publish", "device-1", "hello", "--registry", "registry-a", "--state", "--subfolder", "alerts"})
+	assert.Equal(suite.T(), cli.ExitUsage, code)
+}
+
+func (suite *CliTestSuite) TestListen() {
+	code := suite.app.Run([]string{"listen", "device-1", "--registry", "registry-a", "--count", "2", "--duration", "5s", "-o", "json"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "\"kind\":\"config\"")
+	assert.Contains(suite.T(), suite.stdout.String(), "\"subfolder\":\"reboot\"")
+}
+
+func (suite *CliTestSuite) TestRecordAndReplay() {
+	dir, err := ioutil.TempDir("", "cli")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.jsonl")
+
+	suite.app.Stdin = bytes.NewBufferString("first\nsecond\n")
+	code := suite.app.Run([]string{"publish", "device-1", "--registry", "registry-a", "--raw", "--record", path})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+
+	suite.mqtt.topics, suite.mqtt.messages = nil, nil
+	code = suite.app.Run([]string{"replay", path, "--registry", "registry-a", "--speed", "0", "-o", "json"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Equal(suite.T(), []string{"first", "second"}, suite.mqtt.messages)
+	assert.Contains(suite.T(), suite.stdout.String(), "\"published\": 2")
+}
+
+func (suite *CliTestSuite) TestDeviceTwin() {
+	code := suite.app.Run([]string{"device", "twin", "device-1", "--registry", "registry-a"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "stale")
+	assert.Contains(suite.T(), suite.stdout.String(), "fan different")
+}
+
+func (suite *CliTestSuite) TestDeviceConfigDiff() {
+	code := suite.app.Run([]string{"device", "config", "diff", "device-1", "3", "--registry", "registry-a"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "--- version 3")
+	assert.Contains(suite.T(), suite.stdout.String(), "+++ version 4")
+	assert.Contains(suite.T(), suite.stdout.String(), "-  \"fan\": \"off\"")
+	assert.Contains(suite.T(), suite.stdout.String(), "+  \"fan\": \"on\"")
+
+	code = suite.app.Run([]string{"device", "config", "diff", "device-1", "1", "--registry", "registry-a"})
+	assert.Equal(suite.T(), cli.ExitError, code)
+}
+
+func (suite *CliTestSuite) TestDeviceConfigRollback() {
+	code := suite.app.Run([]string{"device", "config", "rollback", "device-1", "3", "--registry", "registry-a"})
+	assert.Equal(suite.T(), cli.ExitUsage, code)
+
+	code = suite.app.Run([]string{"device", "config", "rollback", "device-1", "3", "--registry", "registry-a",
+		"--reason", "fan noise", "--actor", "oncall", "-o", "json"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "\"version\": \"5\"")
+
+	entries, err := audit.Read(suite.audit)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), entries, 1)
+	assert.Equal(suite.T(), "oncall", entries[0].Actor)
+	assert.Equal(suite.T(), "fan noise", entries[0].Reason)
+	assert.Equal(suite.T(), "registry-a", entries[0].Registry)
+	assert.Equal(suite.T(), "3", entries[0].Details["toVersion"])
+}
+
+func (suite *CliTestSuite) TestDeviceProvision() {
+	dir, err := ioutil.TempDir("", "provision")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "batch.csv")
+	assert.NoError(suite.T(), ioutil.WriteFile(manifest, []byte("id,generateKey\nsensor-1,true\nsensor-2,true\n"), 0644), "UnexpectedError")
+
+	code := suite.app.Run([]string{"device", "provision", manifest, "--registry", "registry-a", "--key-dir", filepath.Join(dir, "keys"), "-o", "json"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "\"created\": 1")
+	assert.Contains(suite.T(), suite.stdout.String(), "\"existing\": 1")
+	assert.FileExists(suite.T(), filepath.Join(dir, "batch.results.jsonl"))
+	assert.FileExists(suite.T(), filepath.Join(dir, "keys", "sensor-1_private.pem"))
+}
+
+func (suite *CliTestSuite) TestInventoryExport() {
+	code := suite.app.Run([]string{"inventory", "export", "--registry", "registry-a", "--format", "csv"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.Contains(suite.T(), suite.stdout.String(), "snapshotTime,registry,deviceId")
+	assert.Contains(suite.T(), suite.stdout.String(), ",registry-a,device-1,")
+
+	suite.stdout.Reset()
+	code = suite.app.Run([]string{"inventory", "export", "--registry", "registry-a", "--format", "parquet"})
+	assert.Equal(suite.T(), cli.ExitOK, code, suite.stderr.String())
+	assert.True(suite.T(), bytes.HasPrefix(suite.stdout.Bytes(), []byte("PAR1")))
+
+	code = suite.app.Run([]string{"inventory", "export", "--format", "avro"})
+	assert.Equal(suite.T(), cli.ExitUsage, code)
+	assert.Contains(suite.T(), suite.stderr.String(), "must be jsonl, csv or parquet")
+}
+
+func TestCliTestSuite(t *testing.T) {
+	suite.Run(t, new(CliTestSuite))
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pjgg/iotPlayground/configuration"
+)
+
+func init() {
+	register(
+		&command{
+			path:  "config show",
+			short: "show the effective configuration of the active profile and where each value comes from",
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args); err != nil {
+					return err
+				}
+				return ctx.printer.print(configuration.Settings())
+			},
+		},
+		&command{
+			path:  "config validate",
+			short: "check the configuration of the active profile and report every violation",
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args); err != nil {
+					return err
+				}
+				if _, err := configuration.Load(); err != nil {
+					return err
+				}
+				fmt.Fprintf(ctx.app.Stdout, "configuration of profile %q is valid\n", ctx.profile)
+				return nil
+			},
+		},
+	)
+}
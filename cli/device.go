@@ -0,0 +1,392 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pjgg/iotPlayground/audit"
+	"github.com/pjgg/iotPlayground/confighistory"
+	"github.com/pjgg/iotPlayground/twin"
+	"github.com/spf13/pflag"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+func registryFlag(flags *pflag.FlagSet) {
+	flags.String("registry", "", "registry of the device (required)")
+}
+
+func init() {
+	register(
+		&command{
+			path:  "device create",
+			args:  "<deviceID>",
+			short: "create a device authenticated by the configured device public key",
+			flags: registryFlag,
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<deviceID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				device, err := connector.CreateDevice(args[0])
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(device)
+			},
+		},
+		&command{
+			path:  "device get",
+			args:  "<deviceID>",
+			short: "show a device",
+			flags: registryFlag,
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<deviceID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				device, err := connector.GetDevice(args[0])
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(device)
+			},
+		},
+		&command{
+			path:  "device list",
+			short: "list the devices of a registry",
+			flags: registryFlag,
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args); err != nil {
+					return err
+				}
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				devices, err := connector.ListDevices()
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(devices)
+			},
+		},
+		&command{
+			path:  "device delete",
+			args:  "<deviceID>",
+			short: "delete a device",
+			flags: registryFlag,
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<deviceID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				if _, err = connector.DeleteDevice(args[0]); err != nil {
+					return err
+				}
+				return ctx.printer.print(deleted{Kind: "device", ID: args[0]})
+			},
+		},
+		&command{
+			path:  "device patch",
+			args:  "<deviceID>",
+			short: "block or unblock a device and replace its metadata",
+			flags: func(flags *pflag.FlagSet) {
+				registryFlag(flags)
+				flags.String("blocked", "", "true to refuse the device connections, false to accept them again")
+				flags.StringArray("metadata", nil, "key=value metadata, replaces the whole device metadata")
+			},
+			run: devicePatch,
+		},
+		&command{
+			path:  "device config set",
+			args:  "<deviceID>",
+			short: "push a new config version, from --data or --file (- for stdin)",
+			flags: func(flags *pflag.FlagSet) {
+				registryFlag(flags)
+				flags.String("data", "", "config content")
+				flags.String("file", "", "file holding the config content, - for stdin")
+			},
+			run: deviceConfigSet,
+		},
+		&command{
+			path:  "device config history",
+			args:  "<deviceID>",
+			short: "list the latest config versions of a device",
+			flags: registryFlag,
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<deviceID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				configs, err := connector.GetDeviceConfigs(args[0])
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(configs)
+			},
+		},
+		&command{
+			path:  "device config diff",
+			args:  "<deviceID> <fromVersion> [toVersion]",
+			short: "diff two config versions, pretty printed when JSON, toVersion defaults to the latest",
+			flags: registryFlag,
+			run:   deviceConfigDiff,
+		},
+		&command{
+			path:  "device config rollback",
+			args:  "<deviceID> <version>",
+			short: "push the content of an old config version again, recorded in the audit log",
+			flags: func(flags *pflag.FlagSet) {
+				registryFlag(flags)
+				flags.String("reason", "", "why the device is rolled back, recorded in the audit log (required)")
+				flags.String("actor", "", "who rolls back, "+audit.ActorEnv+" ENV or user@host by default")
+			},
+			run: deviceConfigRollback,
+		},
+		&command{
+			path:  "device twin",
+			args:  "<deviceID>",
+			short: "compare the latest config (desired) with the latest state (reported) of a device",
+			flags: func(flags *pflag.FlagSet) {
+				registryFlag(flags)
+				flags.Duration("stale-after", 0, "report devices without state for longer as stale, disabled when zero")
+				flags.StringArray("ignore", nil, "reported path left out of the comparison, e.g. agent")
+			},
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<deviceID>"); err != nil {
+					return err
+				}
+				options := twin.Options{}
+				options.StaleAfter, _ = ctx.flags.GetDuration("stale-after")
+				options.Ignore, _ = ctx.flags.GetStringArray("ignore")
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				deviceTwin, err := twin.Get(connector, args[0], options)
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(deviceTwin)
+			},
+		},
+		&command{
+			path:  "device states",
+			args:  "<deviceID>",
+			short: "list the latest states reported by a device",
+			flags: registryFlag,
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<deviceID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.devices()
+				if err != nil {
+					return err
+				}
+				states, err := connector.GetDeviceStates(args[0])
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(states)
+			},
+		},
+	)
+}
+
+func devicePatch(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<deviceID>"); err != nil {
+		return err
+	}
+
+	newDevice := &cloudiot.Device{}
+	var fields []string
+
+	if blocked, _ := ctx.flags.GetString("blocked"); len(blocked) > 0 {
+		value, err := strconv.ParseBool(blocked)
+		if err != nil {
+			return usagef("--blocked must be true or false, got %q", blocked)
+		}
+		newDevice.Blocked = value
+		newDevice.ForceSendFields = append(newDevice.ForceSendFields, "Blocked")
+		fields = append(fields, "blocked")
+	}
+	if metadata, _ := ctx.flags.GetStringArray("metadata"); len(metadata) > 0 {
+		newDevice.Metadata = map[string]string{}
+		for _, entry := range metadata {
+			pair := strings.SplitN(entry, "=", 2)
+			if len(pair) != 2 || len(pair[0]) == 0 {
+				return usagef("--metadata must be key=value, got %q", entry)
+			}
+			newDevice.Metadata[pair[0]] = pair[1]
+		}
+		fields = append(fields, "metadata")
+	}
+
+	if len(fields) == 0 {
+		return usagef("nothing to patch, set --blocked or --metadata")
+	}
+
+	connector, err := ctx.devices()
+	if err != nil {
+		return err
+	}
+	device, err := connector.PatchDevice(args[0], newDevice, strings.Join(fields, ","))
+	if err != nil {
+		return err
+	}
+	return ctx.printer.print(device)
+}
+
+func deviceConfigSet(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<deviceID>"); err != nil {
+		return err
+	}
+
+	data, _ := ctx.flags.GetString("data")
+	file, _ := ctx.flags.GetString("file")
+	switch {
+	case len(data) > 0 && len(file) > 0:
+		return usagef("--data and --file are exclusive")
+	case file == "-":
+		content, err := ioutil.ReadAll(ctx.app.Stdin)
+		if err != nil {
+			return err
+		}
+		data = string(content)
+	case len(file) > 0:
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		data = string(content)
+	case len(data) == 0:
+		return usagef("missing config content, set --data or --file")
+	}
+
+	connector, err := ctx.devices()
+	if err != nil {
+		return err
+	}
+	config, err := connector.SetDeviceConfig(args[0], data)
+	if err != nil {
+		return err
+	}
+	return ctx.printer.print(config)
+}
+
+// configDiff is the result of device config diff.
+type configDiff struct {
+	From int64  `json:"from"`
+	To   int64  `json:"to"`
+	Diff string `json:"diff"`
+}
+
+func parseVersion(value string) (int64, error) {
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, usagef("config versions are positive numbers, got %q", value)
+	}
+	return version, nil
+}
+
+func deviceConfigDiff(ctx *commandContext, args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return usagef("expected argument(s) <deviceID> <fromVersion> [toVersion], got %d", len(args))
+	}
+	result := configDiff{}
+	var err error
+	if result.From, err = parseVersion(args[1]); err != nil {
+		return err
+	}
+	if len(args) == 3 {
+		if result.To, err = parseVersion(args[2]); err != nil {
+			return err
+		}
+	}
+
+	connector, err := ctx.devices()
+	if err != nil {
+		return err
+	}
+	configs, err := connector.GetDeviceConfigs(args[0])
+	if err != nil {
+		return err
+	}
+	from, err := confighistory.Find(configs, result.From)
+	if err != nil {
+		return err
+	}
+	to, err := confighistory.Find(configs, result.To)
+	if err != nil {
+		return err
+	}
+	result.To = to.Version
+	if result.Diff, err = confighistory.Diff(from, to); err != nil {
+		return err
+	}
+
+	if ctx.printer.format != Table {
+		return ctx.printer.print(result)
+	}
+	if len(result.Diff) == 0 {
+		_, err = fmt.Fprintf(ctx.printer.writer, "versions %d and %d have the same content\n", result.From, result.To)
+		return err
+	}
+	_, err = fmt.Fprint(ctx.printer.writer, result.Diff)
+	return err
+}
+
+func deviceConfigRollback(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<deviceID>", "<version>"); err != nil {
+		return err
+	}
+	version, err := parseVersion(args[1])
+	if err != nil {
+		return err
+	}
+	reason, err := ctx.requireFlag("reason")
+	if err != nil {
+		return err
+	}
+	actor, _ := ctx.flags.GetString("actor")
+	if len(actor) == 0 {
+		actor = audit.DefaultActor()
+	}
+
+	connector, err := ctx.devices()
+	if err != nil {
+		return err
+	}
+	auditLog, err := ctx.app.AuditLog(ctx.profile)
+	if err != nil {
+		return err
+	}
+	defer auditLog.Close()
+
+	registryID, _ := ctx.flags.GetString("registry")
+	config, err := confighistory.RollBack(connector, auditLog, confighistory.Rollback{
+		Registry: registryID,
+		DeviceID: args[0],
+		Version:  version,
+		Actor:    actor,
+		Reason:   reason,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.printer.print(config)
+}
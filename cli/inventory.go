@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"io"
+	"os"
+
+	"github.com/pjgg/iotPlayground/inventory"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register(&command{
+		path:  "inventory export",
+		short: "write a snapshot of every device of every registry, to stdout or --file",
+		flags: func(flags *pflag.FlagSet) {
+			flags.String("format", "jsonl", "jsonl, csv or parquet")
+			flags.String("file", "", "export file, the report is then printed instead of the records")
+			flags.StringArray("registry", nil, "registry to export, every registry when not set")
+			flags.Int("concurrency", 4, "devices read at once")
+		},
+		run: inventoryExport,
+	})
+}
+
+func inventoryExport(ctx *commandContext, args []string) error {
+	if err := requireArgs(args); err != nil {
+		return err
+	}
+	formatName, _ := ctx.flags.GetString("format")
+	format, err := inventory.ParseFormat(formatName)
+	if err != nil {
+		return usagef("%s", err.Error())
+	}
+	options := inventory.Options{}
+	options.Registries, _ = ctx.flags.GetStringArray("registry")
+	options.Concurrency, _ = ctx.flags.GetInt("concurrency")
+
+	registries, err := ctx.registries()
+	if err != nil {
+		return err
+	}
+	exporter := &inventory.Exporter{
+		Registries: registries,
+		Devices: func(registryID string) (inventory.DeviceConnector, error) {
+			return ctx.app.DeviceConnector(ctx.profile, registryID)
+		},
+	}
+
+	var output io.Writer = ctx.app.Stdout
+	path, _ := ctx.flags.GetString("file")
+	if len(path) > 0 {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	writer, err := inventory.NewWriter(format, output)
+	if err != nil {
+		return err
+	}
+
+	report, err := exporter.Export(writer, options)
+	if len(path) > 0 && report.Registries > 0 {
+		if printErr := ctx.printer.print(report); printErr != nil {
+			return printErr
+		}
+	}
+	return err
+}
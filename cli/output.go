@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/pjgg/iotPlayground/inventory"
+	"github.com/pjgg/iotPlayground/provisioning"
+	"github.com/pjgg/iotPlayground/session"
+	"github.com/pjgg/iotPlayground/simulator"
+	"github.com/pjgg/iotPlayground/twin"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// OutputFormat is how command results are printed.
+type OutputFormat int
+
+const (
+	// Table is a human readable summary.
+	Table OutputFormat = 1 + iota
+	// JSON is the API resource as returned by Cloud IoT.
+	JSON
+	// YAML is the JSON output converted to YAML.
+	YAML
+)
+
+var outputFormatName = [...]string{
+	"table",
+	"json",
+	"yaml",
+}
+
+func (format OutputFormat) String() string {
+	return outputFormatName[format-1]
+}
+
+// ParseOutputFormat returns the OutputFormat of a name, case insensitive.
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	for i, formatName := range outputFormatName {
+		if strings.EqualFold(formatName, name) {
+			return OutputFormat(i + 1), nil
+		}
+	}
+	return 0, usagef("unknown output format %q, must be table, json or yaml", name)
+}
+
+// deleted is the result of delete commands.
+type deleted struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+}
+
+type printer struct {
+	format OutputFormat
+	writer io.Writer
+}
+
+func newPrinter(name string, writer io.Writer) (*printer, error) {
+	format, err := ParseOutputFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &printer{format: format, writer: writer}, nil
+}
+
+// print write a result in the printer format, tables are only known for the iotctl results.
+func (printer *printer) print(value interface{}) error {
+	switch printer.format {
+	case JSON:
+		encoder := json.NewEncoder(printer.writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case YAML:
+		// going through JSON keeps the API field names and omits empty fields
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err = yaml.Unmarshal(jsonBytes, &generic); err != nil {
+			return err
+		}
+		yamlBytes, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = printer.writer.Write(yamlBytes)
+		return err
+	}
+
+	headers, rows := tableOf(value)
+	if headers == nil {
+		return fmt.Errorf("no table output for %T, use -o json or -o yaml", value)
+	}
+
+	table := tabwriter.NewWriter(printer.writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
+
+func tableOf(value interface{}) (headers []string, rows [][]string) {
+	switch typed := value.(type) {
+	case *cloudiot.DeviceRegistry:
+		return tableOf([]*cloudiot.DeviceRegistry{typed})
+	case []*cloudiot.DeviceRegistry:
+		headers = []string{"ID", "MQTT", "HTTP", "TOPICS", "CA_CERTIFICATES"}
+		for _, registry := range typed {
+			var mqttState, httpState string
+			if registry.MqttConfig != nil {
+				mqttState = registry.MqttConfig.MqttEnabledState
+			}
+			if registry.HttpConfig != nil {
+				httpState = registry.HttpConfig.HttpEnabledState
+			}
+			topics := []string{}
+			for _, config := range registry.EventNotificationConfigs {
+				topics = append(topics, config.PubsubTopicName)
+			}
+			rows = append(rows, []string{registry.Id, mqttState, httpState, strings.Join(topics, ","), strconv.Itoa(len(registry.Credentials))})
+		}
+	case *cloudiot.Device:
+		return tableOf([]*cloudiot.Device{typed})
+	case []*cloudiot.Device:
+		headers = []string{"ID", "NUM_ID", "BLOCKED", "CREDENTIALS", "LAST_HEARTBEAT", "LAST_EVENT", "LAST_STATE"}
+		for _, device := range typed {
+			rows = append(rows, []string{device.Id, strconv.FormatUint(device.NumId, 10), strconv.FormatBool(device.Blocked),
+				strconv.Itoa(len(device.Credentials)), device.LastHeartbeatTime, device.LastEventTime, device.LastStateTime})
+		}
+	case *cloudiot.DeviceConfig:
+		return tableOf([]*cloudiot.DeviceConfig{typed})
+	case []*cloudiot.DeviceConfig:
+		headers = []string{"VERSION", "CLOUD_UPDATE", "DEVICE_ACK", "DATA"}
+		for _, config := range typed {
+			rows = append(rows, []string{strconv.FormatInt(config.Version, 10), config.CloudUpdateTime, config.DeviceAckTime, displayData(config.BinaryData)})
+		}
+	case []*cloudiot.DeviceState:
+		headers = []string{"UPDATE_TIME", "DATA"}
+		for _, state := range typed {
+			rows = append(rows, []string{state.UpdateTime, displayData(state.BinaryData)})
+		}
+	case *cloudiot.Policy:
+		headers = []string{"ROLE", "MEMBERS"}
+		for _, binding := range typed.Bindings {
+			rows = append(rows, []string{binding.Role, strings.Join(binding.Members, ",")})
+		}
+	case []configuration.Setting:
+		headers = []string{"KEY", "VALUE", "SOURCE"}
+		for _, setting := range typed {
+			rows = append(rows, []string{setting.Key, setting.Value, setting.Source.String()})
+		}
+	case []published:
+		headers = []string{"DEVICE", "TOPIC", "QOS", "BYTES"}
+		for _, message := range typed {
+			rows = append(rows, []string{message.Device, message.Topic, strconv.Itoa(message.QoS), strconv.Itoa(message.Bytes)})
+		}
+	case simulator.Report:
+		headers = []string{"METRIC", "VALUE"}
+		rows = [][]string{
+			{"elapsed", typed.Elapsed.String()},
+			{"connects", strconv.FormatUint(typed.Connects, 10)},
+			{"connect errors", strconv.FormatUint(typed.ConnectErrors, 10)},
+			{"disconnects", strconv.FormatUint(typed.Disconnects, 10)},
+			{"published", strconv.FormatUint(typed.Published, 10)},
+			{"publish errors", strconv.FormatUint(typed.PublishErrors, 10)},
+			{"bytes", strconv.FormatUint(typed.Bytes, 10)},
+			{"throughput", strconv.FormatFloat(typed.Throughput, 'f', 1, 64) + "/s"},
+			{"latency p50", typed.LatencyP50.String()},
+			{"latency p95", typed.LatencyP95.String()},
+			{"latency p99", typed.LatencyP99.String()},
+			{"latency max", typed.LatencyMax.String()},
+		}
+		for message, count := range typed.Errors {
+			rows = append(rows, []string{"error " + message, strconv.FormatUint(count, 10)})
+		}
+	case session.ReplayReport:
+		headers = []string{"PUBLISHED", "CONFIGS", "SKIPPED", "ELAPSED"}
+		rows = [][]string{{strconv.Itoa(typed.Published), strconv.Itoa(typed.Configs), strconv.Itoa(typed.Skipped), typed.Elapsed.String()}}
+	case inventory.Report:
+		headers = []string{"SNAPSHOT", "REGISTRIES", "DEVICES", "FAILED", "ELAPSED"}
+		rows = [][]string{{displayTime(typed.SnapshotTime), strconv.Itoa(typed.Registries), strconv.Itoa(typed.Devices),
+			strconv.Itoa(typed.Failed), typed.Elapsed.String()}}
+	case provisioning.Report:
+		headers = []string{"CREATED", "EXISTING", "SKIPPED", "FAILED", "ELAPSED"}
+		rows = [][]string{{strconv.Itoa(typed.Created), strconv.Itoa(typed.Existing), strconv.Itoa(typed.Skipped),
+			strconv.Itoa(typed.Failed), typed.Elapsed.String()}}
+	case *twin.Twin:
+		headers = []string{"DEVICE", "STATUS", "DESIRED_VERSION", "CONFIG_ACK", "LAST_STATE", "DIFF"}
+		changes := []string{}
+		for _, change := range typed.Diff {
+			changes = append(changes, change.Path+" "+change.Kind.String())
+		}
+		rows = [][]string{{typed.DeviceID, typed.Status.String(), strconv.FormatInt(typed.DesiredVersion, 10),
+			displayTime(typed.LastConfigAckTime), displayTime(typed.LastStateTime), strings.Join(changes, ", ")}}
+	case deleted:
+		headers = []string{"DELETED", "ID"}
+		rows = [][]string{{typed.Kind, typed.ID}}
+	}
+
+	return
+}
+
+func displayTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Format(time.RFC3339)
+}
+
+// displayData decode base64 API data, kept encoded when it is not printable text.
+func displayData(data string) string {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || !utf8.Valid(decoded) || strings.ContainsAny(string(decoded), "\x00\t\n\r") {
+		return data
+	}
+	return string(decoded)
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pjgg/iotPlayground/provisioning"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register(&command{
+		path:  "device provision",
+		args:  "<manifest.yaml|manifest.csv>",
+		short: "create the devices of a manifest, run it again with the same results file to resume",
+		flags: func(flags *pflag.FlagSet) {
+			registryFlag(flags)
+			flags.Int("concurrency", 4, "devices created at once")
+			flags.Float64("rate", 10, "devices created per second, 0 for unlimited")
+			flags.String("key-dir", "keys", "directory of the generated key pairs")
+			flags.String("results", "", "JSONL per device results, <manifest>.results.jsonl by default")
+		},
+		run: deviceProvision,
+	})
+}
+
+func deviceProvision(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<manifest>"); err != nil {
+		return err
+	}
+
+	manifest, err := provisioning.LoadManifest(args[0])
+	if err != nil {
+		return err
+	}
+	options := provisioning.Options{}
+	options.Concurrency, _ = ctx.flags.GetInt("concurrency")
+	options.Rate, _ = ctx.flags.GetFloat64("rate")
+	options.KeyDir, _ = ctx.flags.GetString("key-dir")
+	options.ResultsPath, _ = ctx.flags.GetString("results")
+	if len(options.ResultsPath) == 0 {
+		options.ResultsPath = strings.TrimSuffix(args[0], filepath.Ext(args[0])) + ".results.jsonl"
+	}
+
+	connector, err := ctx.devices()
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-interrupt:
+			close(stop)
+		case <-finished:
+		}
+	}()
+
+	report, err := provisioning.Provision(connector, manifest, options, stop)
+	if err != nil {
+		return err
+	}
+	if err = ctx.printer.print(report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d device(s) failed, see %s and run again to retry them", report.Failed, options.ResultsPath)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/spf13/pflag"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+func init() {
+	register(
+		&command{
+			path:  "registry create",
+			args:  "<registryID>",
+			short: "create a registry, publishing telemetry to --topic and trusting --ca-cert device certificates",
+			flags: func(flags *pflag.FlagSet) {
+				flags.String("topic", "", "Pub/Sub topic name receiving the device telemetry")
+				flags.StringArray("ca-cert", nil, "CA certificate PEM file, devices must present certificates it signed")
+			},
+			run: registryCreate,
+		},
+		&command{
+			path:  "registry get",
+			args:  "<registryID>",
+			short: "show a registry",
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<registryID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.registries()
+				if err != nil {
+					return err
+				}
+				registry, err := connector.GetRegistry(args[0])
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(registry)
+			},
+		},
+		&command{
+			path:  "registry list",
+			short: "list the registries of the project region",
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args); err != nil {
+					return err
+				}
+				connector, err := ctx.registries()
+				if err != nil {
+					return err
+				}
+				registries, err := connector.ListRegistries()
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(registries)
+			},
+		},
+		&command{
+			path:  "registry delete",
+			args:  "<registryID>",
+			short: "delete an empty registry",
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<registryID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.registries()
+				if err != nil {
+					return err
+				}
+				if _, err = connector.DeleteRegistry(args[0]); err != nil {
+					return err
+				}
+				return ctx.printer.print(deleted{Kind: "registry", ID: args[0]})
+			},
+		},
+		&command{
+			path:  "registry patch",
+			args:  "<registryID>",
+			short: "update the telemetry topic or enable and disable the MQTT and HTTP bridges",
+			flags: func(flags *pflag.FlagSet) {
+				flags.String("topic", "", "Pub/Sub topic name receiving the device telemetry")
+				flags.String("mqtt", "", "enabled or disabled")
+				flags.String("http", "", "enabled or disabled")
+			},
+			run: registryPatch,
+		},
+		&command{
+			path:  "registry iam get",
+			args:  "<registryID>",
+			short: "show the registry IAM policy",
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<registryID>"); err != nil {
+					return err
+				}
+				connector, err := ctx.registries()
+				if err != nil {
+					return err
+				}
+				policy, err := connector.GetRegistryIam(args[0])
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(policy)
+			},
+		},
+		&command{
+			path:  "registry iam set",
+			args:  "<registryID>",
+			short: "replace the registry IAM policy with a single --role binding of --member",
+			flags: func(flags *pflag.FlagSet) {
+				flags.String("member", "", "member, e.g. user:jane@example.com or group:admins@example.com")
+				flags.String("role", "", "role, e.g. roles/viewer")
+			},
+			run: func(ctx *commandContext, args []string) error {
+				if err := requireArgs(args, "<registryID>"); err != nil {
+					return err
+				}
+				member, err := ctx.requireFlag("member")
+				if err != nil {
+					return err
+				}
+				role, err := ctx.requireFlag("role")
+				if err != nil {
+					return err
+				}
+				connector, err := ctx.registries()
+				if err != nil {
+					return err
+				}
+				policy, err := connector.SetRegistryIam(args[0], member, role)
+				if err != nil {
+					return err
+				}
+				return ctx.printer.print(policy)
+			},
+		},
+	)
+}
+
+func registryCreate(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<registryID>"); err != nil {
+		return err
+	}
+	topic, _ := ctx.flags.GetString("topic")
+	caCerts, _ := ctx.flags.GetStringArray("ca-cert")
+
+	connector, err := ctx.registries()
+	if err != nil {
+		return err
+	}
+
+	var config []*cloudiot.EventNotificationConfig
+	if len(topic) > 0 {
+		config = []*cloudiot.EventNotificationConfig{{PubsubTopicName: connector.GenerateTopicName(topic)}}
+	}
+
+	var registry *cloudiot.DeviceRegistry
+	if len(caCerts) > 0 {
+		registry, err = connector.CreateRegistryWithCredentials(args[0], config, caCerts)
+	} else {
+		registry, err = connector.CreateRegistry(args[0], config)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.printer.print(registry)
+}
+
+func registryPatch(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<registryID>"); err != nil {
+		return err
+	}
+
+	newRegistry := &cloudiot.DeviceRegistry{}
+	var fields []string
+
+	connector, err := ctx.registries()
+	if err != nil {
+		return err
+	}
+
+	if topic, _ := ctx.flags.GetString("topic"); len(topic) > 0 {
+		newRegistry.EventNotificationConfigs = []*cloudiot.EventNotificationConfig{{PubsubTopicName: connector.GenerateTopicName(topic)}}
+		fields = append(fields, "event_notification_configs")
+	}
+	if mqtt, _ := ctx.flags.GetString("mqtt"); len(mqtt) > 0 {
+		state, err := enabledState("mqtt", mqtt)
+		if err != nil {
+			return err
+		}
+		newRegistry.MqttConfig = &cloudiot.MqttConfig{MqttEnabledState: "MQTT_" + state}
+		fields = append(fields, "mqtt_config.mqtt_enabled_state")
+	}
+	if http, _ := ctx.flags.GetString("http"); len(http) > 0 {
+		state, err := enabledState("http", http)
+		if err != nil {
+			return err
+		}
+		newRegistry.HttpConfig = &cloudiot.HttpConfig{HttpEnabledState: "HTTP_" + state}
+		fields = append(fields, "http_config.http_enabled_state")
+	}
+
+	if len(fields) == 0 {
+		return usagef("nothing to patch, set --topic, --mqtt or --http")
+	}
+
+	registry, err := connector.PatchRegistry(args[0], newRegistry, strings.Join(fields, ","))
+	if err != nil {
+		return err
+	}
+	return ctx.printer.print(registry)
+}
+
+func enabledState(flag, value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "enabled", "enable", "true":
+		return "ENABLED", nil
+	case "disabled", "disable", "false":
+		return "DISABLED", nil
+	}
+	return "", usagef("--%s must be enabled or disabled, got %q", flag, value)
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/session"
+	"github.com/spf13/pflag"
+)
+
+func recordFlag(flags *pflag.FlagSet) {
+	flags.String("record", "", "append the device session to this JSONL file, replayable with iotctl replay")
+}
+
+func init() {
+	register(&command{
+		path:  "replay",
+		args:  "<session.jsonl>",
+		short: "publish again the telemetry and states of a recorded device session",
+		flags: func(flags *pflag.FlagSet) {
+			registryFlag(flags)
+			flags.String("device", "", "replay as this device, the recorded device by default")
+			flags.Float64("speed", 1, "1 replays in real time, 10 ten times faster, 0 without any delay")
+			flags.Bool("configs", false, "push the recorded configs again through the admin API")
+		},
+		run: replay,
+	})
+}
+
+// recordedMQTT returns the MQTT connector of deviceID, recording its session when --record is set. The
+// returned func closes the session file.
+func (ctx *commandContext) recordedMQTT(deviceID string) (device.MQTTIotDeviceConnectorInterface, func(), error) {
+	connector, err := ctx.mqtt(deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path, _ := ctx.flags.GetString("record")
+	if len(path) == 0 {
+		return connector, func() {}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session.NewRecorder(connector, file), func() {
+		if err := file.Close(); err != nil {
+			log.Errorln(err.Error())
+		}
+	}, nil
+}
+
+func replay(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<session.jsonl>"); err != nil {
+		return err
+	}
+	speed, _ := ctx.flags.GetFloat64("speed")
+	if speed < 0 {
+		return usagef("--speed can not be negative, got %g", speed)
+	}
+	options := session.ReplayOptions{Speed: speed}
+	options.Device, _ = ctx.flags.GetString("device")
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the MQTT connection is made as the first recorded device when --device is not set
+	deviceID := options.Device
+	if len(deviceID) == 0 {
+		first, err := session.NewReader(file).Next()
+		if err != nil {
+			return err
+		}
+		deviceID = first.Device
+		if _, err = file.Seek(0, 0); err != nil {
+			return err
+		}
+	}
+
+	if configs, _ := ctx.flags.GetBool("configs"); configs {
+		if options.Configs, err = ctx.devices(); err != nil {
+			return err
+		}
+	}
+	connector, err := ctx.mqtt(deviceID)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	go func() {
+		if _, interrupted := <-interrupt; interrupted {
+			close(stop)
+		}
+	}()
+	options.Stop = stop
+
+	report, err := session.Replay(session.NewReader(file), connector, options)
+	if err != nil {
+		return err
+	}
+	return ctx.printer.print(report)
+}
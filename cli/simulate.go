@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pjgg/iotPlayground/simulator"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register(&command{
+		path:  "simulate",
+		args:  "<scenario.yaml>",
+		short: "run a fleet of virtual devices from a scenario file and report throughput, latency and errors",
+		flags: func(flags *pflag.FlagSet) {
+			flags.String("registry", "", "registry of the devices, overrides the scenario registry")
+			flags.Duration("duration", 0, "run duration, overrides the scenario duration")
+			flags.Duration("progress", 10*time.Second, "interval of the progress lines written to stderr, 0 disables them")
+		},
+		run:   simulate,
+		watch: true,
+	})
+}
+
+func simulate(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<scenario.yaml>"); err != nil {
+		return err
+	}
+
+	scenario, err := simulator.LoadScenario(args[0])
+	if err != nil {
+		return err
+	}
+	if registryID, _ := ctx.flags.GetString("registry"); len(registryID) > 0 {
+		scenario.Registry = registryID
+	}
+	if duration, _ := ctx.flags.GetDuration("duration"); duration > 0 {
+		scenario.Duration = duration
+	}
+	profile := scenario.Profile
+	if len(profile) == 0 {
+		profile = ctx.profile
+	}
+
+	sim, err := simulator.New(scenario, simulator.ProfileDialer(profile, scenario.Registry))
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	finished := make(chan struct{})
+	defer close(finished)
+
+	go func() {
+		var ticks <-chan time.Time
+		if progress, _ := ctx.flags.GetDuration("progress"); progress > 0 {
+			ticker := time.NewTicker(progress)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+		for {
+			select {
+			case <-ticks:
+				fmt.Fprintln(ctx.app.Stderr, sim.Stats().Report().String())
+			case <-interrupt:
+				close(stop)
+				return
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	return ctx.printer.print(sim.Run(stop))
+}
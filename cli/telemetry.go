@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/spf13/pflag"
+)
+
+// published is the result of the publish command, one per message.
+type published struct {
+	Device string `json:"device"`
+	Topic  string `json:"topic"`
+	QoS    int    `json:"qos"`
+	Bytes  int    `json:"bytes"`
+}
+
+// received is a config or command delivered to the listen command.
+type received struct {
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Subfolder string    `json:"subfolder,omitempty"`
+	Data      string    `json:"data"`
+}
+
+func init() {
+	register(
+		&command{
+			path:  "publish",
+			args:  "<deviceID> [message]",
+			short: "publish telemetry or state as a device: the message, --file content or every stdin line",
+			flags: func(flags *pflag.FlagSet) {
+				registryFlag(flags)
+				flags.String("file", "", "file published as a single message, - for the whole stdin")
+				flags.Bool("state", false, "publish a device state instead of telemetry")
+				flags.String("subfolder", "", "telemetry subfolder, events/<subfolder> topic")
+				flags.Int("qos", 1, "MQTT QoS, 0 at most once or 1 at least once")
+				flags.Bool("raw", false, "publish the telemetry payload as is, without the telemetry envelope")
+				recordFlag(flags)
+			},
+			run:   publish,
+			watch: true,
+		},
+		&command{
+			path:  "listen",
+			args:  "<deviceID>",
+			short: "connect as a device and print configs and commands as they arrive, until interrupted",
+			flags: func(flags *pflag.FlagSet) {
+				registryFlag(flags)
+				flags.Duration("duration", 0, "stop listening after this duration, 0 waits for an interrupt")
+				flags.Int("count", 0, "stop listening after this many messages, 0 for no limit")
+				flags.Bool("no-config", false, "do not subscribe to the device config")
+				flags.Bool("no-commands", false, "do not subscribe to the device commands")
+				recordFlag(flags)
+			},
+			run:   listen,
+			watch: true,
+		},
+	)
+}
+
+func publish(ctx *commandContext, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usagef("expected <deviceID> [message], got %d argument(s)", len(args))
+	}
+	deviceID := args[0]
+
+	state, _ := ctx.flags.GetBool("state")
+	subfolder, _ := ctx.flags.GetString("subfolder")
+	raw, _ := ctx.flags.GetBool("raw")
+	if state && (len(subfolder) > 0 || raw) {
+		return usagef("--subfolder and --raw only apply to telemetry")
+	}
+	qosLevel, _ := ctx.flags.GetInt("qos")
+	qos, err := parseQoS(qosLevel)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ctx.messages(args[1:])
+	if err != nil {
+		return err
+	}
+
+	connector, closeRecord, err := ctx.recordedMQTT(deviceID)
+	if err != nil {
+		return err
+	}
+	defer closeRecord()
+
+	results := []published{}
+	for message := range messages {
+		var token mqtt.Token
+		topic := "state"
+		switch {
+		case state:
+			token = connector.PublishState(deviceID, string(message), qos)
+		case raw:
+			topic = (&telemetry.Telemetry{Subfolder: subfolder}).Topic()
+			token = connector.PublishMsg(deviceID, topic, string(message), qos)
+		default:
+			event := &telemetry.Telemetry{Subfolder: subfolder, Payload: message}
+			topic = event.Topic()
+			if token, err = connector.PublishTelemetry(deviceID, event, qos); err != nil {
+				return err
+			}
+		}
+		token.Wait()
+		if err = token.Error(); err != nil {
+			return err
+		}
+		results = append(results, published{Device: deviceID, Topic: topic, QoS: int(qos.Value()), Bytes: len(message)})
+	}
+
+	return ctx.printer.print(results)
+}
+
+// messages returns the message argument, the --file content or, with neither, every non empty stdin line.
+func (ctx *commandContext) messages(args []string) (<-chan []byte, error) {
+	file, _ := ctx.flags.GetString("file")
+	if len(args) > 0 && len(file) > 0 {
+		return nil, usagef("a message and --file are exclusive")
+	}
+
+	var single []byte
+	switch {
+	case len(args) > 0:
+		single = []byte(args[0])
+	case file == "-":
+		content, err := ioutil.ReadAll(ctx.app.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		single = content
+	case len(file) > 0:
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		single = content
+	}
+
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		if single != nil {
+			messages <- single
+			return
+		}
+		// lines are published as they are read, so a device can be driven interactively
+		scanner := bufio.NewScanner(ctx.app.Stdin)
+		for scanner.Scan() {
+			if line := scanner.Bytes(); len(line) > 0 {
+				messages <- append([]byte(nil), line...)
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+func listen(ctx *commandContext, args []string) error {
+	if err := requireArgs(args, "<deviceID>"); err != nil {
+		return err
+	}
+	deviceID := args[0]
+	duration, _ := ctx.flags.GetDuration("duration")
+	count, _ := ctx.flags.GetInt("count")
+	noConfig, _ := ctx.flags.GetBool("no-config")
+	noCommands, _ := ctx.flags.GetBool("no-commands")
+	if noConfig && noCommands {
+		return usagef("nothing to listen to, --no-config and --no-commands are both set")
+	}
+
+	connector, closeRecord, err := ctx.recordedMQTT(deviceID)
+	if err != nil {
+		return err
+	}
+	defer closeRecord()
+
+	// handlers run on the MQTT client goroutines
+	var mutex sync.Mutex
+	printed := 0
+	done := make(chan struct{})
+	output := &streamPrinter{printer: ctx.printer}
+	handle := func(message received) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if count > 0 && printed >= count {
+			return
+		}
+		if err := output.print(message); err != nil {
+			fmt.Fprintln(ctx.app.Stderr, "error: "+err.Error())
+		}
+		printed++
+		if count > 0 && printed == count {
+			close(done)
+		}
+	}
+
+	if !noConfig {
+		token := connector.SubscribeConfig(deviceID, func(config []byte) {
+			handle(received{Time: time.Now().UTC(), Kind: "config", Data: string(config)})
+		})
+		if token.Wait(); token.Error() != nil {
+			return token.Error()
+		}
+	}
+	if !noCommands {
+		token := connector.SubscribeCommands(deviceID, func(subfolder string, command []byte) {
+			handle(received{Time: time.Now().UTC(), Kind: "command", Subfolder: subfolder, Data: string(command)})
+		})
+		if token.Wait(); token.Error() != nil {
+			return token.Error()
+		}
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-done:
+	case <-timeout:
+	case <-interrupt:
+	}
+
+	return nil
+}
+
+// streamPrinter print results one at a time as they arrive: a table row, a JSON line or a YAML document.
+type streamPrinter struct {
+	printer *printer
+	started bool
+}
+
+func (stream *streamPrinter) print(message received) error {
+	switch stream.printer.format {
+	case JSON:
+		return json.NewEncoder(stream.printer.writer).Encode(message)
+	case YAML:
+		if _, err := io.WriteString(stream.printer.writer, "---\n"); err != nil {
+			return err
+		}
+		return stream.printer.print(message)
+	}
+
+	if !stream.started {
+		stream.started = true
+		fmt.Fprintf(stream.printer.writer, "%-30s  %-7s  %-12s  %s\n", "TIME", "KIND", "SUBFOLDER", "DATA")
+	}
+	_, err := fmt.Fprintf(stream.printer.writer, "%-30s  %-7s  %-12s  %s\n", message.Time.Format(time.RFC3339Nano), message.Kind, message.Subfolder, message.Data)
+	return err
+}
+
+func parseQoS(level int) (connectors.QoS, error) {
+	switch level {
+	case 0:
+		return connectors.AtMostOnce, nil
+	case 1:
+		return connectors.AtLeastOnce, nil
+	}
+	return 0, usagef("--qos must be 0 or 1, got %d", level)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pjgg/iotPlayground/keygen"
+)
+
+func main() {
+	dir := flag.String("out", ".", "directory where the PEM files are written")
+	commonName := flag.String("cn", "iot-device", "certificates common name")
+	days := flag.Int("days", 3650, "certificates validity in days")
+	format := flag.String("format", "pkcs8", "private keys PEM format, pkcs1 or pkcs8")
+	caCert := flag.String("ca-cert", "", "CA certificate signing the device certificates, self-signed when empty")
+	caKey := flag.String("ca-key", "", "CA private key signing the device certificates")
+	caKeyPassphraseEnv := flag.String("ca-key-passphrase-env", "CA_KEY_PASSPHRASE", "ENV holding the passphrase of an encrypted CA private key")
+	flag.Parse()
+
+	request := keygen.DeviceFilesRequest{
+		Dir:        *dir,
+		CommonName: *commonName,
+		Validity:   time.Duration(*days) * 24 * time.Hour,
+		CACertPath: *caCert,
+		CAKeyPath:  *caKey,
+	}
+	if passphrase := os.Getenv(*caKeyPassphraseEnv); len(passphrase) > 0 {
+		request.CAKeyPassphrase = []byte(passphrase)
+	}
+
+	switch strings.ToLower(*format) {
+	case "pkcs1":
+		request.Format = keygen.PKCS1
+	case "pkcs8":
+		request.Format = keygen.PKCS8
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q, must be pkcs1 or pkcs8\n", *format)
+		os.Exit(2)
+	}
+
+	files, err := keygen.GenerateDeviceFiles(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	for _, path := range []string{files.RSAPrivate, files.RSAPublic, files.RSACert, files.ECPrivate, files.ECPublic, files.ECCert} {
+		fmt.Println(path)
+	}
+}
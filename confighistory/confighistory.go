@@ -0,0 +1,192 @@
+package confighistory
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pjgg/iotPlayground/audit"
+	"github.com/pmezard/go-difflib/difflib"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// RollbackAction is the audit log action of a rollback.
+const RollbackAction = "config.rollback"
+
+// ErrVersionNotFound is returned for versions missing from the config history, Cloud IoT only keeps the
+// latest ones.
+var ErrVersionNotFound = errors.New("config version not found in the device history")
+
+// ErrConfigChanged is returned when a config was pushed between reading the history and a rollback.
+var ErrConfigChanged = errors.New("config changed since the device history was read")
+
+// Connector is the part of the device admin connector the config history needs,
+// HTTPIotDeviceConnectorInterface implements it.
+type Connector interface {
+	GetDeviceConfigs(deviceID string) ([]*cloudiot.DeviceConfig, error)
+	SetDeviceConfigVersion(deviceID string, configData string, versionToUpdate int64) (*cloudiot.DeviceConfig, error)
+}
+
+// Find returns a config version, the latest one when version is zero.
+func Find(configs []*cloudiot.DeviceConfig, version int64) (*cloudiot.DeviceConfig, error) {
+	var found *cloudiot.DeviceConfig
+	for _, config := range configs {
+		if (version == 0 && (found == nil || config.Version > found.Version)) || config.Version == version {
+			found = config
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("version %d: %s", version, ErrVersionNotFound.Error())
+	}
+	return found, nil
+}
+
+// Decode returns the content of a config version.
+func Decode(config *cloudiot.DeviceConfig) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(config.BinaryData)
+	if err != nil {
+		return nil, fmt.Errorf("config version %d: %s", config.Version, err.Error())
+	}
+	return data, nil
+}
+
+// Pretty returns the content of a config version, indented with sorted keys when it is JSON and as is
+// otherwise.
+func Pretty(config *cloudiot.DeviceConfig) (string, error) {
+	data, err := Decode(config)
+	if err != nil {
+		return "", err
+	}
+
+	var document interface{}
+	if json.Unmarshal(data, &document) != nil {
+		return string(data), nil
+	}
+	pretty := &bytes.Buffer{}
+	encoder := json.NewEncoder(pretty)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(document); err != nil {
+		return "", err
+	}
+	return pretty.String(), nil
+}
+
+// Diff returns the unified diff of two config versions, empty when their contents are the same.
+func Diff(from, to *cloudiot.DeviceConfig) (string, error) {
+	fromText, err := Pretty(from)
+	if err != nil {
+		return "", err
+	}
+	toText, err := Pretty(to)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        lines(fromText),
+		B:        lines(toText),
+		FromFile: "version " + strconv.FormatInt(from.Version, 10),
+		ToFile:   "version " + strconv.FormatInt(to.Version, 10),
+		Context:  3,
+	})
+}
+
+// lines split a text for difflib, which already ends the last line with a newline.
+func lines(text string) []string {
+	return difflib.SplitLines(strings.TrimSuffix(text, "\n"))
+}
+
+// DiffVersions read the config history of a device and diff two of its versions, zero meaning the latest.
+func DiffVersions(connector Connector, deviceID string, from, to int64) (string, error) {
+	configs, err := connector.GetDeviceConfigs(deviceID)
+	if err != nil {
+		return "", err
+	}
+	fromConfig, err := Find(configs, from)
+	if err != nil {
+		return "", err
+	}
+	toConfig, err := Find(configs, to)
+	if err != nil {
+		return "", err
+	}
+	return Diff(fromConfig, toConfig)
+}
+
+// Rollback is a request to push an old config version again.
+type Rollback struct {
+	Registry string
+	DeviceID string
+	Version  int64
+	Actor    string
+	Reason   string
+}
+
+// RollBack push the content of an old config version as a new version and record it in the audit log.
+// The push only succeeds while the latest version read is still the latest one, a config pushed meanwhile
+// fails the rollback with ErrConfigChanged. The new version is returned with the audit error when the
+// config was pushed but could not be recorded.
+func RollBack(connector Connector, auditLog *audit.Log, rollback Rollback) (*cloudiot.DeviceConfig, error) {
+	if len(rollback.Actor) == 0 || len(rollback.Reason) == 0 {
+		return nil, fmt.Errorf("a rollback requires an actor and a reason")
+	}
+
+	configs, err := connector.GetDeviceConfigs(rollback.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := Find(configs, rollback.Version)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := Find(configs, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := Decode(target)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := connector.SetDeviceConfigVersion(rollback.DeviceID, string(data), latest.Version)
+	if isConflict(err) {
+		return nil, fmt.Errorf("version %d is no longer the latest: %s", latest.Version, ErrConfigChanged.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = auditLog.Record(audit.Entry{
+		Actor:    rollback.Actor,
+		Action:   RollbackAction,
+		Registry: rollback.Registry,
+		Device:   rollback.DeviceID,
+		Reason:   rollback.Reason,
+		Details: map[string]string{
+			"fromVersion": strconv.FormatInt(latest.Version, 10),
+			"toVersion":   strconv.FormatInt(target.Version, 10),
+			"newVersion":  strconv.FormatInt(config.Version, 10),
+		},
+	})
+	if err != nil {
+		return config, fmt.Errorf("version %d pushed but not audited: %s", config.Version, err.Error())
+	}
+	return config, nil
+}
+
+// isConflict tells if the server rejected a config because versionToUpdate is not the latest version.
+func isConflict(err error) bool {
+	apiErr, isAPIErr := err.(*googleapi.Error)
+	if !isAPIErr {
+		return false
+	}
+	return apiErr.Code == http.StatusConflict || apiErr.Code == http.StatusPreconditionFailed ||
+		(apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Body, "FAILED_PRECONDITION"))
+}
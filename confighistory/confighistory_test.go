@@ -0,0 +1,136 @@
+package confighistory_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pjgg/iotPlayground/audit"
+	"github.com/pjgg/iotPlayground/confighistory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+	"google.golang.org/api/googleapi"
+)
+
+type ConfigHistoryTestSuite struct {
+	suite.Suite
+	connector *fakeConnector
+	audit     *bytes.Buffer
+}
+
+type fakeConnector struct {
+	configs []*cloudiot.DeviceConfig
+	pushed  []string
+	err     error
+	// latest is the latest version on the server, it moves ahead of configs on concurrent pushes
+	latest int64
+}
+
+func (fake *fakeConnector) GetDeviceConfigs(deviceID string) ([]*cloudiot.DeviceConfig, error) {
+	return fake.configs, nil
+}
+
+func (fake *fakeConnector) SetDeviceConfigVersion(deviceID string, configData string, versionToUpdate int64) (*cloudiot.DeviceConfig, error) {
+	if fake.err != nil {
+		return nil, fake.err
+	}
+	if versionToUpdate != 0 && versionToUpdate != fake.latest {
+		return nil, &googleapi.Error{Code: 409, Message: "the config version does not match"}
+	}
+	fake.pushed = append(fake.pushed, configData)
+	fake.latest++
+	return &cloudiot.DeviceConfig{Version: fake.latest}, nil
+}
+
+func (suite *ConfigHistoryTestSuite) SetupTest() {
+	suite.audit = &bytes.Buffer{}
+	suite.connector = &fakeConnector{configs: []*cloudiot.DeviceConfig{
+		// {"fan":"on","zones":[1,2]}
+		{Version: 3, BinaryData: "eyJmYW4iOiJvbiIsInpvbmVzIjpbMSwyXX0="},
+		// {"zones":[1],"fan":"off"}
+		{Version: 2, BinaryData: "eyJ6b25lcyI6WzFdLCJmYW4iOiJvZmYifQ=="},
+		// reboot
+		{Version: 1, BinaryData: "cmVib290"},
+	}, latest: 3}
+}
+
+func (suite *ConfigHistoryTestSuite) TestFind() {
+	latest, err := confighistory.Find(suite.connector.configs, 0)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), int64(3), latest.Version)
+
+	_, err = confighistory.Find(suite.connector.configs, 7)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *ConfigHistoryTestSuite) TestPretty() {
+	pretty, err := confighistory.Pretty(suite.connector.configs[1])
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), "{\n  \"fan\": \"off\",\n  \"zones\": [\n    1\n  ]\n}\n", pretty)
+
+	pretty, err = confighistory.Pretty(suite.connector.configs[2])
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), "reboot", pretty)
+}
+
+func (suite *ConfigHistoryTestSuite) TestDiffVersions() {
+	diff, err := confighistory.DiffVersions(suite.connector, "device-1", 2, 0)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), "--- version 2\n+++ version 3\n@@ -1,6 +1,7 @@\n {\n-  \"fan\": \"off\",\n+  \"fan\": \"on\",\n"+
+		"   \"zones\": [\n-    1\n+    1,\n+    2\n   ]\n }\n", diff)
+
+	diff, err = confighistory.DiffVersions(suite.connector, "device-1", 3, 3)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Empty(suite.T(), diff)
+}
+
+func (suite *ConfigHistoryTestSuite) TestRollBack() {
+	config, err := confighistory.RollBack(suite.connector, audit.NewLog(suite.audit), confighistory.Rollback{
+		Registry: "registry-a", DeviceID: "device-1", Version: 2, Actor: "oncall", Reason: "fan noise",
+	})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), int64(4), config.Version)
+	assert.Equal(suite.T(), []string{"{\"zones\":[1],\"fan\":\"off\"}"}, suite.connector.pushed)
+
+	entries, err := audit.Read(suite.audit)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), entries, 1)
+	assert.Equal(suite.T(), confighistory.RollbackAction, entries[0].Action)
+	assert.Equal(suite.T(), "oncall", entries[0].Actor)
+	assert.Equal(suite.T(), "fan noise", entries[0].Reason)
+	assert.Equal(suite.T(), map[string]string{"fromVersion": "3", "toVersion": "2", "newVersion": "4"}, entries[0].Details)
+	assert.False(suite.T(), entries[0].Time.IsZero())
+}
+
+func (suite *ConfigHistoryTestSuite) TestRollBackRequiresReason() {
+	_, err := confighistory.RollBack(suite.connector, audit.NewLog(suite.audit), confighistory.Rollback{DeviceID: "device-1", Version: 2, Actor: "oncall"})
+	assert.Error(suite.T(), err)
+	assert.Empty(suite.T(), suite.connector.pushed)
+}
+
+func (suite *ConfigHistoryTestSuite) TestRollBackConflict() {
+	// a config pushed between reading the history and the rollback
+	suite.connector.latest = 4
+	_, err := confighistory.RollBack(suite.connector, audit.NewLog(suite.audit), confighistory.Rollback{
+		DeviceID: "device-1", Version: 2, Actor: "oncall", Reason: "fan noise",
+	})
+	if assert.Error(suite.T(), err) {
+		assert.Contains(suite.T(), err.Error(), confighistory.ErrConfigChanged.Error())
+	}
+	assert.Empty(suite.T(), suite.connector.pushed)
+	assert.Empty(suite.T(), suite.audit.String())
+}
+
+func (suite *ConfigHistoryTestSuite) TestFailedRollBackIsNotAudited() {
+	suite.connector.err = errors.New("quota exceeded")
+	_, err := confighistory.RollBack(suite.connector, audit.NewLog(suite.audit), confighistory.Rollback{
+		DeviceID: "device-1", Version: 2, Actor: "oncall", Reason: "fan noise",
+	})
+	assert.Error(suite.T(), err)
+	assert.Empty(suite.T(), suite.audit.String())
+}
+
+func TestConfigHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigHistoryTestSuite))
+}
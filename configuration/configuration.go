@@ -2,6 +2,7 @@ package configuration
 
 import (
 	"os"
+	"strings"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
@@ -9,48 +10,148 @@ import (
 )
 
 type Configuration struct {
-	GcloudProjectID          string
-	GcloudRegion             string
-	DevicePublicKeyPath      string
-	DevicePrivateKeyPath     string
-	DeviceTelemetryTopic     string
-	DeviceJwtExpirationInMin int
-	MqttEndpoint             string
+	GcloudProjectID            string
+	GcloudRegion               string
+	DevicePublicKeyPath        string
+	DevicePrivateKeyPath       string
+	DeviceKeyType              string
+	DevicePrivateKeyEnv        string
+	DevicePrivateKeyPassphrase string
+	DeviceTelemetryTopic       string
+	DeviceProtocol             string
+	DeviceJwtExpirationInMin   int
+	DeviceJwtClockSkewInSec    int
+	MqttEndpoint               string
+	MqttEndpoints              []string
+	MqttProxy                  string
+	MqttCACertPaths            []string
+	MqttPinnedSPKI             []string
+	MqttClientCertPath         string
+	MqttClientKeyPath          string
+	MqttTLSMinVersion          string
+	HTTPBridgeEndpoint         string
+	LogLevel                   string
+	AuditLogPath               string
+	Profile                    string
 }
 
 var onceConfiguration sync.Once
 var ConfigurationInstance *Configuration
 
+// settingsMutex guard the global viper, loading a configuration binds its defaults and ENV, so profiles
+// loaded side by side, e.g. by connectors dialing concurrently, would race.
+var settingsMutex sync.Mutex
+
+// New create a single Configuration instance, the process exits with every violation logged when the
+// configuration is not valid. Use Load to handle the error, and Current to follow reloads.
 func New() *Configuration {
 	onceConfiguration.Do(func() {
-		ConfigurationInstance = &Configuration{}
-
-		if len(os.Getenv("GCLOUD_PROJECT")) == 0 {
-			log.Fatalln("missing required ENV GCLOUD_PROJECT")
-		}
-
-		if len(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")) == 0 {
-			log.Fatalln("missing required ENV GOOGLE_APPLICATION_CREDENTIALS")
+		var err error
+		if ConfigurationInstance, err = Load(); err != nil {
+			log.Fatalln(err.Error())
 		}
-
-		ConfigurationInstance.GcloudProjectID = viper.GetString("gcloud.projectID")
-		ConfigurationInstance.GcloudRegion = viper.GetString("gcloud.region")
-		ConfigurationInstance.DevicePublicKeyPath = viper.GetString("device.publicKeyPath")
-		ConfigurationInstance.DevicePrivateKeyPath = viper.GetString("device.privateKeyPath")
-		ConfigurationInstance.MqttEndpoint = viper.GetString("gcloud.mqtt")
-		ConfigurationInstance.DeviceTelemetryTopic = viper.GetString("device.telemetryTopic")
-		ConfigurationInstance.DeviceJwtExpirationInMin = viper.GetInt("device.jwtExpirationInMin")
-
-		log.WithFields(log.Fields{
-			"GcloudProjectID":          ConfigurationInstance.GcloudProjectID,
-			"GcloudRegion":             ConfigurationInstance.GcloudRegion,
-			"DevicePublicKeyPath":      ConfigurationInstance.DevicePublicKeyPath,
-			"DevicePrivateKeyPath":     ConfigurationInstance.DevicePrivateKeyPath,
-			"MqttEndpoint":             ConfigurationInstance.MqttEndpoint,
-			"DeviceTelemetryTopic":     ConfigurationInstance.DeviceTelemetryTopic,
-			"DeviceJwtExpirationInMin": ConfigurationInstance.DeviceJwtExpirationInMin,
-		}).Info("configuration loaded")
+		current.Store(ConfigurationInstance)
+		applyLogLevel(ConfigurationInstance)
 	})
 
 	return ConfigurationInstance
 }
+
+// Load read the configuration of the active profile from viper and validate it, all violations are
+// returned at once in a ValidationError. Every key can be overridden by its EnvPrefix ENV and, once
+// BindFlags is called, by its flag. The project ID defaults to GCLOUD_PROJECT.
+func Load() (*Configuration, error) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	return loadProfile(viper.GetViper(), activeProfile(viper.GetViper()))
+}
+
+// LoadProfile read and validate the configuration of a named profile, keys missing in the profile
+// section come from the rest of the file. An empty name loads the configuration without profile.
+func LoadProfile(name string) (*Configuration, error) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	return loadProfile(viper.GetViper(), name)
+}
+
+// loadProfile read and validate the configuration of a named profile from settings.
+func loadProfile(settings *viper.Viper, name string) (*Configuration, error) {
+	bindDefaultsAndEnv(settings)
+	validationError := &ValidationError{}
+
+	name = strings.ToLower(name)
+	if len(name) > 0 && !hasProfile(settings, name) {
+		validationError.add(profileKey, unknownProfileMessage(settings, name))
+	}
+
+	if len(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")) == 0 {
+		validationError.add("ENV GOOGLE_APPLICATION_CREDENTIALS", "required")
+	}
+
+	for _, key := range unknownKeys(settings.AllKeys()) {
+		validationError.add(key, "unknown key")
+	}
+
+	values := profileReader{settings: settings, profile: name}
+	conf := &Configuration{Profile: name}
+	conf.GcloudProjectID = values.getString("gcloud.projectID")
+	conf.GcloudRegion = values.getString("gcloud.region")
+	conf.DevicePublicKeyPath = values.getString("device.publicKeyPath")
+	conf.DevicePrivateKeyPath = values.getString("device.privateKeyPath")
+	conf.DeviceKeyType = values.getString("device.keyType")
+	conf.DevicePrivateKeyEnv = values.getString("device.privateKeyEnv")
+	conf.DevicePrivateKeyPassphrase = values.getString("device.privateKeyPassphrase")
+	conf.MqttEndpoint = values.getString("gcloud.mqtt")
+	conf.MqttEndpoints = values.getStringSlice("gcloud.mqttEndpoints")
+	if len(conf.MqttEndpoints) == 0 && len(conf.MqttEndpoint) > 0 {
+		conf.MqttEndpoints = []string{conf.MqttEndpoint}
+	}
+	conf.MqttProxy = values.getString("gcloud.mqttProxy")
+	conf.MqttCACertPaths = values.getStringSlice("gcloud.mqttTLS.caPaths")
+	conf.MqttPinnedSPKI = values.getStringSlice("gcloud.mqttTLS.pinnedSPKI")
+	conf.MqttClientCertPath = values.getString("gcloud.mqttTLS.clientCertPath")
+	conf.MqttClientKeyPath = values.getString("gcloud.mqttTLS.clientKeyPath")
+	conf.MqttTLSMinVersion = values.getString("gcloud.mqttTLS.minVersion")
+	conf.HTTPBridgeEndpoint = values.getString("gcloud.httpBridge")
+	conf.DeviceTelemetryTopic = values.getString("device.telemetryTopic")
+	conf.DeviceJwtExpirationInMin = values.getInt("device.jwtExpirationInMin")
+	conf.DeviceJwtClockSkewInSec = values.getInt("device.jwtClockSkewInSec")
+	conf.DeviceProtocol = values.getString("device.protocol")
+	conf.LogLevel = values.getString("log.level")
+	conf.AuditLogPath = values.getString("audit.logPath")
+
+	if err := conf.Validate(); err != nil {
+		validationError.Violations = append(validationError.Violations, err.(*ValidationError).Violations...)
+	}
+
+	if err := validationError.orNil(); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"GcloudProjectID":          conf.GcloudProjectID,
+		"GcloudRegion":             conf.GcloudRegion,
+		"DevicePublicKeyPath":      conf.DevicePublicKeyPath,
+		"DevicePrivateKeyPath":     conf.DevicePrivateKeyPath,
+		"DeviceKeyType":            conf.DeviceKeyType,
+		"DevicePrivateKeyEnv":      conf.DevicePrivateKeyEnv,
+		"MqttEndpoints":            conf.MqttEndpoints,
+		"MqttProxy":                len(conf.MqttProxy) > 0,
+		"MqttCACertPaths":          conf.MqttCACertPaths,
+		"MqttPinnedSPKI":           conf.MqttPinnedSPKI,
+		"MqttClientCertPath":       conf.MqttClientCertPath,
+		"MqttTLSMinVersion":        conf.MqttTLSMinVersion,
+		"HTTPBridgeEndpoint":       conf.HTTPBridgeEndpoint,
+		"DeviceTelemetryTopic":     conf.DeviceTelemetryTopic,
+		"DeviceJwtExpirationInMin": conf.DeviceJwtExpirationInMin,
+		"DeviceJwtClockSkewInSec":  conf.DeviceJwtClockSkewInSec,
+		"DeviceProtocol":           conf.DeviceProtocol,
+		"LogLevel":                 conf.LogLevel,
+		"AuditLogPath":             conf.AuditLogPath,
+		"Profile":                  conf.Profile,
+	}).Info("configuration loaded")
+
+	return conf, nil
+}
@@ -0,0 +1,232 @@
+package configuration
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix prefix the ENV overriding a key, e.g. IOT_DEVICE_JWTEXPIRATIONINMIN for device.jwtExpirationInMin.
+const EnvPrefix = "IOT"
+
+// Source is where the effective value of a key comes from, by increasing precedence.
+type Source int
+
+const (
+	// Unset keys have no value.
+	Unset Source = 1 + iota
+	// Default values are built in or come from legacy ENV such as GCLOUD_PROJECT.
+	Default
+	// File values are read from the configuration file.
+	File
+	// Profile values are read from the active profile section of the configuration file.
+	Profile
+	// Env values are read from EnvPrefix ENV.
+	Env
+	// Flag values are given on the command line.
+	Flag
+)
+
+var sourceName = [...]string{
+	"unset",
+	"default",
+	"file",
+	"profile",
+	"env",
+	"flag",
+}
+
+func (source Source) String() string {
+	return sourceName[source-1]
+}
+
+// Setting is the effective value of a key, secret values are masked.
+type Setting struct {
+	Key    string
+	Value  string
+	Source Source
+}
+
+const maskedValue = "******"
+
+var boundFlags *pflag.FlagSet
+
+// EnvName returns the ENV overriding key.
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// BindFlags define a --<key> flag for every configuration key, e.g. --device.jwtExpirationInMin=30, flags
+// given on the command line override every other source.
+func BindFlags(flags *pflag.FlagSet) error {
+	for _, rule := range schema {
+		usage := fmt.Sprintf("overrides %s, ENV %s", rule.key, EnvName(rule.key))
+		switch rule.kind {
+		case intKey:
+			flags.Int(rule.key, 0, usage)
+		case stringSliceKey:
+			flags.StringSlice(rule.key, nil, usage)
+		default:
+			flags.String(rule.key, "", usage)
+		}
+	}
+
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	boundFlags = flags
+	return bindFlags(viper.GetViper())
+}
+
+// bindFlags bind the flags defined by BindFlags to settings.
+func bindFlags(settings *viper.Viper) error {
+	if boundFlags == nil {
+		return nil
+	}
+	for _, rule := range schema {
+		if err := settings.BindPFlag(rule.key, boundFlags.Lookup(rule.key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindDefaultsAndEnv register the built in defaults and the EnvPrefix ENV of every key, precedence is
+// defaults < file < ENV < flags.
+func bindDefaultsAndEnv(settings *viper.Viper) {
+	settings.SetDefault("gcloud.projectID", os.Getenv("GCLOUD_PROJECT"))
+	settings.SetDefault("gcloud.mqtt", "ssl://mqtt.googleapis.com:8883")
+	settings.SetDefault("gcloud.httpBridge", "https://cloudiotdevice.googleapis.com/v1")
+	settings.SetDefault("device.privateKeyPassphrase", os.Getenv("DEVICE_PRIVATE_KEY_PASSPHRASE"))
+	settings.SetDefault("device.jwtExpirationInMin", 60)
+	settings.SetDefault("device.telemetryTopic", "events")
+	settings.SetDefault("device.protocol", "MQTT")
+	settings.SetDefault("audit.logPath", "audit.jsonl")
+
+	for _, rule := range schema {
+		settings.BindEnv(rule.key, EnvName(rule.key))
+	}
+}
+
+// getStringSlice read a list, ENV lists are comma separated.
+func getStringSlice(settings *viper.Viper, key string) []string {
+	value, isString := settings.Get(key).(string)
+	if !isString {
+		return settings.GetStringSlice(key)
+	}
+
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// Settings returns the effective value and source of every key of the active profile, sorted by key.
+func Settings() []Setting {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	values := profileReader{settings: viper.GetViper(), profile: activeProfile(viper.GetViper())}
+
+	settings := make([]Setting, 0, len(schema))
+	for _, rule := range schema {
+		var value string
+		switch rule.kind {
+		case intKey:
+			value = strconv.Itoa(values.getInt(rule.key))
+		case stringSliceKey:
+			value = strings.Join(values.getStringSlice(rule.key), ",")
+		default:
+			value = values.getString(rule.key)
+		}
+
+		source := sourceOf(values.settings, rule.key)
+		if values.key(rule.key) != rule.key {
+			source = Profile
+		}
+		if source == Unset || len(value) == 0 {
+			source, value = Unset, ""
+		}
+
+		settings = append(settings, Setting{Key: rule.key, Value: maskSecret(rule, value), Source: source})
+	}
+
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// DumpSettings write the effective configuration as a key, value, source table.
+func DumpSettings(writer io.Writer) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "KEY\tVALUE\tSOURCE")
+	for _, setting := range Settings() {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Source)
+	}
+	return table.Flush()
+}
+
+func sourceOf(settings *viper.Viper, key string) Source {
+	if boundFlags != nil {
+		if flag := boundFlags.Lookup(key); flag != nil && flag.Changed {
+			return Flag
+		}
+	}
+	if len(os.Getenv(EnvName(key))) > 0 {
+		return Env
+	}
+	if inConfigFile(settings, key) {
+		return File
+	}
+	if settings.IsSet(key) {
+		return Default
+	}
+	return Unset
+}
+
+// inConfigFile walk the configuration file tree, viper.InConfig only knows top level keys.
+func inConfigFile(settings *viper.Viper, key string) bool {
+	path := strings.Split(strings.ToLower(key), ".")
+	if !settings.InConfig(path[0]) {
+		return false
+	}
+
+	node := settings.Get(path[0])
+	for _, segment := range path[1:] {
+		var exist bool
+		switch tree := node.(type) {
+		case map[string]interface{}:
+			node, exist = tree[segment]
+		case map[interface{}]interface{}:
+			node, exist = tree[segment]
+		}
+		if !exist {
+			return false
+		}
+	}
+	return true
+}
+
+// maskSecret hide secret values and the password of URLs such as an authenticated proxy.
+func maskSecret(rule rule, value string) string {
+	if len(value) == 0 {
+		return value
+	}
+	if rule.secret {
+		return maskedValue
+	}
+	if parsed, err := url.Parse(value); err == nil && parsed.User != nil {
+		return parsed.Redacted()
+	}
+	return value
+}
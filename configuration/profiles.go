@@ -0,0 +1,124 @@
+package configuration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// profilesKey hold the named profiles, each one overriding any key of the file for that profile:
+//
+//	profile: staging
+//	profiles:
+//	  staging:
+//	    gcloud:
+//	      projectID: my-staging-project
+const profilesKey = "profiles"
+
+// profileKey select the active profile.
+const profileKey = "profile"
+
+// profileReader read a key from the profile section of the file when it is set there, ENV and flags
+// still override it.
+type profileReader struct {
+	settings *viper.Viper
+	profile  string
+}
+
+func (reader profileReader) key(key string) string {
+	if len(reader.profile) == 0 {
+		return key
+	}
+	if source := sourceOf(reader.settings, key); source == Env || source == Flag {
+		return key
+	}
+	if profileKey := profilesKey + "." + reader.profile + "." + key; reader.settings.IsSet(profileKey) {
+		return profileKey
+	}
+	return key
+}
+
+func (reader profileReader) getString(key string) string {
+	return reader.settings.GetString(reader.key(key))
+}
+
+func (reader profileReader) getInt(key string) int {
+	return reader.settings.GetInt(reader.key(key))
+}
+
+func (reader profileReader) getStringSlice(key string) []string {
+	return getStringSlice(reader.settings, reader.key(key))
+}
+
+// Profiles returns the names of the profiles defined in the configuration file.
+func Profiles() []string {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	return profiles(viper.GetViper())
+}
+
+func profiles(settings *viper.Viper) []string {
+	profiles := []string{}
+	for name := range settings.GetStringMap(profilesKey) {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+
+	return profiles
+}
+
+// ActiveProfile returns the profile selected by the profile key, empty when no profile is selected.
+func ActiveProfile() string {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	return activeProfile(viper.GetViper())
+}
+
+func activeProfile(settings *viper.Viper) string {
+	bindDefaultsAndEnv(settings)
+	return strings.ToLower(settings.GetString(profileKey))
+}
+
+func hasProfile(settings *viper.Viper, name string) bool {
+	for _, profile := range profiles(settings) {
+		if profile == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscribeProfile register a handler notified with the named profile, instead of the active one,
+// after every successful reload. Reloads leaving the profile invalid are logged and skipped.
+func SubscribeProfile(name string, handler ChangeHandler) (unsubscribe func()) {
+	var mutex sync.Mutex
+	previous, err := LoadProfile(name)
+	if err != nil {
+		log.Errorln("profile " + name + ": " + err.Error())
+	}
+
+	return Subscribe(func(_, _ *Configuration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		conf, err := LoadProfile(name)
+		if err != nil {
+			log.Errorln("profile " + name + " reload rejected: " + err.Error())
+			return
+		}
+		if previous != nil {
+			handler(previous, conf)
+		}
+		previous = conf
+	})
+}
+
+func unknownProfileMessage(settings *viper.Viper, name string) string {
+	return fmt.Sprintf("unknown profile %q, defined profiles are %s", name, strings.Join(profiles(settings), ", "))
+}
@@ -0,0 +1,120 @@
+package configuration_test
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProfilesTestSuite struct {
+	suite.Suite
+}
+
+const profilesFile = `
+gcloud:
+  projectID: bq-iot-dev-project
+  region: europe-west1
+device:
+  publicKeyPath: ../ec_public.pem
+  privateKeyPath: ../ec_private.pem
+profiles:
+  staging:
+    gcloud:
+      projectID: bq-iot-staging-project
+  prod:
+    gcloud:
+      projectID: bq-iot-prod-project
+      region: us-central1
+    device:
+      jwtExpirationInMin: 20
+`
+
+func (suite *ProfilesTestSuite) SetupTest() {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	assert.NoError(suite.T(), viper.ReadConfig(bytes.NewBufferString(profilesFile)), "UnexpectedError")
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "credentials.json")
+}
+
+func (suite *ProfilesTestSuite) TearDownTest() {
+	os.Unsetenv("IOT_PROFILE")
+	os.Unsetenv("IOT_GCLOUD_REGION")
+	viper.Reset()
+}
+
+func (suite *ProfilesTestSuite) TestProfiles() {
+	assert.EqualValues(suite.T(), []string{"prod", "staging"}, configuration.Profiles())
+}
+
+func (suite *ProfilesTestSuite) TestSideBySide() {
+	staging, err := configuration.LoadProfile("staging")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	prod, err := configuration.LoadProfile("prod")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	assert.EqualValues(suite.T(), "bq-iot-staging-project", staging.GcloudProjectID)
+	assert.EqualValues(suite.T(), "europe-west1", staging.GcloudRegion)
+	assert.EqualValues(suite.T(), 60, staging.DeviceJwtExpirationInMin)
+	assert.EqualValues(suite.T(), "bq-iot-prod-project", prod.GcloudProjectID)
+	assert.EqualValues(suite.T(), "us-central1", prod.GcloudRegion)
+	assert.EqualValues(suite.T(), 20, prod.DeviceJwtExpirationInMin)
+	assert.EqualValues(suite.T(), "prod", prod.Profile)
+}
+
+func (suite *ProfilesTestSuite) TestConcurrentLoads() {
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			conf, err := configuration.LoadProfile("staging")
+			if assert.NoError(suite.T(), err, "UnexpectedError") {
+				assert.EqualValues(suite.T(), "bq-iot-staging-project", conf.GcloudProjectID)
+			}
+			configuration.Settings()
+		}()
+	}
+	wait.Wait()
+}
+
+func (suite *ProfilesTestSuite) TestActiveProfile() {
+	conf, err := configuration.Load()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), "bq-iot-dev-project", conf.GcloudProjectID)
+
+	os.Setenv("IOT_PROFILE", "prod")
+	os.Setenv("IOT_GCLOUD_REGION", "asia-east1")
+	conf, err = configuration.Load()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), "bq-iot-prod-project", conf.GcloudProjectID)
+	// ENV still override the profile
+	assert.EqualValues(suite.T(), "asia-east1", conf.GcloudRegion)
+
+	settings := map[string]configuration.Setting{}
+	for _, setting := range configuration.Settings() {
+		settings[setting.Key] = setting
+	}
+	assert.EqualValues(suite.T(), configuration.Profile, settings["gcloud.projectID"].Source)
+	assert.EqualValues(suite.T(), configuration.Env, settings["gcloud.region"].Source)
+}
+
+func (suite *ProfilesTestSuite) TestInvalidProfiles() {
+	_, err := configuration.LoadProfile("qa")
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), `unknown profile "qa", defined profiles are prod, staging`)
+
+	viper.Set("profiles.staging.gcloud.projectId2", "typo")
+	_, err = configuration.LoadProfile("staging")
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "profiles.staging.gcloud.projectid2: unknown key")
+}
+
+func TestProfilesTestSuite(t *testing.T) {
+	suite.Run(t, new(ProfilesTestSuite))
+}
@@ -0,0 +1,288 @@
+package configuration
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var projectIDFormat = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+var regionFormat = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+
+// Violation is a configuration key failing its schema rule.
+type Violation struct {
+	Key     string
+	Message string
+}
+
+// ValidationError aggregate every violation found while loading the configuration.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (err *ValidationError) Error() string {
+	messages := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		messages = append(messages, violation.Key+": "+violation.Message)
+	}
+	return fmt.Sprintf("invalid configuration, %d violation(s): %s", len(err.Violations), strings.Join(messages, "; "))
+}
+
+func (err *ValidationError) add(key, message string) {
+	err.Violations = append(err.Violations, Violation{Key: key, Message: message})
+}
+
+func (err *ValidationError) orNil() error {
+	if len(err.Violations) == 0 {
+		return nil
+	}
+	return err
+}
+
+// keyKind is the type of a configuration value.
+type keyKind int
+
+const (
+	stringKey keyKind = 1 + iota
+	intKey
+	stringSliceKey
+)
+
+// rule describe a single key, check returns an empty message when the value is valid. Secret values
+// are never printed.
+type rule struct {
+	key    string
+	kind   keyKind
+	secret bool
+	check  func(conf *Configuration) string
+}
+
+// schema is every key read by Load, keys without check are optional and free form. device.keyType,
+// device.protocol and the JWT lifetime limit are checked by connectors, see RegisterCheck.
+var schema = []rule{
+	{key: "gcloud.projectID", kind: stringKey, check: func(conf *Configuration) string {
+		return checkFormat(conf.GcloudProjectID, projectIDFormat, "a project ID like my-project-123")
+	}},
+	{key: "gcloud.region", kind: stringKey, check: func(conf *Configuration) string {
+		return checkFormat(conf.GcloudRegion, regionFormat, "a region like europe-west1")
+	}},
+	{key: "gcloud.mqtt", kind: stringKey},
+	{key: "gcloud.mqttEndpoints", kind: stringSliceKey, check: func(conf *Configuration) string {
+		if len(conf.MqttEndpoints) == 0 {
+			return "required, set gcloud.mqtt or gcloud.mqttEndpoints"
+		}
+		for _, endpoint := range conf.MqttEndpoints {
+			if message := checkMQTTURL(endpoint); len(message) > 0 {
+				return message
+			}
+		}
+		return ""
+	}},
+	{key: "gcloud.mqttProxy", kind: stringKey, check: func(conf *Configuration) string {
+		if len(conf.MqttProxy) == 0 {
+			return ""
+		}
+		if proxy, err := url.Parse(conf.MqttProxy); err != nil || len(proxy.Host) == 0 {
+			return "must be a proxy URL like http://proxy:3128"
+		}
+		return ""
+	}},
+	{key: "gcloud.mqttTLS.caPaths", kind: stringSliceKey, check: func(conf *Configuration) string {
+		for _, path := range conf.MqttCACertPaths {
+			if message := checkReadable(path); len(message) > 0 {
+				return message
+			}
+		}
+		return ""
+	}},
+	{key: "gcloud.mqttTLS.pinnedSPKI", kind: stringSliceKey},
+	{key: "gcloud.mqttTLS.clientCertPath", kind: stringKey, check: func(conf *Configuration) string {
+		return checkOptionalReadable(conf.MqttClientCertPath)
+	}},
+	{key: "gcloud.mqttTLS.clientKeyPath", kind: stringKey, check: func(conf *Configuration) string {
+		return checkOptionalReadable(conf.MqttClientKeyPath)
+	}},
+	{key: "gcloud.mqttTLS.minVersion", kind: stringKey, check: func(conf *Configuration) string {
+		switch conf.MqttTLSMinVersion {
+		case "", "1.2", "1.3":
+			return ""
+		}
+		return fmt.Sprintf("unsupported TLS version %q, must be 1.2 or 1.3", conf.MqttTLSMinVersion)
+	}},
+	{key: "gcloud.httpBridge", kind: stringKey, check: func(conf *Configuration) string {
+		if len(conf.HTTPBridgeEndpoint) == 0 {
+			return ""
+		}
+		if bridge, err := url.Parse(conf.HTTPBridgeEndpoint); err != nil || bridge.Scheme != "https" || len(bridge.Host) == 0 {
+			return fmt.Sprintf("%q must be an https URL", conf.HTTPBridgeEndpoint)
+		}
+		return ""
+	}},
+	{key: "device.publicKeyPath", kind: stringKey, check: func(conf *Configuration) string {
+		return checkReadable(conf.DevicePublicKeyPath)
+	}},
+	{key: "device.privateKeyPath", kind: stringKey, check: func(conf *Configuration) string {
+		if len(conf.DevicePrivateKeyEnv) > 0 {
+			return ""
+		}
+		return checkReadable(conf.DevicePrivateKeyPath)
+	}},
+	{key: "device.privateKeyEnv", kind: stringKey, check: func(conf *Configuration) string {
+		if _, exist := os.LookupEnv(conf.DevicePrivateKeyEnv); len(conf.DevicePrivateKeyEnv) > 0 && !exist {
+			return fmt.Sprintf("ENV %s is not set", conf.DevicePrivateKeyEnv)
+		}
+		return ""
+	}},
+	{key: "device.privateKeyPassphrase", kind: stringKey, secret: true},
+	{key: "device.keyType", kind: stringKey},
+	{key: "device.telemetryTopic", kind: stringKey, check: func(conf *Configuration) string {
+		// events or events/<subfolder>, the only topics a device can publish telemetry to
+		if len(conf.DeviceTelemetryTopic) == 0 || conf.DeviceTelemetryTopic == "events" {
+			return ""
+		}
+		if !strings.HasPrefix(conf.DeviceTelemetryTopic, "events/") || strings.HasSuffix(conf.DeviceTelemetryTopic, "/") {
+			return fmt.Sprintf("%q is not events or events/<subfolder>", conf.DeviceTelemetryTopic)
+		}
+		return ""
+	}},
+	{key: "device.protocol", kind: stringKey},
+	{key: "device.jwtExpirationInMin", kind: intKey, check: func(conf *Configuration) string {
+		if conf.DeviceJwtExpirationInMin <= 0 {
+			return fmt.Sprintf("must be positive, got %d", conf.DeviceJwtExpirationInMin)
+		}
+		return ""
+	}},
+	{key: "device.jwtClockSkewInSec", kind: intKey, check: func(conf *Configuration) string {
+		if conf.DeviceJwtClockSkewInSec < 0 {
+			return fmt.Sprintf("must not be negative, got %d", conf.DeviceJwtClockSkewInSec)
+		}
+		return ""
+	}},
+	{key: "profile", kind: stringKey},
+	{key: "audit.logPath", kind: stringKey},
+	{key: "log.level", kind: stringKey, check: func(conf *Configuration) string {
+		if len(conf.LogLevel) == 0 {
+			return ""
+		}
+		if _, err := log.ParseLevel(conf.LogLevel); err != nil {
+			return err.Error()
+		}
+		return ""
+	}},
+}
+
+// RegisterCheck add a check to a schema key, it runs after the key own check. It is meant for rules owned by
+// packages importing configuration, e.g. connectors parsing key types, and must be called from an init func.
+func RegisterCheck(key string, check func(conf *Configuration) string) {
+	for i := range schema {
+		if schema[i].key != key {
+			continue
+		}
+		previous := schema[i].check
+		schema[i].check = func(conf *Configuration) string {
+			if previous != nil {
+				if message := previous(conf); len(message) > 0 {
+					return message
+				}
+			}
+			return check(conf)
+		}
+		return
+	}
+	panic("configuration key " + key + " is not part of the schema")
+}
+
+// Validate check the configuration against its schema, every violation is reported in a ValidationError.
+func (conf *Configuration) Validate() error {
+	validationError := &ValidationError{}
+	for _, rule := range schema {
+		if rule.check == nil {
+			continue
+		}
+		if message := rule.check(conf); len(message) > 0 {
+			validationError.add(rule.key, message)
+		}
+	}
+
+	return validationError.orNil()
+}
+
+// unknownKeys returns the keys set in the configuration file that are not part of the schema, usually
+// misspelled ones whose value would silently be ignored.
+func unknownKeys(keys []string) (unknown []string) {
+	known := make(map[string]bool, len(schema))
+	for _, rule := range schema {
+		known[strings.ToLower(rule.key)] = true
+	}
+
+	for _, key := range keys {
+		schemaKey := strings.ToLower(key)
+		if strings.HasPrefix(schemaKey, profilesKey+".") {
+			// profiles.<name>.<key>, a profile can not select another profile
+			parts := strings.SplitN(schemaKey, ".", 3)
+			if len(parts) < 3 || parts[2] == profileKey {
+				unknown = append(unknown, key)
+				continue
+			}
+			schemaKey = parts[2]
+		}
+		if !known[schemaKey] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	return
+}
+
+func checkFormat(value string, format *regexp.Regexp, expected string) string {
+	if len(value) == 0 {
+		return "required"
+	}
+	if !format.MatchString(value) {
+		return fmt.Sprintf("%q is not %s", value, expected)
+	}
+	return ""
+}
+
+func checkMQTTURL(endpoint string) string {
+	broker, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Sprintf("invalid MQTT URL %q: %s", endpoint, err.Error())
+	}
+	switch broker.Scheme {
+	case "ssl", "tls", "tcps", "wss":
+	default:
+		return fmt.Sprintf("unsupported MQTT URL scheme %q, must be ssl or wss", endpoint)
+	}
+	if len(broker.Hostname()) == 0 || len(broker.Port()) == 0 {
+		return fmt.Sprintf("MQTT URL %q must have a host and a port", endpoint)
+	}
+	return ""
+}
+
+func checkReadable(path string) string {
+	if len(path) == 0 {
+		return "required"
+	}
+	return checkOptionalReadable(path)
+}
+
+func checkOptionalReadable(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		return fmt.Sprintf("%s is not a readable file", path)
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package configuration_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	// connectors register the key type, protocol and JWT lifetime checks
+	_ "github.com/pjgg/iotPlayground/connectors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ValidationTestSuite struct {
+	suite.Suite
+}
+
+func validConfiguration() *configuration.Configuration {
+	return &configuration.Configuration{
+		GcloudProjectID:          "bq-iot-example-project",
+		GcloudRegion:             "europe-west1",
+		DevicePublicKeyPath:      "../ec_public.pem",
+		DevicePrivateKeyPath:     "../ec_private.pem",
+		DeviceJwtExpirationInMin: 60,
+		MqttEndpoints:            []string{"ssl://mqtt.googleapis.com:8883", "wss://mqtt.googleapis.com:443/mqtt"},
+	}
+}
+
+func (suite *ValidationTestSuite) TestValid() {
+	assert.NoError(suite.T(), validConfiguration().Validate(), "UnexpectedError")
+}
+
+func (suite *ValidationTestSuite) TestAggregatedViolations() {
+	conf := validConfiguration()
+	conf.GcloudProjectID = "Bad_Project"
+	conf.GcloudRegion = ""
+	conf.DevicePublicKeyPath = ""
+	conf.DevicePrivateKeyPath = "../missing_private.pem"
+	conf.DeviceJwtExpirationInMin = 24 * 60
+	conf.DeviceJwtClockSkewInSec = 60
+	conf.MqttEndpoints = []string{"tcp://mqtt.googleapis.com:1883"}
+	conf.DeviceKeyType = "ES512"
+	conf.DeviceProtocol = "AMQP"
+
+	err := conf.Validate()
+	assert.Error(suite.T(), err)
+
+	keys := []string{}
+	for _, violation := range err.(*configuration.ValidationError).Violations {
+		keys = append(keys, violation.Key)
+	}
+	assert.EqualValues(suite.T(), []string{
+		"gcloud.projectID",
+		"gcloud.region",
+		"gcloud.mqttEndpoints",
+		"device.publicKeyPath",
+		"device.privateKeyPath",
+		"device.keyType",
+		"device.protocol",
+		"device.jwtExpirationInMin",
+	}, keys)
+}
+
+func (suite *ValidationTestSuite) TestKeyTypeAndProtocol() {
+	conf := validConfiguration()
+	conf.DeviceKeyType = "es256_x509_pem"
+	conf.DeviceProtocol = "mqtt"
+	assert.NoError(suite.T(), conf.Validate(), "UnexpectedError")
+
+	conf.DeviceKeyType = "ES512"
+	err := conf.Validate()
+	if assert.Error(suite.T(), err) {
+		assert.Contains(suite.T(), err.Error(), "device.keyType: unknown key type \"ES512\"")
+	}
+}
+
+func (suite *ValidationTestSuite) TestTelemetryTopic() {
+	conf := validConfiguration()
+	for _, topic := range []string{"events", "events/site-a"} {
+		conf.DeviceTelemetryTopic = topic
+		assert.NoError(suite.T(), conf.Validate(), topic)
+	}
+	for _, topic := range []string{"state", "events/", "eventsx"} {
+		conf.DeviceTelemetryTopic = topic
+		assert.Error(suite.T(), conf.Validate(), topic)
+	}
+}
+
+func (suite *ValidationTestSuite) TestPrivateKeyEnv() {
+	conf := validConfiguration()
+	conf.DevicePrivateKeyPath = ""
+	conf.DevicePrivateKeyEnv = "VALIDATION_TEST_PRIVATE_KEY"
+	assert.Error(suite.T(), conf.Validate())
+
+	os.Setenv("VALIDATION_TEST_PRIVATE_KEY", "pem")
+	defer os.Unsetenv("VALIDATION_TEST_PRIVATE_KEY")
+	assert.NoError(suite.T(), conf.Validate(), "UnexpectedError")
+}
+
+func (suite *ValidationTestSuite) TestLoadReportsUnknownKeys() {
+	defer viper.Reset()
+	os.Setenv("GCLOUD_PROJECT", "bq-iot-example-project")
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "credentials.json")
+
+	setValidKeys()
+	viper.Set("device.publicCredentialsPath", "../ec_public.pem")
+
+	_, err := configuration.Load()
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "device.publiccredentialspath: unknown key")
+	assert.Contains(suite.T(), err.Error(), "device.publicKeyPath: required")
+
+	viper.Reset()
+	setValidKeys()
+	viper.Set("device.publicKeyPath", "../ec_public.pem")
+	conf, err := configuration.Load()
+	if assert.NoError(suite.T(), err, "UnexpectedError") {
+		assert.EqualValues(suite.T(), []string{"ssl://mqtt.googleapis.com:8883"}, conf.MqttEndpoints)
+	}
+}
+
+func setValidKeys() {
+	viper.Set("gcloud.projectID", "bq-iot-example-project")
+	viper.Set("gcloud.region", "europe-west1")
+	viper.Set("gcloud.mqtt", "ssl://mqtt.googleapis.com:8883")
+	viper.Set("device.privateKeyPath", "../ec_private.pem")
+	viper.Set("device.jwtExpirationInMin", 60)
+}
+
+func TestValidationTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidationTestSuite))
+}
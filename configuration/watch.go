@@ -0,0 +1,168 @@
+package configuration
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// ChangeHandler is notified after a reloaded configuration replaced the previous one.
+type ChangeHandler func(previous, current *Configuration)
+
+var current atomic.Value
+var onceWatch sync.Once
+var reloadMutex sync.Mutex
+
+var subscribersMutex sync.Mutex
+var subscribers = map[int]ChangeHandler{}
+var nextSubscriber int
+
+// Current returns the latest valid configuration, the New instance until a reload succeeds.
+func Current() *Configuration {
+	if conf, loaded := current.Load().(*Configuration); loaded {
+		return conf
+	}
+	return New()
+}
+
+// Subscribe register a handler called, in subscription order, after every successful reload.
+func Subscribe(handler ChangeHandler) (unsubscribe func()) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+
+	id := nextSubscriber
+	nextSubscriber++
+	subscribers[id] = handler
+
+	return func() {
+		subscribersMutex.Lock()
+		defer subscribersMutex.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+// Watch reload the configuration every time its file is written. An invalid file is logged and ignored,
+// the previous configuration stays current. Without configuration file there is nothing to watch.
+func Watch() {
+	onceWatch.Do(func() {
+		path := viper.ConfigFileUsed()
+		if len(path) == 0 {
+			log.Info("no configuration file to watch")
+			return
+		}
+
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Errorln("configuration watch failed: " + err.Error())
+			return
+		}
+		// the directory is watched, editors replace the file instead of writing it
+		if err = watcher.Add(filepath.Dir(path)); err != nil {
+			log.Errorln("configuration watch failed: " + err.Error())
+			watcher.Close()
+			return
+		}
+
+		go func() {
+			for {
+				select {
+				case event, open := <-watcher.Events:
+					if !open {
+						return
+					}
+					if filepath.Clean(event.Name) == filepath.Clean(path) && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+						log.Info("configuration file changed: " + event.Name)
+						Reload()
+					}
+				case err, open := <-watcher.Errors:
+					if !open {
+						return
+					}
+					log.Errorln("configuration watch failed: " + err.Error())
+				}
+			}
+		}()
+	})
+}
+
+// Reload read the configuration file into a fresh viper and validate it. Only a valid file replaces the
+// settings, the configuration is then swapped atomically and the subscribers notified. Reloads run one at
+// a time.
+func Reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	conf, err := reloadFile(viper.ConfigFileUsed())
+	if err != nil {
+		log.Errorln("configuration reload rejected: " + err.Error())
+		return err
+	}
+
+	previous := Current()
+	current.Store(conf)
+	applyLogLevel(conf)
+
+	subscribersMutex.Lock()
+	handlers := make([]ChangeHandler, 0, len(subscribers))
+	for id := 0; id < nextSubscriber; id++ {
+		if handler, exist := subscribers[id]; exist {
+			handlers = append(handlers, handler)
+		}
+	}
+	subscribersMutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(previous, conf)
+	}
+
+	return nil
+}
+
+// reloadFile parse path into a fresh viper and load the configuration from it, the global settings are
+// replaced by the same content only once it is valid. Without file the configuration is loaded again from
+// ENV and flags.
+func reloadFile(path string) (*Configuration, error) {
+	if len(path) == 0 {
+		return Load()
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := viper.New()
+	fresh.SetConfigFile(path)
+	if err = fresh.ReadConfig(bytes.NewReader(content)); err != nil {
+		return nil, err
+	}
+	if err = bindFlags(fresh); err != nil {
+		return nil, err
+	}
+	conf, err := loadProfile(fresh, activeProfile(fresh))
+	if err != nil {
+		return nil, err
+	}
+
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	if err = viper.ReadConfig(bytes.NewReader(content)); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func applyLogLevel(conf *Configuration) {
+	if len(conf.LogLevel) == 0 {
+		return
+	}
+	if level, err := log.ParseLevel(conf.LogLevel); err == nil {
+		log.SetLevel(level)
+	}
+}
@@ -0,0 +1,116 @@
+package configuration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type WatchTestSuite struct {
+	suite.Suite
+	dir  string
+	path string
+}
+
+const watchedFile = `
+gcloud:
+  projectID: bq-iot-example-project
+  region: europe-west1
+device:
+  publicKeyPath: %s
+  privateKeyPath: %s
+  jwtExpirationInMin: %d
+`
+
+func (suite *WatchTestSuite) SetupSuite() {
+	suite.dir, _ = ioutil.TempDir("", "configuration")
+	suite.path = filepath.Join(suite.dir, "config.yaml")
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "credentials.json")
+
+	viper.Reset()
+	viper.SetConfigFile(suite.path)
+	suite.writeConfig(60)
+	assert.NoError(suite.T(), viper.ReadInConfig(), "UnexpectedError")
+	assert.NoError(suite.T(), configuration.Reload(), "UnexpectedError")
+}
+
+func (suite *WatchTestSuite) TearDownSuite() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *WatchTestSuite) writeConfig(jwtExpirationInMin int) {
+	publicKeyPath, _ := filepath.Abs("../ec_public.pem")
+	privateKeyPath, _ := filepath.Abs("../ec_private.pem")
+	content := fmt.Sprintf(watchedFile, publicKeyPath, privateKeyPath, jwtExpirationInMin)
+	assert.NoError(suite.T(), ioutil.WriteFile(suite.path, []byte(content), 0644), "UnexpectedError")
+}
+
+func (suite *WatchTestSuite) TestInvalidReloadKeepsCurrent() {
+	notified := false
+	unsubscribe := configuration.Subscribe(func(previous, current *configuration.Configuration) {
+		notified = true
+	})
+	defer unsubscribe()
+
+	suite.writeConfig(-1)
+	assert.Error(suite.T(), configuration.Reload())
+	assert.False(suite.T(), notified)
+	assert.EqualValues(suite.T(), 60, configuration.Current().DeviceJwtExpirationInMin)
+	// the rejected file does not leak into the settings other profiles are loaded from
+	assert.EqualValues(suite.T(), 60, viper.GetInt("device.jwtExpirationInMin"))
+
+	suite.writeConfig(60)
+}
+
+func (suite *WatchTestSuite) TestConcurrentReloads() {
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			assert.NoError(suite.T(), configuration.Reload(), "UnexpectedError")
+		}()
+	}
+	wait.Wait()
+	assert.EqualValues(suite.T(), 60, configuration.Current().DeviceJwtExpirationInMin)
+}
+
+func (suite *WatchTestSuite) TestWatch() {
+	changes := make(chan [2]int, 10)
+	unsubscribe := configuration.Subscribe(func(previous, current *configuration.Configuration) {
+		changes <- [2]int{previous.DeviceJwtExpirationInMin, current.DeviceJwtExpirationInMin}
+	})
+	defer unsubscribe()
+
+	configuration.Watch()
+	// let the watcher register the directory
+	time.Sleep(100 * time.Millisecond)
+	suite.writeConfig(30)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case change := <-changes:
+			if change[1] == 30 {
+				assert.EqualValues(suite.T(), 60, change[0])
+				assert.EqualValues(suite.T(), 30, configuration.Current().DeviceJwtExpirationInMin)
+				return
+			}
+		case <-timeout:
+			suite.T().Fatal("configuration change not notified")
+		}
+	}
+}
+
+func TestWatchTestSuite(t *testing.T) {
+	suite.Run(t, new(WatchTestSuite))
+}
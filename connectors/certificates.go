@@ -0,0 +1,52 @@
+package connectors
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// X509CertificatePem is the registry credential format of a CA certificate.
+const X509CertificatePem = "X509_CERTIFICATE_PEM"
+
+// ParseCertificatePEM returns the first certificate of a PEM bundle.
+func ParseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// VerifyCertificateChain check a device certificate is currently valid and signed by one of the CA
+// certificates, as Cloud IoT does when a registry holds CA credentials.
+func VerifyCertificateChain(deviceCertPEM []byte, caCertPEMs []string) error {
+	certificate, err := ParseCertificatePEM(deviceCertPEM)
+	if err != nil {
+		return fmt.Errorf("invalid device certificate: %s", err.Error())
+	}
+
+	roots := x509.NewCertPool()
+	for i, caCertPEM := range caCertPEMs {
+		if !roots.AppendCertsFromPEM([]byte(caCertPEM)) {
+			return fmt.Errorf("registry CA credential %d is not a PEM certificate", i)
+		}
+	}
+
+	_, err = certificate.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: time.Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("device certificate %q does not chain to a registry CA: %s", certificate.Subject.CommonName, err.Error())
+	}
+
+	return nil
+}
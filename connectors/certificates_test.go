@@ -0,0 +1,82 @@
+package connectors_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type CertificatesTestSuite struct {
+	suite.Suite
+	caPEM      string
+	otherCAPEM string
+	devicePEM  []byte
+}
+
+func (suite *CertificatesTestSuite) TestVerifyCertificateChain() {
+	err := connectors.VerifyCertificateChain(suite.devicePEM, []string{suite.otherCAPEM, suite.caPEM})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+}
+
+func (suite *CertificatesTestSuite) TestVerifyCertificateChainUnknownCA() {
+	err := connectors.VerifyCertificateChain(suite.devicePEM, []string{suite.otherCAPEM})
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "does not chain to a registry CA")
+}
+
+func (suite *CertificatesTestSuite) TestParseKeyType() {
+	keyType, err := connectors.ParseKeyType("rsa_x509_pem")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), connectors.RsaX509Pem, keyType)
+	assert.True(suite.T(), keyType.IsX509())
+
+	_, err = connectors.ParseKeyType("DSA_PEM")
+	assert.Error(suite.T(), err)
+}
+
+func (suite *CertificatesTestSuite) SetupSuite() {
+	var caKey *ecdsa.PrivateKey
+	var caCert *x509.Certificate
+	suite.caPEM, caKey, caCert = newTestCA("test-root")
+	suite.otherCAPEM, _, _ = newTestCA("other-root")
+
+	deviceKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "test-device"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, caCert, &deviceKey.PublicKey, caKey)
+	suite.devicePEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newTestCA(commonName string) (string, *ecdsa.PrivateKey, *x509.Certificate) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certificate, _ := x509.ParseCertificate(der)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), key, certificate
+}
+
+func TestCertificatesTestSuite(t *testing.T) {
+	suite.Run(t, new(CertificatesTestSuite))
+}
@@ -0,0 +1,36 @@
+package connectors
+
+import (
+	"fmt"
+
+	"github.com/pjgg/iotPlayground/configuration"
+)
+
+// configuration can not import connectors, so the keys parsed here are checked through RegisterCheck.
+func init() {
+	configuration.RegisterCheck("device.keyType", func(conf *configuration.Configuration) string {
+		if len(conf.DeviceKeyType) == 0 {
+			return ""
+		}
+		if _, err := ParseKeyType(conf.DeviceKeyType); err != nil {
+			return err.Error()
+		}
+		return ""
+	})
+	configuration.RegisterCheck("device.protocol", func(conf *configuration.Configuration) string {
+		if len(conf.DeviceProtocol) == 0 {
+			return ""
+		}
+		if _, err := ParseProtocol(conf.DeviceProtocol); err != nil {
+			return err.Error()
+		}
+		return ""
+	})
+	configuration.RegisterCheck("device.jwtExpirationInMin", func(conf *configuration.Configuration) string {
+		options := DeviceJWTOptions(conf)
+		if options.Expiration+options.Skew > MaxJWTExpiration {
+			return fmt.Sprintf("%d minutes plus %d seconds clock skew exceeds the %s Cloud IoT maximum", conf.DeviceJwtExpirationInMin, conf.DeviceJwtClockSkewInSec, MaxJWTExpiration)
+		}
+		return ""
+	})
+}
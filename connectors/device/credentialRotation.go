@@ -0,0 +1,94 @@
+package device
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/connectors"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+// CredentialRotation describe a zero downtime key rotation: the new key is registered next to the current
+// ones, the device switches to it, a connect is verified, and only then the previous keys are revoked.
+type CredentialRotation struct {
+	NewPublicKeyPath string
+	KeyType          connectors.KeyType
+	ExpirationTime   time.Time
+	// Switch makes the device use the new private key, e.g. deploying it or updating its key path. switched
+	// tells whether the device uses the new key, also when err is set by a partial switch.
+	Switch func() (switched bool, err error)
+	// Verify confirms the device connects with the new key, see VerifyHTTPBridgeConnect.
+	Verify func() error
+}
+
+// RotateCredential run a credential rotation. If the switch fails, the device reports it did not switch, or
+// the verification fails, the previous keys are kept, and the new key is revoked unless the device switched
+// to it, so the device can still connect.
+func (iotConnector *HTTPIotDeviceConnector) RotateCredential(deviceID string, rotation CredentialRotation) (err error) {
+	if rotation.Verify == nil {
+		return fmt.Errorf("credential rotation of %s requires a verification step", deviceID)
+	}
+
+	previous, err := iotConnector.ListCredentials(deviceID)
+	if err != nil {
+		return
+	}
+
+	keyBytes, err := ioutil.ReadFile(rotation.NewPublicKeyPath)
+	if err != nil {
+		return
+	}
+	for _, old := range previous {
+		if old.PublicKey != nil && strings.TrimSpace(old.PublicKey.Key) == strings.TrimSpace(string(keyBytes)) {
+			return fmt.Errorf("credential rotation of %s: %s is already registered", deviceID, rotation.NewPublicKeyPath)
+		}
+	}
+
+	device, err := iotConnector.AddCredential(deviceID, rotation.NewPublicKeyPath, rotation.KeyType, rotation.ExpirationTime)
+	if err != nil {
+		return
+	}
+	log.Debugln("Rotation of ", deviceID, ": new credential added, ", len(device.Credentials), " registered")
+
+	switched := false
+	rollback := func(cause error) error {
+		if switched {
+			log.Errorln("Rotation of " + deviceID + ": the device switched to the new credential, it is kept next to the previous ones")
+			return cause
+		}
+		if _, revokeErr := iotConnector.RemoveCredential(deviceID, rotation.NewPublicKeyPath); revokeErr != nil {
+			log.Errorln("Rotation of " + deviceID + ": unable to revoke new credential: " + revokeErr.Error())
+		}
+		return cause
+	}
+
+	if rotation.Switch != nil {
+		if switched, err = rotation.Switch(); err != nil {
+			return rollback(fmt.Errorf("credential rotation of %s: switch fail: %s", deviceID, err.Error()))
+		}
+		if !switched {
+			return rollback(fmt.Errorf("credential rotation of %s: the device did not switch to the new credential", deviceID))
+		}
+	}
+
+	if err = rotation.Verify(); err != nil {
+		return rollback(fmt.Errorf("credential rotation of %s: verification fail: %s", deviceID, err.Error()))
+	}
+
+	_, err = iotConnector.filterCredentials(deviceID, func(credential *cloudiot.DeviceCredential) bool {
+		for _, old := range previous {
+			if old.PublicKey != nil && credential.PublicKey != nil && old.PublicKey.Key == credential.PublicKey.Key {
+				return false
+			}
+		}
+		return true
+	})
+	if err == nil {
+		log.Debugln("Rotation of ", deviceID, ": ", len(previous), " previous credentials revoked")
+	}
+
+	return
+}
@@ -0,0 +1,100 @@
+package device_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type CredentialRotationTestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	connector   *device.HTTPIotDeviceConnector
+	mutex       sync.Mutex
+	credentials []*cloudiot.DeviceCredential
+	oldKey      string
+	newKey      string
+}
+
+func (suite *CredentialRotationTestSuite) TestNotSwitchedKeepsPreviousCredentials() {
+	verified := false
+	err := suite.connector.RotateCredential("device-1", device.CredentialRotation{
+		NewPublicKeyPath: "../../rsa_public.pem",
+		KeyType:          connectors.RsaPem,
+		Switch:           func() (bool, error) { return false, nil },
+		Verify: func() error {
+			verified = true
+			return nil
+		},
+	})
+	assert.Error(suite.T(), err)
+	assert.False(suite.T(), verified)
+	assert.EqualValues(suite.T(), []string{suite.oldKey}, suite.keys())
+}
+
+func (suite *CredentialRotationTestSuite) TestSwitchedRevokesPreviousCredentials() {
+	err := suite.connector.RotateCredential("device-1", device.CredentialRotation{
+		NewPublicKeyPath: "../../rsa_public.pem",
+		KeyType:          connectors.RsaPem,
+		Switch:           func() (bool, error) { return true, nil },
+		Verify:           func() error { return nil },
+	})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), []string{suite.newKey}, suite.keys())
+}
+
+func (suite *CredentialRotationTestSuite) keys() (keys []string) {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	for _, credential := range suite.credentials {
+		keys = append(keys, credential.PublicKey.Key)
+	}
+	return
+}
+
+func (suite *CredentialRotationTestSuite) SetupTest() {
+	oldKey, _ := ioutil.ReadFile("../../ec_public.pem")
+	newKey, _ := ioutil.ReadFile("../../rsa_public.pem")
+	suite.oldKey = string(oldKey)
+	suite.newKey = string(newKey)
+	suite.credentials = []*cloudiot.DeviceCredential{{PublicKey: &cloudiot.PublicKeyCredential{Format: "ES256_PEM", Key: suite.oldKey}}}
+
+	// the server keeps the credentials of a single device, GET returns them and PATCH replaces them
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.mutex.Lock()
+		defer suite.mutex.Unlock()
+		if r.Method == http.MethodPatch && strings.Contains(r.URL.Query().Get("updateMask"), "credentials") {
+			var patch cloudiot.Device
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			suite.credentials = patch.Credentials
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&cloudiot.Device{Id: "device-1", Credentials: suite.credentials})
+	}))
+
+	service, err := cloudiot.New(http.DefaultClient)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	service.BasePath = suite.server.URL + "/"
+	suite.connector = &device.HTTPIotDeviceConnector{HTTPClient: service}
+}
+
+func (suite *CredentialRotationTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func TestCredentialRotationTestSuite(t *testing.T) {
+	suite.Run(t, new(CredentialRotationTestSuite))
+}
@@ -0,0 +1,187 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/pjgg/iotPlayground/keystore"
+)
+
+// DeviceClient define device side behavior regardless of the underlying transport.
+type DeviceClient interface {
+	PublishTelemetry(message *telemetry.Telemetry) error
+	ReportState(state []byte) error
+	OnConfig(handler func(config []byte)) error
+	OnCommand(handler func(subfolder string, command []byte)) error
+	Close() error
+}
+
+// ErrCommandsUnsupported is returned by transports that can not deliver commands to devices.
+var ErrCommandsUnsupported = errors.New("commands are not supported by this transport")
+
+const mqttDisconnectQuiesceMs = 250
+const httpBridgeConfigPollSecond = 60
+
+// NewDeviceClient create a DeviceClient for deviceID over the given protocol. Every client has its own
+// connector, so clients of different devices or registries can be used and closed side by side.
+func NewDeviceClient(protocol connectors.Protocol, registryID, deviceID string) (DeviceClient, error) {
+	switch protocol {
+	case connectors.MQTT:
+		connector, err := newConfiguredMQTTIotConnector(registryID, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		return &mqttDeviceClient{connector: connector, deviceID: deviceID}, nil
+	case connectors.HTTP:
+		return &httpDeviceClient{
+			connector: newConfiguredHTTPBridgeIotConnector(registryID),
+			deviceID:  deviceID,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported device protocol %d", protocol)
+}
+
+// NewConfiguredDeviceClient create a DeviceClient over the protocol set in device.protocol, MQTT by default.
+func NewConfiguredDeviceClient(registryID, deviceID string) (DeviceClient, error) {
+	protocol := connectors.MQTT
+	if name := configuration.New().DeviceProtocol; len(name) > 0 {
+		var err error
+		if protocol, err = connectors.ParseProtocol(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewDeviceClient(protocol, registryID, deviceID)
+}
+
+// NewProfileDeviceClient create a DeviceClient over the protocol set in the device.protocol of a
+// configuration profile, with its own connector.
+func NewProfileDeviceClient(profile, registryID, deviceID string) (DeviceClient, error) {
+	conf, err := configuration.LoadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	protocol := connectors.MQTT
+	if len(conf.DeviceProtocol) > 0 {
+		if protocol, err = connectors.ParseProtocol(conf.DeviceProtocol); err != nil {
+			return nil, err
+		}
+	}
+
+	switch protocol {
+	case connectors.MQTT:
+		connector, err := NewProfileMQTTIotConnector(profile, registryID, deviceID, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &mqttDeviceClient{connector: connector.(*MQTTIotDeviceConnector), deviceID: deviceID}, nil
+	case connectors.HTTP:
+		connector, err := NewProfileHTTPBridgeIotConnector(profile, registryID, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &httpDeviceClient{connector: connector, deviceID: deviceID}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported device protocol %d", protocol)
+}
+
+// NewProfileMQTTDeviceClient create an MQTT DeviceClient of a configuration profile authenticated with
+// its own keyStore, so many devices can share one process.
+func NewProfileMQTTDeviceClient(profile, registryID, deviceID string, keyStore keystore.KeyStore) (DeviceClient, error) {
+	connector, err := NewProfileMQTTIotConnector(profile, registryID, deviceID, keyStore)
+	if err != nil {
+		return nil, err
+	}
+	return &mqttDeviceClient{connector: connector.(*MQTTIotDeviceConnector), deviceID: deviceID}, nil
+}
+
+type mqttDeviceClient struct {
+	connector *MQTTIotDeviceConnector
+	deviceID  string
+}
+
+func (client *mqttDeviceClient) PublishTelemetry(message *telemetry.Telemetry) error {
+	token, err := client.connector.PublishTelemetry(client.deviceID, message, connectors.AtLeastOnce)
+	if err != nil {
+		return err
+	}
+	token.Wait()
+	return token.Error()
+}
+
+func (client *mqttDeviceClient) ReportState(state []byte) error {
+	token := client.connector.PublishState(client.deviceID, string(state), connectors.AtLeastOnce)
+	token.Wait()
+	return token.Error()
+}
+
+func (client *mqttDeviceClient) OnConfig(handler func(config []byte)) error {
+	token := client.connector.SubscribeConfig(client.deviceID, handler)
+	token.Wait()
+	return token.Error()
+}
+
+func (client *mqttDeviceClient) OnCommand(handler func(subfolder string, command []byte)) error {
+	token := client.connector.SubscribeCommands(client.deviceID, handler)
+	token.Wait()
+	return token.Error()
+}
+
+func (client *mqttDeviceClient) Close() error {
+	if client.connector.unsubscribe != nil {
+		client.connector.unsubscribe()
+	}
+	client.connector.client().Disconnect(mqttDisconnectQuiesceMs)
+
+	client.connector.clientMutex.RLock()
+	defer client.connector.clientMutex.RUnlock()
+	client.connector.tunnels.Close()
+	return nil
+}
+
+type httpDeviceClient struct {
+	connector HTTPBridgeDeviceConnectorInterface
+	deviceID  string
+	mutex     sync.Mutex
+	stops     []func()
+}
+
+func (client *httpDeviceClient) PublishTelemetry(message *telemetry.Telemetry) error {
+	return client.connector.PublishTelemetry(client.deviceID, message)
+}
+
+func (client *httpDeviceClient) ReportState(state []byte) error {
+	return client.connector.PublishState(client.deviceID, string(state))
+}
+
+func (client *httpDeviceClient) OnConfig(handler func(config []byte)) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	stop := client.connector.PollConfig(client.deviceID, httpBridgeConfigPollSecond*time.Second, handler)
+	client.stops = append(client.stops, stop)
+	return nil
+}
+
+func (client *httpDeviceClient) OnCommand(handler func(subfolder string, command []byte)) error {
+	return ErrCommandsUnsupported
+}
+
+func (client *httpDeviceClient) Close() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	for _, stop := range client.stops {
+		stop()
+	}
+	client.stops = nil
+	return client.connector.Close()
+}
@@ -0,0 +1,115 @@
+package device_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/registry"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type DeviceClientTestSuite struct {
+	suite.Suite
+	configuration *configuration.Configuration
+	registryID    string
+	deviceID      string
+	otherDeviceID string
+}
+
+func (suite *DeviceClientTestSuite) TestPublishTelemetry() {
+	for _, protocol := range []connectors.Protocol{connectors.MQTT, connectors.HTTP} {
+		client, err := device.NewDeviceClient(protocol, suite.registryID, suite.deviceID)
+		assert.NoError(suite.T(), err, "UnexpectedError")
+
+		err = client.PublishTelemetry(&telemetry.Telemetry{Payload: []byte("test")})
+		assert.NoError(suite.T(), err, "error publish over "+protocol.String())
+	}
+}
+
+func (suite *DeviceClientTestSuite) TestReportState() {
+	for _, protocol := range []connectors.Protocol{connectors.MQTT, connectors.HTTP} {
+		client, err := device.NewDeviceClient(protocol, suite.registryID, suite.deviceID)
+		assert.NoError(suite.T(), err, "UnexpectedError")
+
+		err = client.ReportState([]byte("{status:'ok'}"))
+		assert.NoError(suite.T(), err, "error report state over "+protocol.String())
+	}
+}
+
+func (suite *DeviceClientTestSuite) TestHTTPOnCommandUnsupported() {
+	client, err := device.NewDeviceClient(connectors.HTTP, suite.registryID, suite.deviceID)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	err = client.OnCommand(func(subfolder string, command []byte) {})
+	assert.EqualValues(suite.T(), device.ErrCommandsUnsupported, err)
+	assert.NoError(suite.T(), client.Close())
+}
+
+func (suite *DeviceClientTestSuite) TestClientsPerDevice() {
+	for _, protocol := range []connectors.Protocol{connectors.MQTT, connectors.HTTP} {
+		first, err := device.NewDeviceClient(protocol, suite.registryID, suite.deviceID)
+		assert.NoError(suite.T(), err, "UnexpectedError")
+		second, err := device.NewDeviceClient(protocol, suite.registryID, suite.otherDeviceID)
+		assert.NoError(suite.T(), err, "UnexpectedError")
+
+		assert.NoError(suite.T(), first.ReportState([]byte("{device:1}")), "error report state over "+protocol.String())
+		assert.NoError(suite.T(), second.ReportState([]byte("{device:2}")), "error report state over "+protocol.String())
+
+		// closing a device client must leave the other device connected
+		assert.NoError(suite.T(), first.Close())
+		err = second.PublishTelemetry(&telemetry.Telemetry{Payload: []byte("test")})
+		assert.NoError(suite.T(), err, "error publish over "+protocol.String())
+		assert.NoError(suite.T(), second.Close())
+	}
+}
+
+func (suite *DeviceClientTestSuite) SetupTest() {
+	connector := registry.NewHTTPIotRegistryConnector(connectors.HTTP, suite.configuration.GcloudProjectID, suite.configuration.GcloudRegion)
+
+	eventNotificationConfigs := []*cloudiot.EventNotificationConfig{
+		{
+			PubsubTopicName: connector.GenerateTopicName(suite.configuration.DeviceTelemetryTopic),
+		},
+	}
+
+	_, err := connector.CreateRegistry(suite.registryID, eventNotificationConfigs)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	connectorHTTPDevices := device.NewDeviceHTTPIotConnector(suite.registryID)
+	connectorHTTPDevices.SwapToRegistry(suite.registryID)
+	connectorHTTPDevices.CreateDevice(suite.deviceID)
+	connectorHTTPDevices.CreateDevice(suite.otherDeviceID)
+}
+
+func (suite *DeviceClientTestSuite) TearDownTest() {
+	connector := registry.NewHTTPIotRegistryConnector(connectors.HTTP, suite.configuration.GcloudProjectID, suite.configuration.GcloudRegion)
+	connectorHTTPDevices := device.NewDeviceHTTPIotConnector(suite.registryID)
+
+	deviceList, _ := connectorHTTPDevices.ListDevices()
+	for _, device := range deviceList {
+		connectorHTTPDevices.DeleteDevice(device.Id)
+	}
+
+	connector.DeleteRegistry(suite.registryID)
+}
+
+func TestDeviceClientTestSuite(t *testing.T) {
+
+	configInit()
+	rand.Seed(time.Now().UnixNano())
+
+	iotReg := new(DeviceClientTestSuite)
+	iotReg.configuration = configuration.New()
+	iotReg.registryID = "test-registry-" + randStringRunes(4)
+	iotReg.deviceID = "test-device-" + randStringRunes(4)
+	iotReg.otherDeviceID = "test-device-" + randStringRunes(4)
+
+	suite.Run(t, iotReg)
+}
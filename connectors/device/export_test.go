@@ -0,0 +1,12 @@
+package device
+
+import (
+	"crypto/tls"
+	"net/url"
+)
+
+// MQTTBrokerURLs expose mqttBrokerURLs to the device_test package.
+var MQTTBrokerURLs = func(brokers []*url.URL, mqttProxy string, tlsConfig *tls.Config) ([]string, func(), error) {
+	brokerURLs, tunnels, err := mqttBrokerURLs(brokers, mqttProxy, tlsConfig)
+	return brokerURLs, tunnels.Close, err
+}
@@ -0,0 +1,347 @@
+package device
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/pjgg/iotPlayground/keystore"
+	"google.golang.org/api/googleapi"
+)
+
+// HTTPBridgeDeviceConnector handler devices telemetry communication through the Cloud IoT HTTP bridge.
+type HTTPBridgeDeviceConnector struct {
+	sequence           uint64 // first field to keep 64 bit atomic alignment on 32 bit devices
+	HTTPClient         *http.Client
+	endpoint           string
+	keyStore           keystore.KeyStore
+	projectID          string
+	region             string
+	registryID         string
+	jwtOptions         connectors.JWTOptions
+	clock              *connectors.OffsetClock // corrected from the bridge Date header when a token is rejected
+	jwtMutex           sync.Mutex
+	jwt                string
+	jwtExpirationTime  time.Time
+	session            string
+	configuredKeyStore bool   // keyStore follows the configuration and is rebuilt on reload
+	telemetrySubfolder string // from device.telemetryTopic, guarded by jwtMutex as it follows reloads
+	unsubscribe        func()
+}
+
+// HTTPBridgeDeviceConnectorInterface define device telemetry behavior over HTTPS, same operations as
+// the MQTT connector with synchronous errors instead of tokens.
+type HTTPBridgeDeviceConnectorInterface interface {
+	PublishMsg(toDeviceID, topicName, msg string) error
+	PublishTelemetry(toDeviceID string, message *telemetry.Telemetry) error
+	PublishState(toDeviceID, state string) error
+	GetConfig(toDeviceID string, localVersion int64) (*BridgeDeviceConfig, error)
+	PollConfig(toDeviceID string, interval time.Duration, handler func(config []byte)) (stop func())
+	Close() error
+}
+
+// BridgeDeviceConfig is a device config version as returned by the HTTP bridge.
+type BridgeDeviceConfig struct {
+	Version         int64
+	CloudUpdateTime string
+	BinaryData      []byte
+}
+
+var onceHTTPBridgeDevice sync.Once
+var httpBridgeDeviceConnector HTTPBridgeDeviceConnector
+
+const defaultHTTPBridgeEndpoint = "https://cloudiotdevice.googleapis.com/v1"
+const httpBridgeTimeoutSecond = 30
+
+// NewHTTPBridgeIotConnector create a single HTTPBridgeDeviceConnector instance.
+func NewHTTPBridgeIotConnector(registryID string) HTTPBridgeDeviceConnectorInterface {
+	return NewHTTPBridgeIotConnectorWithKeyStore(registryID, nil)
+}
+
+// NewHTTPBridgeIotConnectorWithKeyStore create a single HTTPBridgeDeviceConnector instance signing its JWT
+// with keyStore, the configured device key store when nil.
+func NewHTTPBridgeIotConnectorWithKeyStore(registryID string, keyStore keystore.KeyStore) HTTPBridgeDeviceConnectorInterface {
+
+	onceHTTPBridgeDevice.Do(func() {
+		httpBridgeDeviceConnector.init(configuration.New(), registryID, keyStore)
+		configuration.Subscribe(httpBridgeDeviceConnector.onConfigurationChange)
+	})
+
+	return &httpBridgeDeviceConnector
+}
+
+// NewProfileHTTPBridgeIotConnector create an HTTPBridgeDeviceConnector bound to a configuration profile,
+// every call returns a new instance so profiles can be used side by side. keyStore is the profile device
+// key store when nil.
+func NewProfileHTTPBridgeIotConnector(profile, registryID string, keyStore keystore.KeyStore) (HTTPBridgeDeviceConnectorInterface, error) {
+	conf, err := configuration.LoadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &HTTPBridgeDeviceConnector{}
+	connector.init(conf, registryID, keyStore)
+	connector.unsubscribe = configuration.SubscribeProfile(profile, connector.onConfigurationChange)
+
+	return connector, nil
+}
+
+// newConfiguredHTTPBridgeIotConnector create an HTTPBridgeDeviceConnector of the active configuration, a new
+// instance on every call unlike NewHTTPBridgeIotConnector.
+func newConfiguredHTTPBridgeIotConnector(registryID string) *HTTPBridgeDeviceConnector {
+	connector := &HTTPBridgeDeviceConnector{}
+	connector.init(configuration.New(), registryID, nil)
+	connector.unsubscribe = configuration.Subscribe(connector.onConfigurationChange)
+
+	return connector
+}
+
+// VerifyHTTPBridgeConnect returns a check that fetch the device config through the HTTP bridge with a JWT
+// signed by privateKeyPath, used to confirm a device credential before revoking the previous one. The bridge
+// does not hold sessions, so a device connected over MQTT is not disconnected.
+func VerifyHTTPBridgeConnect(registryID, deviceID, privateKeyPath string) func() error {
+	return func() error {
+		conf := configuration.New()
+		connector := &HTTPBridgeDeviceConnector{}
+		connector.init(conf, registryID, keystore.NewFileKeyStore(privateKeyPath, []byte(conf.DevicePrivateKeyPassphrase)))
+
+		_, err := connector.GetConfig(deviceID, 0)
+		return err
+	}
+}
+
+func (iotConnector *HTTPBridgeDeviceConnector) init(conf *configuration.Configuration, registryID string, keyStore keystore.KeyStore) {
+	if keyStore == nil {
+		keyStore = keystore.FromConfiguration(conf)
+		iotConnector.configuredKeyStore = true
+	}
+	iotConnector.HTTPClient = &http.Client{Timeout: httpBridgeTimeoutSecond * time.Second}
+	iotConnector.endpoint = strings.TrimSuffix(conf.HTTPBridgeEndpoint, "/")
+	if len(iotConnector.endpoint) == 0 {
+		iotConnector.endpoint = defaultHTTPBridgeEndpoint
+	}
+	iotConnector.registryID = registryID
+	iotConnector.keyStore = keyStore
+	iotConnector.projectID = conf.GcloudProjectID
+	iotConnector.region = conf.GcloudRegion
+	iotConnector.clock = &connectors.OffsetClock{}
+	iotConnector.clock.SetOffset(connectors.DefaultClock.Offset())
+	iotConnector.jwtOptions = connectors.DeviceJWTOptions(conf)
+	iotConnector.jwtOptions.Clock = iotConnector.clock
+	iotConnector.telemetrySubfolder = telemetry.Subfolder(conf.DeviceTelemetryTopic)
+	iotConnector.session = newSession()
+}
+
+// PublishMsg push a telemetry event, topicName is events or events/<subfolder> as for the MQTT connector.
+func (iotConnector *HTTPBridgeDeviceConnector) PublishMsg(toDeviceID, topicName, msg string) error {
+	body := map[string]string{
+		"binary_data": base64.StdEncoding.EncodeToString([]byte(msg)),
+	}
+	if subfolder := telemetry.Subfolder(topicName); len(subfolder) > 0 {
+		body["sub_folder"] = subfolder
+	}
+
+	log.Info("Publish Msg to device " + toDeviceID + " over HTTP bridge")
+	return iotConnector.do(http.MethodPost, iotConnector.devicePath(toDeviceID)+":publishEvent", body, nil)
+}
+
+// PublishTelemetry stamp and push a telemetry event, see MQTTIotDeviceConnector.PublishTelemetry.
+func (iotConnector *HTTPBridgeDeviceConnector) PublishTelemetry(toDeviceID string, message *telemetry.Telemetry) error {
+	message.Sequence = atomic.AddUint64(&iotConnector.sequence, 1)
+	message.Session = iotConnector.session
+	if len(message.Subfolder) == 0 {
+		iotConnector.jwtMutex.Lock()
+		message.Subfolder = iotConnector.telemetrySubfolder
+		iotConnector.jwtMutex.Unlock()
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now().UTC()
+	}
+
+	envelope, err := message.Encode()
+	if err != nil {
+		log.Errorln(err.Error())
+		return err
+	}
+
+	return iotConnector.PublishMsg(toDeviceID, message.Topic(), string(envelope))
+}
+
+// PublishState push a device state.
+func (iotConnector *HTTPBridgeDeviceConnector) PublishState(toDeviceID, state string) error {
+	body := map[string]interface{}{
+		"state": map[string]string{
+			"binary_data": base64.StdEncoding.EncodeToString([]byte(state)),
+		},
+	}
+
+	return iotConnector.do(http.MethodPost, iotConnector.devicePath(toDeviceID)+":setState", body, nil)
+}
+
+// GetConfig retrieve the latest device config. The bridge answers right away, local_version only tells it
+// which version the device already has, so callers poll for newer versions.
+func (iotConnector *HTTPBridgeDeviceConnector) GetConfig(toDeviceID string, localVersion int64) (config *BridgeDeviceConfig, err error) {
+	var response struct {
+		Version         string `json:"version"`
+		CloudUpdateTime string `json:"cloudUpdateTime"`
+		BinaryData      string `json:"binaryData"`
+	}
+
+	path := fmt.Sprintf("%s/config?local_version=%d", iotConnector.devicePath(toDeviceID), localVersion)
+	if err = iotConnector.do(http.MethodGet, path, nil, &response); err != nil {
+		return
+	}
+
+	config = &BridgeDeviceConfig{CloudUpdateTime: response.CloudUpdateTime}
+	if config.Version, err = strconv.ParseInt(response.Version, 10, 64); err != nil {
+		return nil, err
+	}
+	if config.BinaryData, err = base64.StdEncoding.DecodeString(response.BinaryData); err != nil {
+		return nil, err
+	}
+
+	log.Debugln("Successfully retrieved device config version ", config.Version)
+	return
+}
+
+// PollConfig call handler every time a new config version is found, until stop is called.
+func (iotConnector *HTTPBridgeDeviceConnector) PollConfig(toDeviceID string, interval time.Duration, handler func(config []byte)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+
+	go func() {
+		var localVersion int64
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if config, err := iotConnector.GetConfig(toDeviceID, localVersion); err != nil {
+				log.Errorln("HTTP bridge config polling fail: " + err.Error())
+			} else if config.Version > localVersion {
+				localVersion = config.Version
+				handler(config.BinaryData)
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return
+}
+
+func (iotConnector *HTTPBridgeDeviceConnector) devicePath(deviceID string) string {
+	return fmt.Sprintf("%s/projects/%s/locations/%s/registries/%s/devices/%s", iotConnector.endpoint, iotConnector.projectID, iotConnector.region, iotConnector.registryID, deviceID)
+}
+
+func (iotConnector *HTTPBridgeDeviceConnector) do(method, url string, body interface{}, result interface{}) error {
+	token, err := iotConnector.token()
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err = json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := iotConnector.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// a token rejected because of the device clock is signed again with the bridge time
+		if err := iotConnector.clock.SyncFromDateHeader(resp, time.Now()); err == nil {
+			iotConnector.resetToken()
+		}
+	}
+
+	if err = googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+
+	return nil
+}
+
+// token returns a cached JWT, a new one is signed a minute before the current one expires.
+func (iotConnector *HTTPBridgeDeviceConnector) token() (string, error) {
+	iotConnector.jwtMutex.Lock()
+	defer iotConnector.jwtMutex.Unlock()
+
+	if len(iotConnector.jwt) > 0 && time.Now().Add(time.Minute).Before(iotConnector.jwtExpirationTime) {
+		return iotConnector.jwt, nil
+	}
+
+	jwt, err := connectors.GenerateJWTWithOptions(iotConnector.projectID, iotConnector.keyStore, iotConnector.jwtOptions)
+	if err != nil {
+		return "", err
+	}
+
+	iotConnector.jwt = jwt
+	iotConnector.jwtExpirationTime = time.Now().Add(iotConnector.jwtOptions.Expiration)
+	return jwt, nil
+}
+
+// onConfigurationChange sign the next requests with the reloaded key and JWT settings, and publish the next
+// telemetry events to the reloaded topic.
+func (iotConnector *HTTPBridgeDeviceConnector) onConfigurationChange(previous, current *configuration.Configuration) {
+	iotConnector.jwtMutex.Lock()
+	defer iotConnector.jwtMutex.Unlock()
+
+	iotConnector.telemetrySubfolder = telemetry.Subfolder(current.DeviceTelemetryTopic)
+	iotConnector.jwtOptions = connectors.DeviceJWTOptions(current)
+	iotConnector.jwtOptions.Clock = iotConnector.clock
+	if iotConnector.configuredKeyStore {
+		iotConnector.keyStore = keystore.FromConfiguration(current)
+	}
+	iotConnector.jwt = ""
+}
+
+// resetToken drop the cached JWT, the next request signs a new one.
+func (iotConnector *HTTPBridgeDeviceConnector) resetToken() {
+	iotConnector.jwtMutex.Lock()
+	defer iotConnector.jwtMutex.Unlock()
+
+	iotConnector.jwt = ""
+}
+
+// Close stop following configuration reloads, a profile connector is no longer notified once closed.
+func (iotConnector *HTTPBridgeDeviceConnector) Close() error {
+	if iotConnector.unsubscribe != nil {
+		iotConnector.unsubscribe()
+		iotConnector.unsubscribe = nil
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package device_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/configuration"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/registry"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type HTTPBridgeDeviceConnectorTestSuite struct {
+	suite.Suite
+	configuration *configuration.Configuration
+	registryID    string
+	deviceID      string
+}
+
+func (suite *HTTPBridgeDeviceConnectorTestSuite) TestPublishMsg() {
+	connector := device.NewHTTPBridgeIotConnector(suite.registryID)
+	err := connector.PublishMsg(suite.deviceID, suite.configuration.DeviceTelemetryTopic, "test")
+	assert.NoError(suite.T(), err, "error publish HTTP bridge")
+}
+
+func (suite *HTTPBridgeDeviceConnectorTestSuite) TestPublishTelemetry() {
+	connector := device.NewHTTPBridgeIotConnector(suite.registryID)
+	message := &telemetry.Telemetry{Subfolder: "alerts", Payload: []byte("test")}
+
+	err := connector.PublishTelemetry(suite.deviceID, message)
+	assert.NoError(suite.T(), err, "error publish HTTP bridge")
+	assert.NotEmpty(suite.T(), message.Session)
+	assert.True(suite.T(), message.Sequence > 0)
+}
+
+func (suite *HTTPBridgeDeviceConnectorTestSuite) TestPublishState() {
+	connector := device.NewHTTPBridgeIotConnector(suite.registryID)
+	err := connector.PublishState(suite.deviceID, "{status:'ok'}")
+	assert.NoError(suite.T(), err, "error set state HTTP bridge")
+}
+
+func (suite *HTTPBridgeDeviceConnectorTestSuite) TestGetConfig() {
+	device.NewDeviceHTTPIotConnector(suite.registryID).SetDeviceConfig(suite.deviceID, "{networkID:'myNetworkID'}")
+	connector := device.NewHTTPBridgeIotConnector(suite.registryID)
+
+	config, err := connector.GetConfig(suite.deviceID, 0)
+	assert.NoError(suite.T(), err, "error get config HTTP bridge")
+	assert.EqualValues(suite.T(), "{networkID:'myNetworkID'}", string(config.BinaryData))
+}
+
+func (suite *HTTPBridgeDeviceConnectorTestSuite) SetupTest() {
+	connector := registry.NewHTTPIotRegistryConnector(connectors.HTTP, suite.configuration.GcloudProjectID, suite.configuration.GcloudRegion)
+
+	eventNotificationConfigs := []*cloudiot.EventNotificationConfig{
+		{
+			PubsubTopicName: connector.GenerateTopicName(suite.configuration.DeviceTelemetryTopic),
+		},
+	}
+
+	_, err := connector.CreateRegistry(suite.registryID, eventNotificationConfigs)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	connectorHTTPDevices := device.NewDeviceHTTPIotConnector(suite.registryID)
+	connectorHTTPDevices.SwapToRegistry(suite.registryID)
+	connectorHTTPDevices.CreateDevice(suite.deviceID)
+}
+
+func (suite *HTTPBridgeDeviceConnectorTestSuite) TearDownTest() {
+	connector := registry.NewHTTPIotRegistryConnector(connectors.HTTP, suite.configuration.GcloudProjectID, suite.configuration.GcloudRegion)
+	connectorHTTPDevices := device.NewDeviceHTTPIotConnector(suite.registryID)
+
+	deviceList, _ := connectorHTTPDevices.ListDevices()
+	for _, device := range deviceList {
+		connectorHTTPDevices.DeleteDevice(device.Id)
+	}
+
+	connector.DeleteRegistry(suite.registryID)
+}
+
+func TestHTTPBridgeDeviceConnectorTestSuite(t *testing.T) {
+
+	configInit()
+	rand.Seed(time.Now().UnixNano())
+
+	iotReg := new(HTTPBridgeDeviceConnectorTestSuite)
+	iotReg.configuration = configuration.New()
+	iotReg.registryID = "test-registry-" + randStringRunes(4)
+	iotReg.deviceID = "test-device-" + randStringRunes(4)
+
+	suite.Run(t, iotReg)
+}
@@ -4,8 +4,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pjgg/iotPlayground/configuration"
@@ -30,13 +31,21 @@ type HTTPIotDeviceConnector struct {
 type HTTPIotDeviceConnectorInterface interface {
 	SwapToRegistry(registryID string)
 	CreateDevice(deviceID string) (*cloudiot.Device, error)
+	CreateDeviceFrom(deviceDef *cloudiot.Device) (*cloudiot.Device, error)
 	DeleteDevice(deviceID string) (*cloudiot.Empty, error)
 	GetDevice(deviceID string) (*cloudiot.Device, error)
 	SetDeviceConfig(deviceID string, configData string) (*cloudiot.DeviceConfig, error)
+	SetDeviceConfigVersion(deviceID string, configData string, versionToUpdate int64) (*cloudiot.DeviceConfig, error)
 	GetDeviceConfigs(deviceID string) ([]*cloudiot.DeviceConfig, error)
 	GetDeviceStates(deviceID string) ([]*cloudiot.DeviceState, error)
 	ListDevices() ([]*cloudiot.Device, error)
+	ListBoundDevices(gatewayID string) ([]*cloudiot.Device, error)
 	PatchDevice(deviceID string, newDevice *cloudiot.Device, field string) (*cloudiot.Device, error)
+	AddCredential(deviceID, publicKeyPath string, keyType connectors.KeyType, expirationTime time.Time) (*cloudiot.Device, error)
+	ListCredentials(deviceID string) ([]*cloudiot.DeviceCredential, error)
+	RemoveCredential(deviceID, publicKeyPath string) (*cloudiot.Device, error)
+	RemoveExpiredCredentials(deviceID string) (*cloudiot.Device, error)
+	RotateCredential(deviceID string, rotation CredentialRotation) error
 }
 
 var onceHTTPDevice sync.Once
@@ -46,29 +55,51 @@ var httpIotDeviceConnector HTTPIotDeviceConnector
 func NewDeviceHTTPIotConnector(registryID string) HTTPIotDeviceConnectorInterface {
 
 	onceHTTPDevice.Do(func() {
-		conf := configuration.New()
-		ctx := context.Background()
-
-		httpClient, err := google.DefaultClient(ctx, cloudiot.CloudPlatformScope)
+		connector, err := newHTTPIotDeviceConnector(configuration.New(), registryID)
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
+		httpIotDeviceConnector = *connector
+	})
 
-		httpIotDeviceConnector.HTTPClient, err = cloudiot.New(httpClient)
-		if err != nil {
-			log.Fatalln(err.Error())
-		}
+	return &httpIotDeviceConnector
+}
 
-		httpIotDeviceConnector.registryID = registryID
-		httpIotDeviceConnector.publicKeyPath = conf.DevicePublicKeyPath
-		httpIotDeviceConnector.privateKeyPath = conf.DevicePrivateKeyPath
-		httpIotDeviceConnector.keyType = connectors.RsaPem
-		httpIotDeviceConnector.projectID = conf.GcloudProjectID
-		httpIotDeviceConnector.region = conf.GcloudRegion
+// NewProfileDeviceHTTPIotConnector create an HTTPIotDeviceConnector bound to the project and region of a
+// configuration profile, every call returns a new instance so profiles can be used side by side.
+func NewProfileDeviceHTTPIotConnector(profile, registryID string) (HTTPIotDeviceConnectorInterface, error) {
+	conf, err := configuration.LoadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
 
-	})
+	return newHTTPIotDeviceConnector(conf, registryID)
+}
 
-	return &httpIotDeviceConnector
+func newHTTPIotDeviceConnector(conf *configuration.Configuration, registryID string) (*HTTPIotDeviceConnector, error) {
+	httpClient, err := google.DefaultClient(context.Background(), cloudiot.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &HTTPIotDeviceConnector{}
+	if connector.HTTPClient, err = cloudiot.New(httpClient); err != nil {
+		return nil, err
+	}
+
+	connector.registryID = registryID
+	connector.publicKeyPath = conf.DevicePublicKeyPath
+	connector.privateKeyPath = conf.DevicePrivateKeyPath
+	connector.keyType = connectors.RsaPem
+	if len(conf.DeviceKeyType) > 0 {
+		if connector.keyType, err = connectors.ParseKeyType(conf.DeviceKeyType); err != nil {
+			return nil, err
+		}
+	}
+	connector.projectID = conf.GcloudProjectID
+	connector.region = conf.GcloudRegion
+
+	return connector, nil
 }
 
 // SwapToRegistry overwrite HTTP otDeviceConnector local device registry ID, so all device request will be thrown against this registryID
@@ -96,8 +127,25 @@ func (iotConnector *HTTPIotDeviceConnector) CreateDevice(deviceID string) (devic
 		},
 	}
 
+	return iotConnector.CreateDeviceFrom(&deviceDef)
+}
+
+// CreateDeviceFrom will create a device with its own credentials, metadata and blocked state over a previous
+// given registryID, X.509 credentials are checked against the registry CAs first.
+func (iotConnector *HTTPIotDeviceConnector) CreateDeviceFrom(deviceDef *cloudiot.Device) (device *cloudiot.Device, err error) {
+	for _, credential := range deviceDef.Credentials {
+		if credential.PublicKey == nil {
+			continue
+		}
+		if keyType, parseErr := connectors.ParseKeyType(credential.PublicKey.Format); parseErr == nil && keyType.IsX509() {
+			if err = iotConnector.verifyRegistryChain([]byte(credential.PublicKey.Key)); err != nil {
+				return
+			}
+		}
+	}
+
 	parent := fmt.Sprintf("projects/%s/locations/%s/registries/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID)
-	if device, err = iotConnector.HTTPClient.Projects.Locations.Registries.Devices.Create(parent, &deviceDef).Do(); err == nil {
+	if device, err = iotConnector.HTTPClient.Projects.Locations.Registries.Devices.Create(parent, deviceDef).Do(); err == nil {
 		log.Debugln("Successfully created device.")
 		log.Debugln("\tID: ", device.Id)
 		log.Debugln("\tName: ", device.Name)
@@ -141,7 +189,8 @@ func (iotConnector *HTTPIotDeviceConnector) GetDevice(deviceID string) (device *
 // GetDeviceConfigs will retrieve a device configuration, if is a member of a registryID.
 func (iotConnector *HTTPIotDeviceConnector) GetDeviceConfigs(deviceID string) (configs []*cloudiot.DeviceConfig, err error) {
 	path := fmt.Sprintf("projects/%s/locations/%s/registries/%s/devices/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID, deviceID)
-	if response, err := iotConnector.HTTPClient.Projects.Locations.Registries.Devices.ConfigVersions.List(path).Do(); err == nil {
+	response, err := iotConnector.HTTPClient.Projects.Locations.Registries.Devices.ConfigVersions.List(path).Do()
+	if err == nil {
 		log.Debugln("Successfully retrieved device config!")
 		configs = response.DeviceConfigs
 		for _, config := range response.DeviceConfigs {
@@ -155,7 +204,8 @@ func (iotConnector *HTTPIotDeviceConnector) GetDeviceConfigs(deviceID string) (c
 // GetDeviceStates will retrieve a device states, if is a member of a registryID.
 func (iotConnector *HTTPIotDeviceConnector) GetDeviceStates(deviceID string) (states []*cloudiot.DeviceState, err error) {
 	path := fmt.Sprintf("projects/%s/locations/%s/registries/%s/devices/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID, deviceID)
-	if response, err := iotConnector.HTTPClient.Projects.Locations.Registries.Devices.States.List(path).Do(); err == nil {
+	response, err := iotConnector.HTTPClient.Projects.Locations.Registries.Devices.States.List(path).Do()
+	if err == nil {
 		log.Debugln("Successfully retrieved device states!")
 		states = response.DeviceStates
 		for _, state := range response.DeviceStates {
@@ -169,14 +219,29 @@ func (iotConnector *HTTPIotDeviceConnector) GetDeviceStates(deviceID string) (st
 // ListDevices will retrieve a list of devices that are member of a registryID.
 func (iotConnector *HTTPIotDeviceConnector) ListDevices() (devices []*cloudiot.Device, err error) {
 	parent := fmt.Sprintf("projects/%s/locations/%s/registries/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID)
-	if response, err := iotConnector.HTTPClient.Projects.Locations.Registries.Devices.List(parent).Do(); err == nil {
+	err = iotConnector.HTTPClient.Projects.Locations.Registries.Devices.List(parent).Pages(context.Background(), func(response *cloudiot.ListDevicesResponse) error {
+		devices = append(devices, response.Devices...)
+		return nil
+	})
+	if err == nil {
 		log.Debugln("Successfully retrieved devices!")
-		devices = response.Devices
 		log.Debugln("Devices:")
-		for _, device := range response.Devices {
+		for _, device := range devices {
 			log.Debugln("\t", device.Id)
 		}
+	}
+	return
+}
 
+// ListBoundDevices will retrieve the devices bound to gatewayID, a gateway of the registryID.
+func (iotConnector *HTTPIotDeviceConnector) ListBoundDevices(gatewayID string) (devices []*cloudiot.Device, err error) {
+	parent := fmt.Sprintf("projects/%s/locations/%s/registries/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID)
+	err = iotConnector.HTTPClient.Projects.Locations.Registries.Devices.List(parent).GatewayListOptionsAssociationsGatewayId(gatewayID).Pages(context.Background(), func(response *cloudiot.ListDevicesResponse) error {
+		devices = append(devices, response.Devices...)
+		return nil
+	})
+	if err == nil {
+		log.Debugln("Successfully retrieved devices bound to " + gatewayID + "!")
 	}
 	return
 }
@@ -194,14 +259,147 @@ func (iotConnector *HTTPIotDeviceConnector) PatchDevice(deviceID string, newDevi
 
 // SetDeviceConfig will update and push to server a device configuration.
 func (iotConnector *HTTPIotDeviceConnector) SetDeviceConfig(deviceID string, configData string) (deviceConfig *cloudiot.DeviceConfig, err error) {
+	return iotConnector.SetDeviceConfigVersion(deviceID, configData, 0)
+}
+
+// SetDeviceConfigVersion push a device configuration only if versionToUpdate is still the latest version,
+// the server rejects it otherwise. Zero pushes it whatever the latest version is.
+func (iotConnector *HTTPIotDeviceConnector) SetDeviceConfigVersion(deviceID string, configData string, versionToUpdate int64) (deviceConfig *cloudiot.DeviceConfig, err error) {
 	req := cloudiot.ModifyCloudToDeviceConfigRequest{
-		BinaryData: base64.StdEncoding.EncodeToString([]byte(configData)),
+		BinaryData:      base64.StdEncoding.EncodeToString([]byte(configData)),
+		VersionToUpdate: versionToUpdate,
 	}
 
 	path := fmt.Sprintf("projects/%s/locations/%s/registries/%s/devices/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID, deviceID)
 	if deviceConfig, err = iotConnector.HTTPClient.Projects.Locations.Registries.Devices.ModifyCloudToDeviceConfig(path, &req).Do(); err == nil {
-		fmt.Fprintf(os.Stdout, "Config set!\nVersion now: %d", deviceConfig.Version)
+		log.Debugln("Config set! Version now: ", deviceConfig.Version)
+	}
+
+	return
+}
+
+// AddCredential register a new public key for a device, a zero expirationTime means the key never expires.
+func (iotConnector *HTTPIotDeviceConnector) AddCredential(deviceID, publicKeyPath string, keyType connectors.KeyType, expirationTime time.Time) (device *cloudiot.Device, err error) {
+	keyBytes, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if keyType.IsX509() {
+		if err = iotConnector.verifyRegistryChain(keyBytes); err != nil {
+			return
+		}
+	}
+
+	credentials, err := iotConnector.ListCredentials(deviceID)
+	if err != nil {
+		return
+	}
+
+	credential := &cloudiot.DeviceCredential{
+		PublicKey: &cloudiot.PublicKeyCredential{
+			Format: keyType.String(),
+			Key:    string(keyBytes),
+		},
+	}
+	if !expirationTime.IsZero() {
+		credential.ExpirationTime = expirationTime.UTC().Format(time.RFC3339)
+	}
+
+	if device, err = iotConnector.PatchDevice(deviceID, &cloudiot.Device{Credentials: append(credentials, credential)}, "credentials"); err == nil {
+		log.Debugln("Successfully added credential to device ", deviceID)
+	}
+
+	return
+}
+
+// ListCredentials retrieve the current credentials of a device.
+func (iotConnector *HTTPIotDeviceConnector) ListCredentials(deviceID string) (credentials []*cloudiot.DeviceCredential, err error) {
+	device, err := iotConnector.GetDevice(deviceID)
+	if err != nil {
+		return
+	}
+
+	credentials = device.Credentials
+	return
+}
+
+// RemoveCredential revoke the credential matching the public key stored in publicKeyPath.
+func (iotConnector *HTTPIotDeviceConnector) RemoveCredential(deviceID, publicKeyPath string) (device *cloudiot.Device, err error) {
+	keyBytes, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	return iotConnector.filterCredentials(deviceID, func(credential *cloudiot.DeviceCredential) bool {
+		return credential.PublicKey == nil || strings.TrimSpace(credential.PublicKey.Key) != strings.TrimSpace(string(keyBytes))
+	})
+}
+
+// RemoveExpiredCredentials revoke every credential whose expiration time is in the past.
+func (iotConnector *HTTPIotDeviceConnector) RemoveExpiredCredentials(deviceID string) (device *cloudiot.Device, err error) {
+	now := time.Now()
+	return iotConnector.filterCredentials(deviceID, func(credential *cloudiot.DeviceCredential) bool {
+		return !CredentialExpired(credential, now)
+	})
+}
+
+// CredentialExpired tells if a credential expired at a given time. The API reports credentials without
+// expiration with the unix epoch.
+func CredentialExpired(credential *cloudiot.DeviceCredential, at time.Time) bool {
+	expirationTime, err := time.Parse(time.RFC3339, credential.ExpirationTime)
+	if err != nil || expirationTime.Unix() <= 0 {
+		return false
+	}
+	return expirationTime.Before(at)
+}
+
+func (iotConnector *HTTPIotDeviceConnector) filterCredentials(deviceID string, keep func(*cloudiot.DeviceCredential) bool) (device *cloudiot.Device, err error) {
+	credentials, err := iotConnector.ListCredentials(deviceID)
+	if err != nil {
+		return
+	}
+
+	kept := []*cloudiot.DeviceCredential{}
+	for _, credential := range credentials {
+		if keep(credential) {
+			kept = append(kept, credential)
+		}
+	}
+
+	if len(kept) == len(credentials) {
+		return iotConnector.GetDevice(deviceID)
+	}
+
+	patch := &cloudiot.Device{Credentials: kept, ForceSendFields: []string{"Credentials"}}
+	if device, err = iotConnector.PatchDevice(deviceID, patch, "credentials"); err == nil {
+		log.Debugln("Successfully removed ", len(credentials)-len(kept), " credentials from device ", deviceID)
 	}
 
 	return
 }
+
+// verifyRegistryChain check locally that a device certificate chains to one of the registry CAs, registries
+// without CA credentials accept any certificate.
+func (iotConnector *HTTPIotDeviceConnector) verifyRegistryChain(certBytes []byte) error {
+	parent := fmt.Sprintf("projects/%s/locations/%s/registries/%s", iotConnector.projectID, iotConnector.region, iotConnector.registryID)
+	registry, err := iotConnector.HTTPClient.Projects.Locations.Registries.Get(parent).Do()
+	if err != nil {
+		return err
+	}
+
+	var caCertPEMs []string
+	for _, credential := range registry.Credentials {
+		if credential.PublicKeyCertificate != nil {
+			caCertPEMs = append(caCertPEMs, credential.PublicKeyCertificate.Certificate)
+		}
+	}
+
+	if len(caCertPEMs) == 0 {
+		return nil
+	}
+
+	return connectors.VerifyCertificateChain(certBytes, caCertPEMs)
+}
@@ -3,6 +3,8 @@ package device_test
 import (
 	"encoding/base64"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -114,6 +116,41 @@ func (suite *IotDeviceConnectorTestSuite) TestPatchDevice() {
 
 }
 
+func (suite *IotDeviceConnectorTestSuite) TestAddCredential() {
+	deviceID := "my-test-device" + randStringRunes(4)
+	connectorDevices := device.NewDeviceHTTPIotConnector(suite.registryID)
+	connectorDevices.SwapToRegistry(suite.registryID)
+
+	connectorDevices.CreateDevice(deviceID)
+	expirationTime := time.Now().Add(time.Hour * 24)
+	deviceUpdated, err := connectorDevices.AddCredential(deviceID, suite.configuration.DevicePublicKeyPath, connectors.RsaPem, expirationTime)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), len(deviceUpdated.Credentials), 2)
+
+	credentials, err := connectorDevices.ListCredentials(deviceID)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), len(credentials), 2)
+	assert.EqualValues(suite.T(), credentials[1].ExpirationTime, expirationTime.UTC().Format(time.RFC3339))
+
+}
+
+func (suite *IotDeviceConnectorTestSuite) TestRemoveExpiredCredentials() {
+	deviceID := "my-test-device" + randStringRunes(4)
+	connectorDevices := device.NewDeviceHTTPIotConnector(suite.registryID)
+	connectorDevices.SwapToRegistry(suite.registryID)
+
+	connectorDevices.CreateDevice(deviceID)
+	deviceUpdated, err := connectorDevices.RemoveExpiredCredentials(deviceID)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), len(deviceUpdated.Credentials), 1)
+
+	credential := &cloudiot.DeviceCredential{ExpirationTime: "2018-01-01T00:00:00Z"}
+	assert.EqualValues(suite.T(), device.CredentialExpired(credential, time.Now()), true)
+	credential.ExpirationTime = "1970-01-01T00:00:00Z"
+	assert.EqualValues(suite.T(), device.CredentialExpired(credential, time.Now()), false)
+
+}
+
 type IotDeviceConnectorTestSuite struct {
 	suite.Suite
 	configuration *configuration.Configuration
@@ -156,6 +193,50 @@ func TestIotDeviceConnectorTestSuite(t *testing.T) {
 	suite.Run(t, iotReg)
 }
 
+type DeviceListPagesTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func (suite *DeviceListPagesTestSuite) TestListDevicesFollowsPages() {
+	service, err := cloudiot.New(http.DefaultClient)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	service.BasePath = suite.server.URL + "/"
+	connector := &device.HTTPIotDeviceConnector{HTTPClient: service}
+
+	devices, err := connector.ListDevices()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	ids := []string{}
+	for _, device := range devices {
+		ids = append(ids, device.Id)
+	}
+	assert.EqualValues(suite.T(), []string{"device-1", "device-2", "device-3"}, ids)
+}
+
+func (suite *DeviceListPagesTestSuite) SetupTest() {
+	pages := map[string]string{
+		"":       `{"devices": [{"id": "device-1"}, {"id": "device-2"}], "nextPageToken": "page-2"}`,
+		"page-2": `{"devices": [{"id": "device-3"}]}`,
+	}
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, exist := pages[r.URL.Query().Get("pageToken")]
+		if !exist {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(page))
+	}))
+}
+
+func (suite *DeviceListPagesTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func TestDeviceListPagesTestSuite(t *testing.T) {
+	suite.Run(t, new(DeviceListPagesTestSuite))
+}
+
 func randStringRunes(n int) string {
 	var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	b := make([]rune, n)
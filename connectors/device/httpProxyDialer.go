@@ -0,0 +1,82 @@
+package device
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// httpProxyDialer tunnel connections through an HTTP proxy with the CONNECT method. golang.org/x/net/proxy
+// only knows socks5, registering http and https lets the MQTT proxy tunnels traverse HTTP proxies.
+type httpProxyDialer struct {
+	proxyURL *url.URL
+	forward  proxy.Dialer
+}
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPProxyDialer)
+	proxy.RegisterDialerType("https", newHTTPProxyDialer)
+}
+
+func newHTTPProxyDialer(proxyURL *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	return &httpProxyDialer{proxyURL: proxyURL, forward: forward}, nil
+}
+
+// Dial open a tunnel to addr through the proxy.
+func (dialer *httpProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	proxyAddr := dialer.proxyURL.Host
+	if len(dialer.proxyURL.Port()) == 0 {
+		if dialer.proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(dialer.proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(dialer.proxyURL.Hostname(), "80")
+		}
+	}
+
+	conn, err := dialer.forward.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialer.proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: dialer.proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := dialer.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the broker does not speak before the client, nothing past the response can be buffered here
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused tunnel to %s: %s", dialer.proxyURL.Host, addr, resp.Status)
+	}
+
+	return conn, nil
+}
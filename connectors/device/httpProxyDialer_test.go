@@ -0,0 +1,70 @@
+package device_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	_ "github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/proxy"
+)
+
+type HTTPProxyDialerTestSuite struct {
+	suite.Suite
+	listener      net.Listener
+	authorization chan string
+}
+
+func (suite *HTTPProxyDialerTestSuite) TestDialThroughProxy() {
+	proxyURL, _ := url.Parse("http://user:secret@" + suite.listener.Addr().String())
+	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	conn, err := dialer.Dial("tcp", "mqtt.googleapis.com:8883")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer conn.Close()
+
+	assert.EqualValues(suite.T(), "Basic dXNlcjpzZWNyZXQ=", <-suite.authorization)
+
+	conn.Write([]byte("ping\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), "ping\n", line)
+}
+
+func (suite *HTTPProxyDialerTestSuite) SetupTest() {
+	suite.listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	suite.authorization = make(chan string, 1)
+
+	go func() {
+		conn, err := suite.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		suite.authorization <- req.Header.Get("Proxy-Authorization")
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		// echo what the client sends through the tunnel
+		line, _ := reader.ReadString('\n')
+		conn.Write([]byte(line))
+	}()
+}
+
+func (suite *HTTPProxyDialerTestSuite) TearDownTest() {
+	suite.listener.Close()
+}
+
+func TestHTTPProxyDialerTestSuite(t *testing.T) {
+	suite.Run(t, new(HTTPProxyDialerTestSuite))
+}
@@ -1,9 +1,14 @@
 package device
 
 import (
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
@@ -12,22 +17,44 @@ import (
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/pjgg/iotPlayground/configuration"
 	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/pjgg/iotPlayground/keystore"
 )
 
 // MQTTIotDeviceConnector handler devices telemetry communication.
 type MQTTIotDeviceConnector struct {
-	MQTTClient     mqtt.Client
-	publicKeyPath  string
-	privateKeyPath string
-	projectID      string
-	region         string
-	keyType        connectors.KeyType
-	registryID     string
+	sequence           uint64 // first field to keep 64 bit atomic alignment on 32 bit devices
+	MQTTClient         mqtt.Client
+	publicKeyPath      string
+	privateKeyPath     string
+	projectID          string
+	region             string
+	keyType            connectors.KeyType
+	registryID         string
+	session            string
+	keyStore           keystore.KeyStore
+	deviceID           string
+	clientMutex        sync.RWMutex
+	subscriptions      map[string]mqttSubscription
+	unsubscribe        func() // stop following the profile reloads, set for profile connectors
+	tunnels            mqttProxyTunnels
+	telemetrySubfolder string // from device.telemetryTopic, guarded by clientMutex as it follows reloads
+	configuredKeyStore bool   // keyStore follows the configuration and is rebuilt on reload
+}
+
+// mqttSubscription is replayed on every connection, the broker forgets clean session subscriptions.
+type mqttSubscription struct {
+	qos      byte
+	callback mqtt.MessageHandler
 }
 
 // MQTTIotDeviceConnectorInterface define device telemetry behavior.
 type MQTTIotDeviceConnectorInterface interface {
 	PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) mqtt.Token
+	PublishTelemetry(toDeviceID string, message *telemetry.Telemetry, delivery connectors.QoS) (mqtt.Token, error)
+	PublishState(toDeviceID, state string, delivery connectors.QoS) mqtt.Token
+	SubscribeConfig(toDeviceID string, handler func(config []byte)) mqtt.Token
+	SubscribeCommands(toDeviceID string, handler func(subfolder string, command []byte)) mqtt.Token
 }
 
 var onceMqttDevice sync.Once
@@ -35,90 +62,412 @@ var mqttIotDeviceConnector MQTTIotDeviceConnector
 
 const mqttRetries = 5
 const mqttDelaySecond = 5
+const mqttConnectTimeoutSecond = 30
 
 // NewMQTTIotConnector create a single MQTTIotDeviceConnector instance.
 func NewMQTTIotConnector(registryID, MQTTdeviceID string) MQTTIotDeviceConnectorInterface {
+	return NewMQTTIotConnectorWithKeyStore(registryID, MQTTdeviceID, nil)
+}
 
-	onceMqttDevice.Do(func() {
-		conf := configuration.New()
-		mqttIotDeviceConnector.registryID = registryID
-		mqttIotDeviceConnector.publicKeyPath = conf.DevicePublicKeyPath
-		mqttIotDeviceConnector.privateKeyPath = conf.DevicePrivateKeyPath
-		mqttIotDeviceConnector.keyType = connectors.RsaPem
-		mqttIotDeviceConnector.projectID = conf.GcloudProjectID
-		mqttIotDeviceConnector.region = conf.GcloudRegion
-		jwt, _ := connectors.GenerateJWT(conf.GcloudProjectID, conf.DevicePrivateKeyPath, conf.DeviceJwtExpirationInMin)
-		opts := paho.NewClientOptions()
-
-		opts.SetClientID("projects/" + conf.GcloudProjectID + "/locations/" + conf.GcloudRegion + "/registries/" + registryID + "/devices/" + MQTTdeviceID).
-			AddBroker(conf.MqttEndpoint).
-			SetUsername("unused").
-			SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}).
-			SetPassword(jwt).
-			SetProtocolVersion(4) // Use MQTT 3.1.1
-
-		opts.CleanSession = true
-		opts.AutoReconnect = true
-
-		log.Info("ClientID: " + opts.ClientID)
-		mqttIotDeviceConnector.MQTTClient = paho.NewClient(opts)
-		mqttIotDeviceConnector.mqttConnect(mqttRetries, mqttDelaySecond)
+// NewMQTTIotConnectorWithKeyStore create a single MQTTIotDeviceConnector instance signing its JWT with
+// keyStore, the configured device key store when nil.
+func NewMQTTIotConnectorWithKeyStore(registryID, MQTTdeviceID string, keyStore keystore.KeyStore) MQTTIotDeviceConnectorInterface {
 
+	onceMqttDevice.Do(func() {
+		if err := mqttIotDeviceConnector.init(configuration.New(), registryID, MQTTdeviceID, keyStore); err != nil {
+			log.Fatalln(err.Error())
+		}
+		configuration.Subscribe(mqttIotDeviceConnector.onConfigurationChange)
 	})
 
 	return &mqttIotDeviceConnector
 }
 
+// NewProfileMQTTIotConnector create a connected MQTTIotDeviceConnector bound to a configuration profile,
+// every call returns a new instance so profiles can be used side by side. keyStore is the profile device
+// key store when nil.
+func NewProfileMQTTIotConnector(profile, registryID, MQTTdeviceID string, keyStore keystore.KeyStore) (MQTTIotDeviceConnectorInterface, error) {
+	conf, err := configuration.LoadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &MQTTIotDeviceConnector{}
+	if err = connector.init(conf, registryID, MQTTdeviceID, keyStore); err != nil {
+		return nil, err
+	}
+	connector.unsubscribe = configuration.SubscribeProfile(profile, connector.onConfigurationChange)
+
+	return connector, nil
+}
+
+// newConfiguredMQTTIotConnector create a connected MQTTIotDeviceConnector of the active configuration,
+// a new instance on every call unlike NewMQTTIotConnector.
+func newConfiguredMQTTIotConnector(registryID, MQTTdeviceID string) (*MQTTIotDeviceConnector, error) {
+	connector := &MQTTIotDeviceConnector{}
+	if err := connector.init(configuration.New(), registryID, MQTTdeviceID, nil); err != nil {
+		return nil, err
+	}
+	connector.unsubscribe = configuration.Subscribe(connector.onConfigurationChange)
+
+	return connector, nil
+}
+
+func (iotConnector *MQTTIotDeviceConnector) init(conf *configuration.Configuration, registryID, MQTTdeviceID string, keyStore keystore.KeyStore) error {
+	if keyStore == nil {
+		keyStore = keystore.FromConfiguration(conf)
+		iotConnector.configuredKeyStore = true
+	}
+	iotConnector.keyStore = keyStore
+	iotConnector.deviceID = MQTTdeviceID
+	iotConnector.subscriptions = map[string]mqttSubscription{}
+	iotConnector.registryID = registryID
+	iotConnector.publicKeyPath = conf.DevicePublicKeyPath
+	iotConnector.privateKeyPath = conf.DevicePrivateKeyPath
+	iotConnector.keyType = connectors.RsaPem
+	iotConnector.projectID = conf.GcloudProjectID
+	iotConnector.region = conf.GcloudRegion
+	iotConnector.session = newSession()
+	iotConnector.telemetrySubfolder = telemetry.Subfolder(conf.DeviceTelemetryTopic)
+	opts, tunnels, err := iotConnector.newClientOptions(conf, keyStore)
+	if err != nil {
+		return err
+	}
+
+	log.Info("ClientID: " + opts.ClientID)
+	iotConnector.MQTTClient = paho.NewClient(opts)
+	iotConnector.tunnels = tunnels
+
+	if err = iotConnector.mqttConnect(mqttRetries, mqttDelaySecond); err != nil {
+		tunnels.Close()
+		return err
+	}
+	return nil
+}
+
 // PublishMsg push a mqtt message to google mqtt broker. Thids message will be propagated to a pub/sub topic.
 func (iotConnector *MQTTIotDeviceConnector) PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) (token mqtt.Token) {
 
 	finalTopicName := fmt.Sprintf("/devices/%s/%s", toDeviceID, topicName)
 	log.Info("Publish Msg to topic " + finalTopicName)
-	if !iotConnector.MQTTClient.IsConnected() {
+	if !iotConnector.client().IsConnected() {
 		log.Info("Client Not Connected. Reconnecting... ")
-		mqttIotDeviceConnector.mqttConnect(mqttRetries, mqttDelaySecond)
-	}
-
-	token = iotConnector.MQTTClient.Publish(finalTopicName, delivery.Value(), false, msg)
-	if token.Wait() && token.Error() != nil {
-		log.Errorln("MQTT Publish telemetric fail:")
-		panic(token.Error())
+		iotConnector.mqttConnect(mqttRetries, mqttDelaySecond)
 	}
 
+	// registered before the publish so a failed token is returned to the caller instead of crashing
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("Disconecting Mqtt client ...", r)
-			iotConnector.MQTTClient.Disconnect(1)
+			iotConnector.client().Disconnect(1)
 		}
 	}()
 
+	token = iotConnector.client().Publish(finalTopicName, delivery.Value(), false, msg)
+	if token.Wait() && token.Error() != nil {
+		log.Errorln("MQTT Publish telemetric fail:")
+		panic(token.Error())
+	}
+
 	return
 }
 
-func (iotConnector *MQTTIotDeviceConnector) mqttConnect(retriesAmount, elapsed int) (success bool) {
-	success = true
-	if token := iotConnector.MQTTClient.Connect(); token.Wait() && token.Error() != nil {
-		success = false
-		log.Errorln("MQTT Unable to connect:")
-		panic(token.Error())
+// PublishTelemetry stamp a telemetry event with this connector session, the next sequence number and,
+// if missing, the current time and the device.telemetryTopic subfolder. The encoded envelope is pushed to
+// the event subfolder topic.
+func (iotConnector *MQTTIotDeviceConnector) PublishTelemetry(toDeviceID string, message *telemetry.Telemetry, delivery connectors.QoS) (token mqtt.Token, err error) {
+	message.Session = iotConnector.session
+	message.Sequence = atomic.AddUint64(&iotConnector.sequence, 1)
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now().UTC()
+	}
+	if len(message.Subfolder) == 0 {
+		iotConnector.clientMutex.RLock()
+		message.Subfolder = iotConnector.telemetrySubfolder
+		iotConnector.clientMutex.RUnlock()
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Disconecting Mqtt client ...", r)
-			iotConnector.MQTTClient.Disconnect(1)
-			if retriesAmount > 0 {
-				fmt.Println("Retrying Mqtt connection ...")
-				iotConnector.connectionRetry(retriesAmount, elapsed)
-			}
+	envelope, err := message.Encode()
+	if err != nil {
+		log.Errorln(err.Error())
+		return
+	}
+
+	token = iotConnector.PublishMsg(toDeviceID, message.Topic(), string(envelope), delivery)
+	return
+}
+
+// PublishState push a device state, the broker keeps only the latest states of a device.
+func (iotConnector *MQTTIotDeviceConnector) PublishState(toDeviceID, state string, delivery connectors.QoS) mqtt.Token {
+	return iotConnector.PublishMsg(toDeviceID, "state", state, delivery)
+}
+
+// SubscribeConfig register a handler that receive every config version pushed to the device. The broker
+// delivers the latest config right after the subscription.
+func (iotConnector *MQTTIotDeviceConnector) SubscribeConfig(toDeviceID string, handler func(config []byte)) mqtt.Token {
+	configTopic := fmt.Sprintf("/devices/%s/config", toDeviceID)
+	log.Info("Subscribe to topic " + configTopic)
+	return iotConnector.subscribe(configTopic, connectors.AtLeastOnce.Value(), func(client mqtt.Client, msg mqtt.Message) {
+		handler(msg.Payload())
+	})
+}
+
+// SubscribeCommands register a handler that receive every command sent to the device, subfolder is empty
+// for commands sent without one.
+func (iotConnector *MQTTIotDeviceConnector) SubscribeCommands(toDeviceID string, handler func(subfolder string, command []byte)) mqtt.Token {
+	commandsTopic := fmt.Sprintf("/devices/%s/commands", toDeviceID)
+	log.Info("Subscribe to topic " + commandsTopic + "/#")
+	return iotConnector.subscribe(commandsTopic+"/#", connectors.AtMostOnce.Value(), func(client mqtt.Client, msg mqtt.Message) {
+		subfolder := strings.TrimPrefix(strings.TrimPrefix(msg.Topic(), commandsTopic), "/")
+		handler(subfolder, msg.Payload())
+	})
+}
+
+// mqttConnect connect the current client, retrying retriesAmount times every elapsed seconds. The last
+// connection error is returned once the retries are exhausted.
+func (iotConnector *MQTTIotDeviceConnector) mqttConnect(retriesAmount, elapsed int) (err error) {
+	for attempt := 0; ; attempt++ {
+		token := iotConnector.client().Connect()
+		if token.Wait(); token.Error() == nil {
+			return nil
 		}
-	}()
+
+		err = token.Error()
+		log.Errorln("MQTT Unable to connect: " + err.Error())
+		if attempt >= retriesAmount {
+			return
+		}
+		log.Info("Retrying Mqtt connection ...")
+		time.Sleep(time.Duration(elapsed) * time.Second)
+	}
+}
+
+// client returns the current paho client, replaced when the credentials are reloaded.
+func (iotConnector *MQTTIotDeviceConnector) client() mqtt.Client {
+	iotConnector.clientMutex.RLock()
+	defer iotConnector.clientMutex.RUnlock()
+
+	return iotConnector.MQTTClient
+}
+
+// subscribe record the subscription, so it survives reconnections, and subscribe the current client.
+func (iotConnector *MQTTIotDeviceConnector) subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	iotConnector.clientMutex.Lock()
+	iotConnector.subscriptions[topic] = mqttSubscription{qos: qos, callback: callback}
+	iotConnector.clientMutex.Unlock()
+
+	return iotConnector.client().Subscribe(topic, qos, callback)
+}
+
+// resubscribe replay the recorded subscriptions, called on every connection.
+func (iotConnector *MQTTIotDeviceConnector) resubscribe(client mqtt.Client) {
+	iotConnector.clientMutex.RLock()
+	defer iotConnector.clientMutex.RUnlock()
+
+	for topic, subscription := range iotConnector.subscriptions {
+		client.Subscribe(topic, subscription.qos, subscription.callback)
+	}
+}
+
+func (iotConnector *MQTTIotDeviceConnector) newClientOptions(conf *configuration.Configuration, keyStore keystore.KeyStore) (*paho.ClientOptions, mqttProxyTunnels, error) {
+	opts, tunnels, err := newMQTTClientOptions(conf, iotConnector.registryID, iotConnector.deviceID, keyStore)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.AutoReconnect = true
+	opts.SetOnConnectHandler(iotConnector.resubscribe)
+
+	return opts, tunnels, nil
+}
+
+// onConfigurationChange publish the next telemetry events to the reloaded topic, and reconnect with new
+// credentials when the key, the endpoints or the TLS settings changed. The new client replaces the current
+// one only once connected.
+func (iotConnector *MQTTIotDeviceConnector) onConfigurationChange(previous, current *configuration.Configuration) {
+	iotConnector.clientMutex.Lock()
+	iotConnector.telemetrySubfolder = telemetry.Subfolder(current.DeviceTelemetryTopic)
+	iotConnector.clientMutex.Unlock()
+
+	if !mqttSettingsChanged(previous, current) {
+		return
+	}
+
+	keyStore := iotConnector.keyStore
+	if iotConnector.configuredKeyStore {
+		keyStore = keystore.FromConfiguration(current)
+	}
+
+	opts, tunnels, err := iotConnector.newClientOptions(current, keyStore)
+	if err != nil {
+		log.Errorln("MQTT reconnection with the reloaded configuration failed: " + err.Error())
+		return
+	}
+
+	client := paho.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		tunnels.Close()
+		log.Errorln("MQTT reconnection with the reloaded configuration failed: " + token.Error().Error())
+		return
+	}
+
+	iotConnector.clientMutex.Lock()
+	previousClient := iotConnector.MQTTClient
+	previousTunnels := iotConnector.tunnels
+	iotConnector.MQTTClient = client
+	iotConnector.tunnels = tunnels
+	iotConnector.keyStore = keyStore
+	iotConnector.privateKeyPath = current.DevicePrivateKeyPath
+	iotConnector.publicKeyPath = current.DevicePublicKeyPath
+	iotConnector.clientMutex.Unlock()
+
+	log.Info("MQTT client reconnected with the reloaded configuration")
+	previousClient.Disconnect(mqttDisconnectQuiesceMs)
+	previousTunnels.Close()
+}
+
+func mqttSettingsChanged(previous, current *configuration.Configuration) bool {
+	return previous.DevicePrivateKeyPath != current.DevicePrivateKeyPath ||
+		previous.DevicePrivateKeyEnv != current.DevicePrivateKeyEnv ||
+		previous.DevicePrivateKeyPassphrase != current.DevicePrivateKeyPassphrase ||
+		previous.DeviceJwtExpirationInMin != current.DeviceJwtExpirationInMin ||
+		previous.DeviceJwtClockSkewInSec != current.DeviceJwtClockSkewInSec ||
+		previous.MqttProxy != current.MqttProxy ||
+		previous.MqttClientCertPath != current.MqttClientCertPath ||
+		previous.MqttClientKeyPath != current.MqttClientKeyPath ||
+		previous.MqttTLSMinVersion != current.MqttTLSMinVersion ||
+		strings.Join(previous.MqttEndpoints, ",") != strings.Join(current.MqttEndpoints, ",") ||
+		strings.Join(previous.MqttCACertPaths, ",") != strings.Join(current.MqttCACertPaths, ",") ||
+		strings.Join(previous.MqttPinnedSPKI, ",") != strings.Join(current.MqttPinnedSPKI, ",")
+}
+
+// newMQTTClientOptions build the paho options of a device client authenticated with a JWT signed by keyStore.
+// With a proxy, paho connects to the returned tunnels, to close once the client is disconnected.
+func newMQTTClientOptions(conf *configuration.Configuration, registryID, deviceID string, keyStore keystore.KeyStore) (opts *paho.ClientOptions, tunnels mqttProxyTunnels, err error) {
+	jwt, err := connectors.GenerateJWTWithOptions(conf.GcloudProjectID, keyStore, connectors.DeviceJWTOptions(conf))
+	if err != nil {
+		return
+	}
+
+	opts = paho.NewClientOptions()
+	tlsConfig, err := NewMQTTTLSConfig(MQTTTLSOptions{
+		CACertPaths:    conf.MqttCACertPaths,
+		PinnedSPKI:     conf.MqttPinnedSPKI,
+		ClientCertPath: conf.MqttClientCertPath,
+		ClientKeyPath:  conf.MqttClientKeyPath,
+		MinVersion:     conf.MqttTLSMinVersion,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	brokers, err := mqttBrokers(conf.MqttEndpoints)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	brokerURLs, tunnels, err := mqttBrokerURLs(brokers, conf.MqttProxy, tlsConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	// paho tries the brokers in the given order, so endpoints fallback follow the configuration
+	for _, brokerURL := range brokerURLs {
+		opts.AddBroker(brokerURL)
+	}
+
+	opts.SetClientID("projects/" + conf.GcloudProjectID + "/locations/" + conf.GcloudRegion + "/registries/" + registryID + "/devices/" + deviceID).
+		SetUsername("unused").
+		SetTLSConfig(tlsConfig).
+		SetPassword(jwt).
+		SetConnectTimeout(mqttConnectTimeoutSecond * time.Second).
+		SetProtocolVersion(4) // Use MQTT 3.1.1
+
+	opts.CleanSession = true
+
+	return opts, tunnels, nil
+}
+
+// VerifyMQTTConnect returns a check that open and close a dedicated MQTT session authenticated with
+// privateKeyPath, used to confirm a device credential before revoking the previous one. The session uses
+// the device client ID, so the broker disconnects the device if it is connected, the device reconnects
+// afterwards. Use VerifyHTTPBridgeConnect to leave a connected device alone.
+func VerifyMQTTConnect(registryID, deviceID, privateKeyPath string) func() error {
+	return func() error {
+		conf := configuration.New()
+		opts, tunnels, err := newMQTTClientOptions(conf, registryID, deviceID, keystore.NewFileKeyStore(privateKeyPath, []byte(conf.DevicePrivateKeyPassphrase)))
+		if err != nil {
+			return err
+		}
+		defer tunnels.Close()
+
+		client := paho.NewClient(opts)
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			return token.Error()
+		}
+		client.Disconnect(mqttDisconnectQuiesceMs)
+
+		return nil
+	}
+}
+
+// mqttBrokerURLs returns the URLs paho connects to for brokers. Without proxy they are the brokers,
+// otherwise every broker is reached through its own tunnel and TLS is named after that broker. paho dials
+// wss endpoints itself, they can not go through the proxy.
+func mqttBrokerURLs(brokers []*url.URL, mqttProxy string, tlsConfig *tls.Config) (brokerURLs []string, tunnels mqttProxyTunnels, err error) {
+	if len(mqttProxy) == 0 {
+		for _, broker := range brokers {
+			brokerURLs = append(brokerURLs, broker.String())
+		}
+		return
+	}
+
+	proxyURL, err := url.Parse(mqttProxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, broker := range brokers {
+		if broker.Scheme == "wss" {
+			tunnels.Close()
+			return nil, nil, fmt.Errorf("MQTT endpoint %q can not go through gcloud.mqttProxy, only ssl endpoints can", broker.String())
+		}
+		tunnel, err := newMQTTProxyTunnel(proxyURL, broker, tlsConfig, mqttConnectTimeoutSecond*time.Second)
+		if err != nil {
+			tunnels.Close()
+			return nil, nil, err
+		}
+		tunnels = append(tunnels, tunnel)
+		brokerURLs = append(brokerURLs, tunnel.URL())
+	}
+
+	return
+}
+
+// mqttBrokers parse the configured endpoints, only TLS transports are accepted: ssl://host:8883,
+// ssl://host:443 or wss://host:443/mqtt for networks that only let HTTPS through.
+func mqttBrokers(endpoints []string) (brokers []*url.URL, err error) {
+	for _, endpoint := range endpoints {
+		broker, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MQTT endpoint %q: %s", endpoint, err.Error())
+		}
+		switch broker.Scheme {
+		case "ssl", "tls", "tcps", "wss":
+			brokers = append(brokers, broker)
+		default:
+			return nil, fmt.Errorf("unsupported MQTT endpoint scheme %q, must be ssl or wss", endpoint)
+		}
+	}
+
+	if len(brokers) == 0 {
+		err = fmt.Errorf("no MQTT endpoint configured")
+	}
+
 	return
 }
 
-func (iotConnector *MQTTIotDeviceConnector) connectionRetry(retriesAmount, elapsed int) {
-	retriesAmount--
-	for range time.Tick(time.Duration(elapsed) * time.Second) {
-		iotConnector.mqttConnect(retriesAmount, elapsed)
+func newSession() string {
+	session := make([]byte, 8)
+	if _, err := rand.Read(session); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
 	}
+	return hex.EncodeToString(session)
 }
@@ -0,0 +1,109 @@
+package device
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/proxy"
+)
+
+// mqttProxyTunnel forward local connections to one broker through a proxy. paho only proxies through the
+// process wide all_proxy ENV, so every connector listens on its own loopback port instead: paho connects
+// there over plain TCP and the tunnel opens the TLS session with the broker, named after the broker.
+type mqttProxyTunnel struct {
+	listener  net.Listener
+	dialer    proxy.Dialer
+	broker    string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// mqttProxyTunnels are the tunnels of a paho client, one per broker.
+type mqttProxyTunnels []*mqttProxyTunnel
+
+// newMQTTProxyTunnel start a tunnel to broker through proxyURL, the TLS session is verified with tlsConfig.
+func newMQTTProxyTunnel(proxyURL, broker *url.URL, tlsConfig *tls.Config, timeout time.Duration) (*mqttProxyTunnel, error) {
+	dialer, err := proxy.FromURL(proxyURL, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := &mqttProxyTunnel{
+		listener:  listener,
+		dialer:    dialer,
+		broker:    broker.Host,
+		tlsConfig: tlsConfig.Clone(),
+		timeout:   timeout,
+	}
+	tunnel.tlsConfig.ServerName = broker.Hostname()
+	go tunnel.serve()
+
+	return tunnel, nil
+}
+
+// URL returns the broker URL paho connects to.
+func (tunnel *mqttProxyTunnel) URL() string {
+	return "tcp://" + tunnel.listener.Addr().String()
+}
+
+// Close stop accepting connections, the open ones end with the paho client.
+func (tunnel *mqttProxyTunnel) Close() error {
+	return tunnel.listener.Close()
+}
+
+func (tunnel *mqttProxyTunnel) serve() {
+	for {
+		conn, err := tunnel.listener.Accept()
+		if err != nil {
+			return
+		}
+		go tunnel.forward(conn)
+	}
+}
+
+func (tunnel *mqttProxyTunnel) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := tunnel.dialer.Dial("tcp", tunnel.broker)
+	if err != nil {
+		log.Errorln("MQTT proxy tunnel to " + tunnel.broker + " failed: " + err.Error())
+		return
+	}
+
+	conn := tls.Client(remote, tunnel.tlsConfig)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(tunnel.timeout))
+	if err = conn.Handshake(); err != nil {
+		log.Errorln("MQTT proxy tunnel to " + tunnel.broker + " failed: " + err.Error())
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	// the first side to close ends the tunnel, the deferred closes unblock the other copy
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, conn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// Close stop every tunnel, nil tunnels are a no-op.
+func (tunnels mqttProxyTunnels) Close() {
+	for _, tunnel := range tunnels {
+		tunnel.Close()
+	}
+}
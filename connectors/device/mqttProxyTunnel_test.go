@@ -0,0 +1,164 @@
+package device_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type MQTTProxyTunnelTestSuite struct {
+	suite.Suite
+	roots       *x509.CertPool
+	broker      net.Listener
+	proxy       net.Listener
+	serverNames chan string
+	tunnelled   chan string
+}
+
+func (suite *MQTTProxyTunnelTestSuite) TestServerNamePerBroker() {
+	brokers := []*url.URL{
+		{Scheme: "ssl", Host: "broker-a.test:8883"},
+		{Scheme: "ssl", Host: "broker-b.test:443"},
+	}
+	brokerURLs, closeTunnels, err := device.MQTTBrokerURLs(brokers, "http://"+suite.proxy.Addr().String(), &tls.Config{RootCAs: suite.roots})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer closeTunnels()
+	assert.Len(suite.T(), brokerURLs, 2)
+
+	for i, brokerURL := range brokerURLs {
+		assert.True(suite.T(), strings.HasPrefix(brokerURL, "tcp://127.0.0.1:"))
+		conn, err := net.Dial("tcp", strings.TrimPrefix(brokerURL, "tcp://"))
+		assert.NoError(suite.T(), err, "UnexpectedError")
+
+		conn.Write([]byte("ping\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		assert.NoError(suite.T(), err, "UnexpectedError")
+		assert.EqualValues(suite.T(), "ping\n", line)
+		conn.Close()
+
+		assert.EqualValues(suite.T(), brokers[i].Host, <-suite.tunnelled)
+		assert.EqualValues(suite.T(), brokers[i].Hostname(), <-suite.serverNames)
+	}
+}
+
+func (suite *MQTTProxyTunnelTestSuite) TestWithoutProxy() {
+	brokers := []*url.URL{{Scheme: "ssl", Host: "broker-a.test:8883"}, {Scheme: "wss", Host: "broker-b.test:443", Path: "/mqtt"}}
+	brokerURLs, closeTunnels, err := device.MQTTBrokerURLs(brokers, "", &tls.Config{})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer closeTunnels()
+	assert.EqualValues(suite.T(), []string{"ssl://broker-a.test:8883", "wss://broker-b.test:443/mqtt"}, brokerURLs)
+}
+
+func (suite *MQTTProxyTunnelTestSuite) TestWSSThroughProxyRejected() {
+	brokers := []*url.URL{{Scheme: "ssl", Host: "broker-a.test:8883"}, {Scheme: "wss", Host: "broker-b.test:443", Path: "/mqtt"}}
+	_, _, err := device.MQTTBrokerURLs(brokers, "http://"+suite.proxy.Addr().String(), &tls.Config{})
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "wss://broker-b.test:443/mqtt")
+}
+
+func (suite *MQTTProxyTunnelTestSuite) SetupSuite() {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+	suite.roots = x509.NewCertPool()
+	suite.roots.AddCert(caCert)
+
+	serverKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "broker"},
+		DNSNames:     []string{"broker-a.test", "broker-b.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, _ := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	certificate := tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	suite.serverNames = make(chan string, 2)
+	suite.tunnelled = make(chan string, 2)
+
+	// the broker echo a line once the TLS handshake is done
+	suite.broker, _ = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			suite.serverNames <- hello.ServerName
+			return &certificate, nil
+		},
+	})
+	go func() {
+		for {
+			conn, err := suite.broker.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte(line))
+			}()
+		}
+	}()
+
+	// the proxy tunnel every CONNECT to the broker, whatever the requested host
+	suite.proxy, _ = net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := suite.proxy.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				suite.tunnelled <- req.Host
+
+				upstream, err := net.Dial("tcp", suite.broker.Addr().String())
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+}
+
+func (suite *MQTTProxyTunnelTestSuite) TearDownSuite() {
+	suite.proxy.Close()
+	suite.broker.Close()
+}
+
+func TestMQTTProxyTunnelTestSuite(t *testing.T) {
+	suite.Run(t, new(MQTTProxyTunnelTestSuite))
+}
@@ -0,0 +1,99 @@
+package device
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// MQTTTLSOptions define how the MQTT broker certificate is trusted and how the device authenticate at TLS level.
+type MQTTTLSOptions struct {
+	// CACertPaths are PEM bundles replacing the system trust store, e.g. Google's long term root for mqtt.2030.ltsdomain.com.
+	CACertPaths []string
+	// PinnedSPKI are base64 SHA-256 digests of a subject public key info, one of them must be part of the verified chain.
+	PinnedSPKI []string
+	// ClientCertPath and ClientKeyPath are an optional PEM certificate and key presented to the broker.
+	ClientCertPath string
+	ClientKeyPath  string
+	// MinVersion is 1.2 or 1.3, 1.2 by default.
+	MinVersion string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewMQTTTLSConfig build the TLS config used to reach the MQTT broker.
+func NewMQTTTLSConfig(options MQTTTLSOptions) (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(options.MinVersion) > 0 {
+		version, exist := tlsVersions[options.MinVersion]
+		if !exist {
+			return nil, fmt.Errorf("unsupported TLS min version %q, must be 1.2 or 1.3", options.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(options.ClientCertPath) > 0 || len(options.ClientKeyPath) > 0 {
+		certificate, err := tls.LoadX509KeyPair(options.ClientCertPath, options.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load MQTT client certificate %s: %s", options.ClientCertPath, err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if len(options.CACertPaths) == 0 && len(options.PinnedSPKI) == 0 {
+		return
+	}
+
+	var roots *x509.CertPool
+	if len(options.CACertPaths) > 0 {
+		roots = x509.NewCertPool()
+		for _, path := range options.CACertPaths {
+			pemBytes, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read MQTT CA bundle: %s", err.Error())
+			}
+			if !roots.AppendCertsFromPEM(pemBytes) {
+				return nil, fmt.Errorf("MQTT CA bundle %s does not contain any PEM certificate", path)
+			}
+		}
+	}
+
+	pins := make(map[string]bool)
+	for _, pin := range options.PinnedSPKI {
+		pins[strings.TrimPrefix(pin, "sha256/")] = true
+	}
+
+	// the chain is verified as usual, against roots or the system trust store when nil, pins are checked
+	// on top of it against the verified chains
+	tlsConfig.RootCAs = roots
+	if len(pins) == 0 {
+		return
+	}
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		for _, chain := range state.VerifiedChains {
+			for _, certificate := range chain {
+				if pins[SPKIFingerprint(certificate)] {
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("mqtt tls: no certificate in the verified chain of %s matches a pinned SPKI", state.ServerName)
+	}
+
+	return
+}
+
+// SPKIFingerprint returns the base64 SHA-256 digest of a certificate subject public key info, as used in PinnedSPKI.
+func SPKIFingerprint(certificate *x509.Certificate) string {
+	digest := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
@@ -0,0 +1,134 @@
+package device_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type MQTTTLSConfigTestSuite struct {
+	suite.Suite
+	dir      string
+	caPath   string
+	otherCA  string
+	caCert   *x509.Certificate
+	listener net.Listener
+}
+
+func (suite *MQTTTLSConfigTestSuite) TestCustomCA() {
+	tlsConfig, err := device.NewMQTTTLSConfig(device.MQTTTLSOptions{CACertPaths: []string{suite.caPath}})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.NoError(suite.T(), suite.handshake(tlsConfig))
+}
+
+func (suite *MQTTTLSConfigTestSuite) TestUnknownCA() {
+	tlsConfig, err := device.NewMQTTTLSConfig(device.MQTTTLSOptions{CACertPaths: []string{suite.otherCA}})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	err = suite.handshake(tlsConfig)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "certificate signed by unknown authority")
+}
+
+func (suite *MQTTTLSConfigTestSuite) TestPinnedSPKI() {
+	tlsConfig, _ := device.NewMQTTTLSConfig(device.MQTTTLSOptions{
+		CACertPaths: []string{suite.caPath},
+		PinnedSPKI:  []string{device.SPKIFingerprint(suite.caCert)},
+	})
+	assert.NoError(suite.T(), suite.handshake(tlsConfig))
+
+	tlsConfig, _ = device.NewMQTTTLSConfig(device.MQTTTLSOptions{
+		CACertPaths: []string{suite.caPath},
+		PinnedSPKI:  []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+	})
+	err := suite.handshake(tlsConfig)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "pinned SPKI")
+}
+
+func (suite *MQTTTLSConfigTestSuite) TestInvalidMinVersion() {
+	_, err := device.NewMQTTTLSConfig(device.MQTTTLSOptions{MinVersion: "1.0"})
+	assert.Error(suite.T(), err)
+}
+
+func (suite *MQTTTLSConfigTestSuite) handshake(tlsConfig *tls.Config) error {
+	tlsConfig.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", suite.listener.Addr().String(), tlsConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (suite *MQTTTLSConfigTestSuite) SetupSuite() {
+	suite.dir, _ = ioutil.TempDir("", "mqtt-tls")
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	suite.caCert, _ = x509.ParseCertificate(caDER)
+	suite.caPath = filepath.Join(suite.dir, "ca.pem")
+	ioutil.WriteFile(suite.caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644)
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &otherKey.PublicKey, otherKey)
+	suite.otherCA = filepath.Join(suite.dir, "other.pem")
+	ioutil.WriteFile(suite.otherCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherDER}), 0644)
+
+	serverKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, _ := x509.CreateCertificate(rand.Reader, serverTemplate, suite.caCert, &serverKey.PublicKey, caKey)
+
+	suite.listener, _ = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}},
+	})
+	go func() {
+		for {
+			conn, err := suite.listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+}
+
+func (suite *MQTTTLSConfigTestSuite) TearDownSuite() {
+	suite.listener.Close()
+	os.RemoveAll(suite.dir)
+}
+
+func TestMQTTTLSConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(MQTTTLSConfigTestSuite))
+}
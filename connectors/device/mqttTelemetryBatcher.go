@@ -0,0 +1,181 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+)
+
+// BatchOptions define when a TelemetryBatcher flush and how the batch is encoded.
+type BatchOptions struct {
+	MaxRecords  int
+	MaxBytes    int
+	Linger      time.Duration
+	Format      telemetry.BatchFormat
+	Compression telemetry.Compression
+	Delivery    connectors.QoS
+}
+
+// TelemetryBatcher group telemetry records and publish them as a single MQTT message.
+type TelemetryBatcher struct {
+	connector MQTTIotDeviceConnectorInterface
+	deviceID  string
+	topicName string
+	options   BatchOptions
+	mutex     sync.Mutex
+	records   [][]byte
+	size      int
+	timer     *time.Timer
+	closed    bool
+}
+
+const defaultBatchMaxRecords = 100
+const defaultBatchMaxBytes = 256 * 1024
+const defaultBatchLinger = time.Second
+
+// ErrBatcherClosed is returned when a record is added to a closed TelemetryBatcher.
+var ErrBatcherClosed = errors.New("telemetry batcher closed")
+
+var errInvalidJSONRecord = errors.New("record is not a valid JSON document, JSONArray batches only accept JSON")
+
+// NewTelemetryBatcher create a TelemetryBatcher that publish through the given connector. Batches
+// are sent to the topicName subfolder that advertise its encoding, see telemetry.BatchSubfolder.
+func NewTelemetryBatcher(connector MQTTIotDeviceConnectorInterface, deviceID, topicName string, options BatchOptions) *TelemetryBatcher {
+	if options.MaxRecords <= 0 {
+		options.MaxRecords = defaultBatchMaxRecords
+	}
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = defaultBatchMaxBytes
+	}
+	if options.Linger <= 0 {
+		options.Linger = defaultBatchLinger
+	}
+	if options.Format == 0 {
+		options.Format = telemetry.JSONArray
+	}
+	if options.Compression == 0 {
+		options.Compression = telemetry.Identity
+	}
+	if options.Delivery == 0 {
+		options.Delivery = connectors.AtLeastOnce
+	}
+
+	return &TelemetryBatcher{
+		connector: connector,
+		deviceID:  deviceID,
+		topicName: topicName,
+		options:   options,
+	}
+}
+
+// Add queue a record, the batch is published once MaxRecords or MaxBytes is reached or Linger is elapsed.
+// Add returns an error only when the record is not queued: the batcher is closed, the record is not JSON in a
+// JSONArray batch, or the pending batch is full and can not be published. A queued record whose batch fails
+// to publish stays queued and is tried again after Linger.
+func (batcher *TelemetryBatcher) Add(record []byte) error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	if batcher.closed {
+		return ErrBatcherClosed
+	}
+	if batcher.options.Format == telemetry.JSONArray && !json.Valid(record) {
+		return errInvalidJSONRecord
+	}
+
+	if len(batcher.records) > 0 && batcher.size+len(record) > batcher.options.MaxBytes {
+		if err := batcher.flushLocked(); err != nil {
+			return err
+		}
+	}
+
+	batcher.records = append(batcher.records, record)
+	batcher.size += len(record)
+
+	if len(batcher.records) >= batcher.options.MaxRecords || batcher.size >= batcher.options.MaxBytes {
+		err := batcher.flushLocked()
+		if err == nil {
+			return nil
+		}
+		log.Errorln("telemetry batch flush fail, retry in " + batcher.options.Linger.String() + ": " + err.Error())
+	}
+
+	batcher.armTimerLocked()
+
+	return nil
+}
+
+// armTimerLocked schedule the Linger flush, a failed flush is tried again after another Linger.
+func (batcher *TelemetryBatcher) armTimerLocked() {
+	if batcher.timer != nil {
+		return
+	}
+	batcher.timer = time.AfterFunc(batcher.options.Linger, func() {
+		batcher.mutex.Lock()
+		defer batcher.mutex.Unlock()
+
+		if err := batcher.flushLocked(); err != nil {
+			log.Errorln("telemetry batch flush fail, retry in " + batcher.options.Linger.String() + ": " + err.Error())
+			batcher.armTimerLocked()
+		}
+	})
+}
+
+// Pending returns the number of records waiting to be published.
+func (batcher *TelemetryBatcher) Pending() int {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	return len(batcher.records)
+}
+
+// Flush publish the pending records, if any. The records are kept when the batch can not be encoded or
+// published, so a later Flush, Add or Close sends them again.
+func (batcher *TelemetryBatcher) Flush() error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	return batcher.flushLocked()
+}
+
+// Close flush the pending records and reject any further one, Flush can still be called when it fails.
+func (batcher *TelemetryBatcher) Close() error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	batcher.closed = true
+	return batcher.flushLocked()
+}
+
+func (batcher *TelemetryBatcher) flushLocked() error {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+
+	if len(batcher.records) == 0 {
+		return nil
+	}
+
+	payload, err := telemetry.EncodeBatch(batcher.records, batcher.options.Format, batcher.options.Compression)
+	if err != nil {
+		return err
+	}
+
+	subfolder := telemetry.BatchSubfolder(batcher.options.Format, batcher.options.Compression)
+	log.Debugf("Publish batch of %d records (%d bytes) to subfolder %s", len(batcher.records), len(payload), subfolder)
+	token := batcher.connector.PublishMsg(batcher.deviceID, batcher.topicName+"/"+subfolder, string(payload), batcher.options.Delivery)
+	token.Wait()
+	if err = token.Error(); err != nil {
+		return err
+	}
+
+	batcher.records = nil
+	batcher.size = 0
+	return nil
+}
@@ -0,0 +1,152 @@
+package device_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TelemetryBatcherTestSuite struct {
+	suite.Suite
+	connector *fakeBatchConnector
+}
+
+// doneToken is an already completed MQTT token.
+type doneToken struct {
+	mqtt.Token
+	err error
+}
+
+func (token doneToken) Wait() bool {
+	return true
+}
+
+func (token doneToken) Error() error {
+	return token.err
+}
+
+type fakeBatchConnector struct {
+	device.MQTTIotDeviceConnectorInterface
+	mutex     sync.Mutex
+	topics    []string
+	payloads  []string
+	failures  int
+	published chan struct{}
+}
+
+func (fake *fakeBatchConnector) PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) mqtt.Token {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.failures > 0 {
+		fake.failures--
+		return doneToken{err: errors.New("not connected")}
+	}
+	fake.topics = append(fake.topics, topicName)
+	fake.payloads = append(fake.payloads, msg)
+	select {
+	case fake.published <- struct{}{}:
+	default:
+	}
+	return doneToken{}
+}
+
+func (fake *fakeBatchConnector) sent() []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return append([]string{}, fake.payloads...)
+}
+
+func (suite *TelemetryBatcherTestSuite) SetupTest() {
+	suite.connector = &fakeBatchConnector{published: make(chan struct{}, 1)}
+}
+
+func (suite *TelemetryBatcherTestSuite) TestFlushOnMaxRecords() {
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{MaxRecords: 2, Linger: time.Hour})
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":1}`)), "UnexpectedError")
+	assert.Empty(suite.T(), suite.connector.sent())
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":2}`)), "UnexpectedError")
+
+	assert.Equal(suite.T(), []string{`[{"temp":1},{"temp":2}]`}, suite.connector.sent())
+	assert.Equal(suite.T(), []string{"events/batch-json-identity"}, suite.connector.topics)
+	assert.Equal(suite.T(), 0, batcher.Pending())
+}
+
+func (suite *TelemetryBatcherTestSuite) TestFlushOnMaxBytes() {
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{MaxBytes: 15, Linger: time.Hour})
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":1}`)), "UnexpectedError")
+	// the second record would exceed MaxBytes, the first one is published alone
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":2}`)), "UnexpectedError")
+
+	assert.Equal(suite.T(), []string{`[{"temp":1}]`}, suite.connector.sent())
+	assert.Equal(suite.T(), 1, batcher.Pending())
+}
+
+func (suite *TelemetryBatcherTestSuite) TestFlushOnLinger() {
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{Linger: 20 * time.Millisecond})
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":1}`)), "UnexpectedError")
+
+	select {
+	case <-suite.connector.published:
+	case <-time.After(time.Second):
+		suite.T().Fatal("batch not published after linger")
+	}
+	assert.Equal(suite.T(), []string{`[{"temp":1}]`}, suite.connector.sent())
+}
+
+func (suite *TelemetryBatcherTestSuite) TestInvalidJSONRecordRejected() {
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{Linger: time.Hour})
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":1}`)), "UnexpectedError")
+	assert.Error(suite.T(), batcher.Add([]byte("not json")))
+
+	assert.NoError(suite.T(), batcher.Close(), "UnexpectedError")
+	assert.Equal(suite.T(), []string{`[{"temp":1}]`}, suite.connector.sent())
+	assert.Equal(suite.T(), device.ErrBatcherClosed, batcher.Add([]byte(`{"temp":2}`)))
+}
+
+func (suite *TelemetryBatcherTestSuite) TestFailedPublishKeepsRecords() {
+	suite.connector.failures = 1
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{Linger: time.Hour})
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":1}`)), "UnexpectedError")
+
+	assert.Error(suite.T(), batcher.Flush())
+	assert.Equal(suite.T(), 1, batcher.Pending())
+	assert.NoError(suite.T(), batcher.Flush(), "UnexpectedError")
+	assert.Equal(suite.T(), []string{`[{"temp":1}]`}, suite.connector.sent())
+}
+
+func (suite *TelemetryBatcherTestSuite) TestFailedLingerFlushIsRetried() {
+	suite.connector.failures = 2
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{Linger: 10 * time.Millisecond})
+	assert.NoError(suite.T(), batcher.Add([]byte(`{"temp":1}`)), "UnexpectedError")
+
+	select {
+	case <-suite.connector.published:
+	case <-time.After(time.Second):
+		suite.T().Fatal("batch not published after retries")
+	}
+	assert.Equal(suite.T(), []string{`[{"temp":1}]`}, suite.connector.sent())
+}
+
+func (suite *TelemetryBatcherTestSuite) TestFramesGzip() {
+	batcher := device.NewTelemetryBatcher(suite.connector, "device-1", "events", device.BatchOptions{
+		Linger: time.Hour, Format: telemetry.LengthPrefixed, Compression: telemetry.Gzip,
+	})
+	assert.NoError(suite.T(), batcher.Add([]byte("raw")), "UnexpectedError")
+	assert.NoError(suite.T(), batcher.Flush(), "UnexpectedError")
+
+	records, err := telemetry.DecodeBatch("batch-frames-gzip", []byte(suite.connector.sent()[0]))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), [][]byte{[]byte("raw")}, records)
+}
+
+func TestTelemetryBatcherTestSuite(t *testing.T) {
+	suite.Run(t, new(TelemetryBatcherTestSuite))
+}
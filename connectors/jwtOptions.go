@@ -0,0 +1,132 @@
+package connectors
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pjgg/iotPlayground/configuration"
+)
+
+// MaxJWTExpiration is the longest lifetime, from iat to exp, Cloud IoT accepts.
+const MaxJWTExpiration = 24 * time.Hour
+
+// Clock is the time source stamping JWTs.
+type Clock interface {
+	Now() time.Time
+}
+
+// OffsetClock is the local clock corrected by an offset, for devices whose RTC drifts. The offset can
+// be measured against NTP or seeded from a server Date header.
+type OffsetClock struct {
+	mutex  sync.RWMutex
+	offset time.Duration
+}
+
+// DefaultClock stamps every JWT generated without an explicit Clock.
+var DefaultClock = &OffsetClock{}
+
+// Now returns the corrected time.
+func (clock *OffsetClock) Now() time.Time {
+	clock.mutex.RLock()
+	defer clock.mutex.RUnlock()
+
+	return time.Now().Add(clock.offset)
+}
+
+// Offset returns the current correction.
+func (clock *OffsetClock) Offset() time.Duration {
+	clock.mutex.RLock()
+	defer clock.mutex.RUnlock()
+
+	return clock.offset
+}
+
+// SetOffset set the correction, e.g. the offset reported by an NTP client.
+func (clock *OffsetClock) SetOffset(offset time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.offset = offset
+}
+
+// SyncFromDateHeader set the offset from the Date header of a response received at receivedAt. The header
+// has a one second resolution, so is the correction.
+func (clock *OffsetClock) SyncFromDateHeader(resp *http.Response, receivedAt time.Time) error {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("invalid Date header %q: %s", resp.Header.Get("Date"), err.Error())
+	}
+
+	clock.SetOffset(date.Sub(receivedAt).Truncate(time.Second))
+	return nil
+}
+
+// SyncWithServer send a HEAD request to url and set the offset from its Date header, half the round trip
+// is credited to the response.
+func (clock *OffsetClock) SyncWithServer(client *http.Client, url string) error {
+	sentAt := time.Now()
+	resp, err := client.Head(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	receivedAt := time.Now()
+
+	return clock.SyncFromDateHeader(resp, sentAt.Add(receivedAt.Sub(sentAt)/2))
+}
+
+// JWTOptions customize the device JWT.
+type JWTOptions struct {
+	// Expiration is the token lifetime from the current time.
+	Expiration time.Duration
+	// Skew backdates iat to tolerate a server clock behind the device one.
+	Skew time.Duration
+	// Clock is DefaultClock when nil.
+	Clock Clock
+	// ExtraClaims are added to iat, exp and aud, which can not be overridden.
+	ExtraClaims map[string]interface{}
+}
+
+// Validate fail fast on tokens the bridge would reject.
+func (options JWTOptions) Validate() error {
+	if options.Expiration <= 0 {
+		return fmt.Errorf("JWT expiration must be positive, got %s", options.Expiration)
+	}
+	if options.Skew < 0 {
+		return fmt.Errorf("JWT clock skew must not be negative, got %s", options.Skew)
+	}
+	if options.Expiration+options.Skew > MaxJWTExpiration {
+		return fmt.Errorf("JWT lifetime %s (expiration %s + skew %s) exceeds the %s Cloud IoT maximum", options.Expiration+options.Skew, options.Expiration, options.Skew, MaxJWTExpiration)
+	}
+	return nil
+}
+
+// DeviceJWTOptions returns the options of the configured device JWT.
+func DeviceJWTOptions(conf *configuration.Configuration) JWTOptions {
+	return JWTOptions{
+		Expiration: time.Minute * time.Duration(conf.DeviceJwtExpirationInMin),
+		Skew:       time.Second * time.Duration(conf.DeviceJwtClockSkewInSec),
+	}
+}
+
+func (options JWTOptions) claims(projectID string) jwt.MapClaims {
+	clock := options.Clock
+	if clock == nil {
+		clock = DefaultClock
+	}
+
+	claims := jwt.MapClaims{}
+	for name, value := range options.ExtraClaims {
+		claims[name] = value
+	}
+
+	now := clock.Now()
+	claims["iat"] = now.Add(-options.Skew).Unix()
+	claims["exp"] = now.Add(options.Expiration).Unix()
+	claims["aud"] = projectID
+
+	return claims
+}
@@ -0,0 +1,78 @@
+package connectors_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/keygen"
+	"github.com/pjgg/iotPlayground/keystore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTOptionsTestSuite struct {
+	suite.Suite
+}
+
+type fixedClock time.Time
+
+func (clock fixedClock) Now() time.Time {
+	return time.Time(clock)
+}
+
+func (suite *JWTOptionsTestSuite) TestClaims() {
+	key, _ := keygen.GenerateP256()
+	now := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	signed, err := connectors.GenerateJWTWithOptions("test-project", keystore.NewSignerKeyStore(key), connectors.JWTOptions{
+		Expiration:  time.Hour,
+		Skew:        time.Minute,
+		Clock:       fixedClock(now),
+		ExtraClaims: map[string]interface{}{"sub": "device-1", "aud": "other-project"},
+	})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	claims := jwt.MapClaims{}
+	_, err = new(jwt.Parser).ParseWithClaims(signed, claims, func(token *jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	})
+	// the fixed clock is in the past, only the expiration check fails
+	assert.Error(suite.T(), err)
+	assert.EqualValues(suite.T(), now.Add(-time.Minute).Unix(), claims["iat"])
+	assert.EqualValues(suite.T(), now.Add(time.Hour).Unix(), claims["exp"])
+	assert.EqualValues(suite.T(), "test-project", claims["aud"])
+	assert.EqualValues(suite.T(), "device-1", claims["sub"])
+}
+
+func (suite *JWTOptionsTestSuite) TestValidate() {
+	assert.NoError(suite.T(), connectors.JWTOptions{Expiration: 24 * time.Hour}.Validate(), "UnexpectedError")
+	assert.Error(suite.T(), connectors.JWTOptions{Expiration: 24*time.Hour + time.Second}.Validate())
+	assert.Error(suite.T(), connectors.JWTOptions{Expiration: 24 * time.Hour, Skew: time.Minute}.Validate())
+	assert.Error(suite.T(), connectors.JWTOptions{}.Validate())
+	assert.Error(suite.T(), connectors.JWTOptions{Expiration: time.Hour, Skew: -time.Minute}.Validate())
+
+	key, _ := keygen.GenerateP256()
+	_, err := connectors.GenerateJWTWithKeyStore("test-project", keystore.NewSignerKeyStore(key), 25*60)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *JWTOptionsTestSuite) TestSyncFromDateHeader() {
+	clock := &connectors.OffsetClock{}
+	receivedAt := time.Now()
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Date", receivedAt.Add(-time.Hour).UTC().Format(http.TimeFormat))
+
+	assert.NoError(suite.T(), clock.SyncFromDateHeader(resp, receivedAt), "UnexpectedError")
+	assert.InDelta(suite.T(), float64(-time.Hour), float64(clock.Offset()), float64(time.Second))
+	assert.WithinDuration(suite.T(), time.Now().Add(-time.Hour), clock.Now(), 2*time.Second)
+
+	resp.Header.Set("Date", "yesterday")
+	assert.Error(suite.T(), clock.SyncFromDateHeader(resp, receivedAt))
+}
+
+func TestJWTOptionsTestSuite(t *testing.T) {
+	suite.Run(t, new(JWTOptionsTestSuite))
+}
@@ -0,0 +1,88 @@
+package connectors
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// signerMethod is a jwt-go signing method backed by a crypto.Signer instead of a raw private key, so the
+// key itself never has to be exposed.
+type signerMethod struct {
+	alg string
+}
+
+var signingMethodRS256 = &signerMethod{alg: jwt.SigningMethodRS256.Alg()}
+var signingMethodES256 = &signerMethod{alg: jwt.SigningMethodES256.Alg()}
+
+const es256KeySize = 32
+
+func signingMethodFor(signer crypto.Signer) (*signerMethod, error) {
+	switch publicKey := signer.Public().(type) {
+	case *rsa.PublicKey:
+		return signingMethodRS256, nil
+	case *ecdsa.PublicKey:
+		if publicKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported %s curve, ES256 requires P-256", publicKey.Curve.Params().Name)
+		}
+		return signingMethodES256, nil
+	}
+
+	return nil, fmt.Errorf("unsupported %T public key, must be RSA or ECDSA P-256", signer.Public())
+}
+
+// Alg returns the JWT alg header value.
+func (method *signerMethod) Alg() string {
+	return method.alg
+}
+
+// Sign hash signingString with SHA-256 and sign it with the crypto.Signer passed as key.
+func (method *signerMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	if method == signingMethodES256 {
+		// crypto.Signer returns an ASN.1 signature, JWS expects the fixed size R || S concatenation
+		var ecSignature struct {
+			R, S *big.Int
+		}
+		if _, err = asn1.Unmarshal(signature, &ecSignature); err != nil {
+			return "", err
+		}
+		signature = make([]byte, 2*es256KeySize)
+		rBytes, sBytes := ecSignature.R.Bytes(), ecSignature.S.Bytes()
+		copy(signature[es256KeySize-len(rBytes):es256KeySize], rBytes)
+		copy(signature[2*es256KeySize-len(sBytes):], sBytes)
+	}
+
+	return jwt.EncodeSegment(signature), nil
+}
+
+// Verify check a signature with the public key of the crypto.Signer passed as key.
+func (method *signerMethod) Verify(signingString, signature string, key interface{}) error {
+	publicKey := key
+	if signer, ok := key.(crypto.Signer); ok {
+		publicKey = signer.Public()
+	}
+
+	if method == signingMethodES256 {
+		return jwt.SigningMethodES256.Verify(signingString, signature, publicKey)
+	}
+	return jwt.SigningMethodRS256.Verify(signingString, signature, publicKey)
+}
@@ -0,0 +1,50 @@
+package connectors_test
+
+import (
+	"crypto"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/keygen"
+	"github.com/pjgg/iotPlayground/keystore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTSignerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *JWTSignerTestSuite) TestES256() {
+	key, _ := keygen.GenerateP256()
+	suite.assertSignedBy(key, "ES256")
+}
+
+func (suite *JWTSignerTestSuite) TestRS256() {
+	key, _ := keygen.GenerateRSA(0)
+	suite.assertSignedBy(key, "RS256")
+}
+
+func (suite *JWTSignerTestSuite) TestRepositoryKeys() {
+	for _, path := range []string{"../rsa_private.pem", "../ec_private.pem"} {
+		_, err := connectors.GenerateJWT("test-project", path, 60)
+		assert.NoError(suite.T(), err, path)
+	}
+}
+
+func (suite *JWTSignerTestSuite) assertSignedBy(key crypto.Signer, alg string) {
+	signed, err := connectors.GenerateJWTWithKeyStore("test-project", keystore.NewSignerKeyStore(key), 60)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	token, err := jwt.ParseWithClaims(signed, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), alg, token.Header["alg"])
+	assert.EqualValues(suite.T(), "test-project", token.Claims.(*jwt.StandardClaims).Audience)
+}
+
+func TestJWTSignerTestSuite(t *testing.T) {
+	suite.Run(t, new(JWTSignerTestSuite))
+}
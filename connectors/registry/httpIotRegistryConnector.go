@@ -2,10 +2,12 @@ package registry
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/configuration"
 	"github.com/pjgg/iotPlayground/connectors"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
@@ -28,6 +30,9 @@ type HTTPIotRegistryConnectorInterface interface {
 	ListRegistries() ([]*cloudiot.DeviceRegistry, error)
 	SetRegistryIam(registryID string, member string, role string) (*cloudiot.Policy, error)
 	GetRegistryIam(registryID string) (*cloudiot.Policy, error)
+	CreateRegistryWithCredentials(registryID string, config []*cloudiot.EventNotificationConfig, caCertPaths []string) (*cloudiot.DeviceRegistry, error)
+	PatchRegistry(registryID string, newRegistry *cloudiot.DeviceRegistry, field string) (*cloudiot.DeviceRegistry, error)
+	AddRegistryCredential(registryID string, caCertPath string) (*cloudiot.DeviceRegistry, error)
 }
 
 var onceRegistry sync.Once
@@ -37,20 +42,12 @@ var iotRegistryConnector HTTPIotRegistryConnector
 func NewHTTPIotRegistryConnector(protocol connectors.Protocol, projectID string, region string) HTTPIotRegistryConnectorInterface {
 
 	onceRegistry.Do(func() {
-		ctx := context.Background()
-
 		if protocol == connectors.HTTP {
-			httpClient, err := google.DefaultClient(ctx, cloudiot.CloudPlatformScope)
+			connector, err := newHTTPIotRegistryConnector(projectID, region)
 			if err != nil {
 				log.Fatalln(err.Error())
 			}
-
-			iotRegistryConnector.Client, err = cloudiot.New(httpClient)
-			if err != nil {
-				log.Fatalln(err.Error())
-			}
-			iotRegistryConnector.projectID = projectID
-			iotRegistryConnector.region = region
+			iotRegistryConnector = *connector
 		}
 
 	})
@@ -58,6 +55,31 @@ func NewHTTPIotRegistryConnector(protocol connectors.Protocol, projectID string,
 	return &iotRegistryConnector
 }
 
+// NewProfileHTTPIotRegistryConnector create an HTTPIotRegistryConnector bound to the project and region of
+// a configuration profile, every call returns a new instance so profiles can be used side by side.
+func NewProfileHTTPIotRegistryConnector(profile string) (HTTPIotRegistryConnectorInterface, error) {
+	conf, err := configuration.LoadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTTPIotRegistryConnector(conf.GcloudProjectID, conf.GcloudRegion)
+}
+
+func newHTTPIotRegistryConnector(projectID string, region string) (*HTTPIotRegistryConnector, error) {
+	httpClient, err := google.DefaultClient(context.Background(), cloudiot.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &HTTPIotRegistryConnector{projectID: projectID, region: region}
+	if connector.Client, err = cloudiot.New(httpClient); err != nil {
+		return nil, err
+	}
+
+	return connector, nil
+}
+
 // GenerateTopicName create a topic name according google spec.
 func (iotConnector *HTTPIotRegistryConnector) GenerateTopicName(topicName string) (fullTopicName string) {
 	fullTopicName = fmt.Sprintf("projects/%s/topics/%s", iotConnector.projectID, topicName)
@@ -87,7 +109,7 @@ func (iotConnector *HTTPIotRegistryConnector) CreateRegistry(registryID string,
 // DeleteRegistry remove an existing registry based in his registryID.
 func (iotConnector *HTTPIotRegistryConnector) DeleteRegistry(registryID string) (empty *cloudiot.Empty, err error) {
 	name := fmt.Sprintf("projects/%s/locations/%s/registries/%s", iotConnector.projectID, iotConnector.region, registryID)
-	if iotConnector.Client.Projects.Locations.Registries.Delete(name).Do(); err == nil {
+	if empty, err = iotConnector.Client.Projects.Locations.Registries.Delete(name).Do(); err == nil {
 		log.Debugln("Deleted registry")
 	}
 
@@ -142,13 +164,90 @@ func (iotConnector *HTTPIotRegistryConnector) SetRegistryIam(registryID string,
 // ListRegistries retrieve a list of registries of the current project.
 func (iotConnector *HTTPIotRegistryConnector) ListRegistries() (registries []*cloudiot.DeviceRegistry, err error) {
 	parentPath := fmt.Sprintf("projects/%s/locations/%s", iotConnector.projectID, iotConnector.region)
-	if response, err := iotConnector.Client.Projects.Locations.Registries.List(parentPath).Do(); err == nil {
-		fmt.Println("Registries:")
-		for _, registry := range response.DeviceRegistries {
+	err = iotConnector.Client.Projects.Locations.Registries.List(parentPath).Pages(context.Background(), func(response *cloudiot.ListDeviceRegistriesResponse) error {
+		registries = append(registries, response.DeviceRegistries...)
+		return nil
+	})
+	if err == nil {
+		log.Debugln("Registries:")
+		for _, registry := range registries {
 			log.Debugln("\t", registry.Name)
 		}
-		registries = response.DeviceRegistries
 	}
 
 	return registries, err
 }
+
+// CreateRegistryWithCredentials create a device registry whose devices must present certificates signed by one of the given CAs.
+func (iotConnector *HTTPIotRegistryConnector) CreateRegistryWithCredentials(registryID string, config []*cloudiot.EventNotificationConfig, caCertPaths []string) (registry *cloudiot.DeviceRegistry, err error) {
+	registryDef := cloudiot.DeviceRegistry{
+		Id: registryID,
+		EventNotificationConfigs: config,
+	}
+
+	for _, caCertPath := range caCertPaths {
+		credential, err := newRegistryCredential(caCertPath)
+		if err != nil {
+			return nil, err
+		}
+		registryDef.Credentials = append(registryDef.Credentials, credential)
+	}
+
+	parentPath := fmt.Sprintf("projects/%s/locations/%s", iotConnector.projectID, iotConnector.region)
+	if registry, err = iotConnector.Client.Projects.Locations.Registries.Create(parentPath, &registryDef).Do(); err == nil {
+		log.Debugln("Created registry with ", len(registry.Credentials), " CA credentials:")
+		log.Debugln("\tID: ", registry.Id)
+		log.Debugln("\tName: ", registry.Name)
+	}
+
+	return
+}
+
+// PatchRegistry make a partial update over a registry.
+func (iotConnector *HTTPIotRegistryConnector) PatchRegistry(registryID string, newRegistry *cloudiot.DeviceRegistry, field string) (registry *cloudiot.DeviceRegistry, err error) {
+	name := fmt.Sprintf("projects/%s/locations/%s/registries/%s", iotConnector.projectID, iotConnector.region, registryID)
+	if registry, err = iotConnector.Client.Projects.Locations.Registries.Patch(name, newRegistry).UpdateMask(field).Do(); err == nil {
+		log.Debugln("Successfully patched registry.")
+	}
+
+	return
+}
+
+// AddRegistryCredential append a CA certificate to the registry credentials.
+func (iotConnector *HTTPIotRegistryConnector) AddRegistryCredential(registryID string, caCertPath string) (registry *cloudiot.DeviceRegistry, err error) {
+	credential, err := newRegistryCredential(caCertPath)
+	if err != nil {
+		return
+	}
+
+	current, err := iotConnector.GetRegistry(registryID)
+	if err != nil {
+		return
+	}
+
+	newRegistry := &cloudiot.DeviceRegistry{Credentials: append(current.Credentials, credential)}
+	return iotConnector.PatchRegistry(registryID, newRegistry, "credentials")
+}
+
+func newRegistryCredential(caCertPath string) (*cloudiot.RegistryCredential, error) {
+	certBytes, err := ioutil.ReadFile(caCertPath)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	certificate, err := connectors.ParseCertificatePEM(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry CA certificate %s: %s", caCertPath, err.Error())
+	}
+	if !certificate.IsCA {
+		return nil, fmt.Errorf("registry CA certificate %s is not a CA", caCertPath)
+	}
+
+	return &cloudiot.RegistryCredential{
+		PublicKeyCertificate: &cloudiot.PublicKeyCertificate{
+			Format:      connectors.X509CertificatePem,
+			Certificate: string(certBytes),
+		},
+	}, nil
+}
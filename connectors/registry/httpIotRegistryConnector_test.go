@@ -2,6 +2,8 @@ package registry_test
 
 import (
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -57,6 +59,25 @@ func (suite *IotRegistryConnectorTestSuite) getRegistryIamTest() {
 	assert.EqualValues(suite.T(), policy.Version, 1)
 }
 
+func (suite *IotRegistryConnectorTestSuite) TestAddRegistryCredentialRejectsNonCA() {
+	connector := registry.NewHTTPIotRegistryConnector(connectors.HTTP, suite.configuration.GcloudProjectID, suite.configuration.GcloudRegion)
+
+	_, err := connector.AddRegistryCredential(suite.registryID, "../../rsa_cert.pem")
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "is not a CA")
+}
+
+func (suite *IotRegistryConnectorTestSuite) TestPatchRegistry() {
+	connector := registry.NewHTTPIotRegistryConnector(connectors.HTTP, suite.configuration.GcloudProjectID, suite.configuration.GcloudRegion)
+
+	newRegistry := &cloudiot.DeviceRegistry{
+		HttpConfig: &cloudiot.HttpConfig{HttpEnabledState: "HTTP_DISABLED"},
+	}
+	registry, err := connector.PatchRegistry(suite.registryID, newRegistry, "http_config.http_enabled_state")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), registry.HttpConfig.HttpEnabledState, "HTTP_DISABLED")
+}
+
 type IotRegistryConnectorTestSuite struct {
 	suite.Suite
 	configuration *configuration.Configuration
@@ -93,6 +114,51 @@ func TestIotRegistryConnectorTestSuite(t *testing.T) {
 	suite.Run(t, iotReg)
 }
 
+type RegistryListPagesTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func (suite *RegistryListPagesTestSuite) TestListRegistriesFollowsPages() {
+	service, err := cloudiot.New(http.DefaultClient)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	service.BasePath = suite.server.URL + "/"
+	connector := &registry.HTTPIotRegistryConnector{Client: service}
+
+	registries, err := connector.ListRegistries()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	ids := []string{}
+	for _, registry := range registries {
+		ids = append(ids, registry.Id)
+	}
+	assert.EqualValues(suite.T(), []string{"registry-1", "registry-2", "registry-3"}, ids)
+}
+
+func (suite *RegistryListPagesTestSuite) SetupTest() {
+	pages := map[string]string{
+		"":       `{"deviceRegistries": [{"id": "registry-1"}], "nextPageToken": "page-2"}`,
+		"page-2": `{"deviceRegistries": [{"id": "registry-2"}], "nextPageToken": "page-3"}`,
+		"page-3": `{"deviceRegistries": [{"id": "registry-3"}]}`,
+	}
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, exist := pages[r.URL.Query().Get("pageToken")]
+		if !exist {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(page))
+	}))
+}
+
+func (suite *RegistryListPagesTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func TestRegistryListPagesTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryListPagesTestSuite))
+}
+
 func randStringRunes(n int) string {
 	var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	b := make([]rune, n)
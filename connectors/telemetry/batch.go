@@ -0,0 +1,255 @@
+package telemetry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BatchFormat define how several telemetry records are framed into a single payload.
+type BatchFormat int
+
+const (
+	// JSONArray frames records as a JSON array, every record must be a valid JSON document.
+	JSONArray BatchFormat = 1 + iota
+	// LengthPrefixed frames records as a 4 bytes big endian length followed by the record bytes.
+	LengthPrefixed
+)
+
+var batchFormatName = [...]string{
+	"json",
+	"frames",
+}
+
+func (format BatchFormat) String() string {
+	return batchFormatName[format-1]
+}
+
+// Compression define the algorithm applied to a batch payload once framed.
+type Compression int
+
+const (
+	// Identity leaves the payload uncompressed.
+	Identity Compression = 1 + iota
+	// Gzip ...
+	Gzip
+	// Zstd ...
+	Zstd
+)
+
+var compressionName = [...]string{
+	"identity",
+	"gzip",
+	"zstd",
+}
+
+func (compression Compression) String() string {
+	return compressionName[compression-1]
+}
+
+// Codec compress and decompress batch payloads.
+type Codec interface {
+	Compress(payload []byte) ([]byte, error)
+	Decompress(payload []byte) ([]byte, error)
+}
+
+// BatchSubfolderPrefix is the events subfolder prefix used to advertise a batch encoding.
+const BatchSubfolderPrefix = "batch"
+
+// ErrUnsupportedCompression is returned for a compression without codec.
+var ErrUnsupportedCompression = errors.New("unsupported batch compression")
+
+var codecs = map[Compression]Codec{
+	Gzip: gzipCodec{},
+	Zstd: zstdCodec{},
+}
+
+// BatchSubfolder returns the events subfolder that advertise a batch encoding, e.g. batch-json-gzip.
+func BatchSubfolder(format BatchFormat, compression Compression) string {
+	return fmt.Sprintf("%s-%s-%s", BatchSubfolderPrefix, format, compression)
+}
+
+// ParseBatchSubfolder retrieve the batch format and compression advertised by a subfolder.
+func ParseBatchSubfolder(subfolder string) (format BatchFormat, compression Compression, err error) {
+	parts := strings.Split(subfolder, "-")
+	if len(parts) != 3 || parts[0] != BatchSubfolderPrefix {
+		err = fmt.Errorf("subfolder %q is not a batch subfolder", subfolder)
+		return
+	}
+
+	for i, name := range batchFormatName {
+		if name == parts[1] {
+			format = BatchFormat(i + 1)
+		}
+	}
+	for i, name := range compressionName {
+		if name == parts[2] {
+			compression = Compression(i + 1)
+		}
+	}
+
+	if format == 0 || compression == 0 {
+		err = fmt.Errorf("subfolder %q advertise an unknown batch encoding", subfolder)
+	}
+
+	return
+}
+
+// EncodeBatch frame and compress a list of records into a single payload.
+func EncodeBatch(records [][]byte, format BatchFormat, compression Compression) (payload []byte, err error) {
+	switch format {
+	case JSONArray:
+		raws := make([]json.RawMessage, len(records))
+		for i, record := range records {
+			if !json.Valid(record) {
+				return nil, fmt.Errorf("record %d is not a valid JSON document", i)
+			}
+			raws[i] = json.RawMessage(record)
+		}
+		if payload, err = json.Marshal(raws); err != nil {
+			return
+		}
+	case LengthPrefixed:
+		var buffer bytes.Buffer
+		header := make([]byte, 4)
+		for _, record := range records {
+			binary.BigEndian.PutUint32(header, uint32(len(record)))
+			buffer.Write(header)
+			buffer.Write(record)
+		}
+		payload = buffer.Bytes()
+	default:
+		return nil, fmt.Errorf("unknown batch format %d", format)
+	}
+
+	return compress(payload, compression)
+}
+
+// DecodeBatch is the consumer side counterpart of EncodeBatch. The encoding is taken from the
+// subfolder the batch was published to.
+func DecodeBatch(subfolder string, payload []byte) (records [][]byte, err error) {
+	format, compression, err := ParseBatchSubfolder(subfolder)
+	if err != nil {
+		return
+	}
+
+	if payload, err = decompress(payload, compression); err != nil {
+		return
+	}
+
+	switch format {
+	case JSONArray:
+		var raws []json.RawMessage
+		if err = json.Unmarshal(payload, &raws); err != nil {
+			return
+		}
+		for _, raw := range raws {
+			records = append(records, []byte(raw))
+		}
+	case LengthPrefixed:
+		for len(payload) > 0 {
+			if len(payload) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size := binary.BigEndian.Uint32(payload[:4])
+			payload = payload[4:]
+			if uint64(len(payload)) < uint64(size) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			records = append(records, payload[:size])
+			payload = payload[size:]
+		}
+	}
+
+	return
+}
+
+func compress(payload []byte, compression Compression) ([]byte, error) {
+	if compression == Identity {
+		return payload, nil
+	}
+
+	codec, err := codecFor(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Compress(payload)
+}
+
+func decompress(payload []byte, compression Compression) ([]byte, error) {
+	if compression == Identity {
+		return payload, nil
+	}
+
+	codec, err := codecFor(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Decompress(payload)
+}
+
+func codecFor(compression Compression) (codec Codec, err error) {
+	codec, exist := codecs[compression]
+	if !exist {
+		err = ErrUnsupportedCompression
+	}
+
+	return
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(payload []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(payload []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Compress(payload []byte) ([]byte, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Close()
+
+	return encoder.EncodeAll(payload, nil), nil
+}
+
+func (zstdCodec) Decompress(payload []byte) ([]byte, error) {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+
+	return decoder.DecodeAll(payload, nil)
+}
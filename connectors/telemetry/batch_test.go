@@ -0,0 +1,75 @@
+package telemetry_test
+
+import (
+	"testing"
+
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchTestSuite struct {
+	suite.Suite
+	records [][]byte
+}
+
+func (suite *BatchTestSuite) TestJSONArrayRoundTrip() {
+	payload, err := telemetry.EncodeBatch(suite.records, telemetry.JSONArray, telemetry.Identity)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), `[{"temp":21.5},{"temp":22},"raw"]`, string(payload))
+
+	records, err := telemetry.DecodeBatch(telemetry.BatchSubfolder(telemetry.JSONArray, telemetry.Identity), payload)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), suite.records, records)
+}
+
+func (suite *BatchTestSuite) TestLengthPrefixedGzipRoundTrip() {
+	records := append(suite.records, []byte("not json"), []byte{})
+	payload, err := telemetry.EncodeBatch(records, telemetry.LengthPrefixed, telemetry.Gzip)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	decoded, err := telemetry.DecodeBatch("batch-frames-gzip", payload)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), len(records), len(decoded))
+	for i := range records {
+		assert.EqualValues(suite.T(), string(records[i]), string(decoded[i]))
+	}
+}
+
+func (suite *BatchTestSuite) TestJSONArrayRejectInvalidRecord() {
+	_, err := telemetry.EncodeBatch([][]byte{[]byte("not json")}, telemetry.JSONArray, telemetry.Identity)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *BatchTestSuite) TestUnknownCompression() {
+	_, err := telemetry.EncodeBatch(suite.records, telemetry.JSONArray, telemetry.Compression(7))
+	assert.EqualValues(suite.T(), telemetry.ErrUnsupportedCompression, err)
+
+	_, _, err = telemetry.ParseBatchSubfolder("batch-json-lz4")
+	assert.Error(suite.T(), err)
+}
+
+func (suite *BatchTestSuite) TestJSONArrayZstdRoundTrip() {
+	payload, err := telemetry.EncodeBatch(suite.records, telemetry.JSONArray, telemetry.Zstd)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	records, err := telemetry.DecodeBatch("batch-json-zstd", payload)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), suite.records, records)
+}
+
+func (suite *BatchTestSuite) TestParseBatchSubfolder() {
+	format, compression, err := telemetry.ParseBatchSubfolder("batch-json-gzip")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), telemetry.JSONArray, format)
+	assert.EqualValues(suite.T(), telemetry.Gzip, compression)
+
+	_, _, err = telemetry.ParseBatchSubfolder("alerts")
+	assert.Error(suite.T(), err)
+}
+
+func TestBatchTestSuite(t *testing.T) {
+	batchSuite := new(BatchTestSuite)
+	batchSuite.records = [][]byte{[]byte(`{"temp":21.5}`), []byte(`{"temp":22}`), []byte(`"raw"`)}
+	suite.Run(t, batchSuite)
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventsTopic is the MQTT topic suffix every telemetry event is published to.
+const EventsTopic = "events"
+
+// Telemetry is a telemetry event with the metadata downstream consumers need to route,
+// deduplicate and order messages.
+type Telemetry struct {
+	Subfolder   string            `json:"subfolder,omitempty"`
+	Session     string            `json:"session"`
+	Sequence    uint64            `json:"sequence"`
+	Timestamp   time.Time         `json:"timestamp"`
+	ContentType string            `json:"contentType,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Payload     []byte            `json:"payload"`
+}
+
+// Topic returns the MQTT topic suffix for this event, events or events/<subfolder>.
+func (message *Telemetry) Topic() string {
+	if len(message.Subfolder) == 0 {
+		return EventsTopic
+	}
+	return EventsTopic + "/" + message.Subfolder
+}
+
+// Subfolder returns the subfolder of an events/<subfolder> topic, empty for EventsTopic itself or any other topic.
+func Subfolder(topic string) string {
+	if !strings.HasPrefix(topic, EventsTopic+"/") {
+		return ""
+	}
+	return strings.TrimPrefix(topic, EventsTopic+"/")
+}
+
+// Key returns an identifier unique per device, suitable to deduplicate redelivered events.
+func (message *Telemetry) Key() string {
+	return fmt.Sprintf("%s/%020d", message.Session, message.Sequence)
+}
+
+// Validate check the event can be routed by the registry.
+func (message *Telemetry) Validate() error {
+	if strings.ContainsAny(message.Subfolder, "#+") || strings.HasPrefix(message.Subfolder, "/") || strings.HasSuffix(message.Subfolder, "/") {
+		return fmt.Errorf("invalid telemetry subfolder %q", message.Subfolder)
+	}
+	if len(message.Session) == 0 {
+		return fmt.Errorf("telemetry session is required")
+	}
+	return nil
+}
+
+// Encode serialize the event envelope as JSON, payload is base64 encoded.
+func (message *Telemetry) Encode() ([]byte, error) {
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
+
+// Decode is the consumer side counterpart of Encode.
+func Decode(data []byte) (message *Telemetry, err error) {
+	message = &Telemetry{}
+	if err = json.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+
+	return message, message.Validate()
+}
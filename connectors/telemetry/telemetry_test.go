@@ -0,0 +1,57 @@
+package telemetry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TelemetryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TelemetryTestSuite) TestEncodeDecode() {
+	message := &telemetry.Telemetry{
+		Subfolder:   "alerts",
+		Session:     "a1b2c3",
+		Sequence:    42,
+		Timestamp:   time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC),
+		ContentType: "application/json",
+		Attributes:  map[string]string{"sensor": "temp"},
+		Payload:     []byte(`{"temp":21.5}`),
+	}
+
+	data, err := message.Encode()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	decoded, err := telemetry.Decode(data)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), message, decoded)
+	assert.EqualValues(suite.T(), "events/alerts", decoded.Topic())
+	assert.EqualValues(suite.T(), "a1b2c3/00000000000000000042", decoded.Key())
+}
+
+func (suite *TelemetryTestSuite) TestTopicWithoutSubfolder() {
+	message := &telemetry.Telemetry{Session: "a1b2c3"}
+	assert.EqualValues(suite.T(), telemetry.EventsTopic, message.Topic())
+}
+
+func (suite *TelemetryTestSuite) TestSubfolderOfTopic() {
+	assert.EqualValues(suite.T(), "", telemetry.Subfolder(telemetry.EventsTopic))
+	assert.EqualValues(suite.T(), "site-a/alerts", telemetry.Subfolder("events/site-a/alerts"))
+	assert.EqualValues(suite.T(), "", telemetry.Subfolder("eventsfoo"))
+	assert.EqualValues(suite.T(), "", telemetry.Subfolder("state"))
+}
+
+func (suite *TelemetryTestSuite) TestInvalidSubfolder() {
+	message := &telemetry.Telemetry{Session: "a1b2c3", Subfolder: "alerts/#"}
+	_, err := message.Encode()
+	assert.Error(suite.T(), err)
+}
+
+func TestTelemetryTestSuite(t *testing.T) {
+	suite.Run(t, new(TelemetryTestSuite))
+}
@@ -1,11 +1,14 @@
 package connectors
 
 import (
-	"io/ioutil"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pjgg/iotPlayground/keystore"
 )
 
 // Protocol must be HTTP or MQTT
@@ -27,7 +30,17 @@ func (protocol Protocol) String() string {
 	return protocolName[protocol-1]
 }
 
-// KeyType must be RSA_PEM or ES256_PEM
+// ParseProtocol returns the Protocol matching a name, case insensitive.
+func ParseProtocol(name string) (Protocol, error) {
+	for i, protocol := range protocolName {
+		if strings.EqualFold(protocol, name) {
+			return Protocol(i + 1), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown protocol %q, must be HTTP or MQTT", name)
+}
+
+// KeyType must be RSA_PEM, ES256_PEM, RSA_X509_PEM or ES256_X509_PEM
 type KeyType int
 
 const (
@@ -35,37 +48,81 @@ const (
 	RsaPem KeyType = 1 + iota
 	// ES256_PEM ...
 	Es256Pem
+	// RSA_X509_PEM ...
+	RsaX509Pem
+	// ES256_X509_PEM ...
+	Es256X509Pem
 )
 
 var keyTypeName = [...]string{
 	"RSA_PEM",
 	"ES256_PEM",
+	"RSA_X509_PEM",
+	"ES256_X509_PEM",
 }
 
 func (keyType KeyType) String() string {
 	return keyTypeName[keyType-1]
 }
 
-// GenerateJWT will generate a signed JWT token
+// IsX509 tells if the key is wrapped in an X.509 certificate.
+func (keyType KeyType) IsX509() bool {
+	return keyType == RsaX509Pem || keyType == Es256X509Pem
+}
+
+// ParseKeyType returns the KeyType matching a name, case insensitive.
+func ParseKeyType(name string) (KeyType, error) {
+	for i, keyType := range keyTypeName {
+		if strings.EqualFold(keyType, name) {
+			return KeyType(i + 1), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key type %q, must be one of %s", name, strings.Join(keyTypeName[:], ", "))
+}
+
+var fileKeyStoresMutex sync.Mutex
+var fileKeyStores = map[string]*keystore.FileKeyStore{}
+
+// GenerateJWT will generate a signed JWT token, the parsed private key is cached until the file changes.
 func GenerateJWT(projectID, privateKeyFullPath string, expireTimeMin int) (string, error) {
-	privateKeyBytes, err := ioutil.ReadFile(privateKeyFullPath)
+	fileKeyStoresMutex.Lock()
+	keyStore, exist := fileKeyStores[privateKeyFullPath]
+	if !exist {
+		keyStore = keystore.NewFileKeyStore(privateKeyFullPath, nil)
+		fileKeyStores[privateKeyFullPath] = keyStore
+	}
+	fileKeyStoresMutex.Unlock()
+
+	return GenerateJWTWithKeyStore(projectID, keyStore, expireTimeMin)
+}
+
+// GenerateJWTWithKeyStore will generate a JWT token signed by the key store signer, RS256 for RSA keys
+// and ES256 for P-256 keys, stamped by DefaultClock.
+func GenerateJWTWithKeyStore(projectID string, keyStore keystore.KeyStore, expireTimeMin int) (string, error) {
+	return GenerateJWTWithOptions(projectID, keyStore, JWTOptions{Expiration: time.Minute * time.Duration(expireTimeMin)})
+}
+
+// GenerateJWTWithOptions will generate a JWT token signed by the key store signer with customized claims.
+func GenerateJWTWithOptions(projectID string, keyStore keystore.KeyStore, options JWTOptions) (string, error) {
+	if err := options.Validate(); err != nil {
+		log.Errorln(err.Error())
+		return "", err
+	}
+
+	signer, err := keyStore.Signer()
 	if err != nil {
 		log.Errorln(err.Error())
 		return "", err
 	}
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyBytes)
+
+	method, err := signingMethodFor(signer)
 	if err != nil {
 		log.Errorln(err.Error())
 		return "", err
 	}
 
-	t := time.Now()
-	token := jwt.NewWithClaims(jwt.GetSigningMethod(jwt.SigningMethodRS256.Alg()), &jwt.StandardClaims{
-		IssuedAt:  t.Unix(),
-		ExpiresAt: t.Add(time.Minute * time.Duration(expireTimeMin)).Unix(),
-		Audience:  projectID,
-	})
-	pass, err := token.SignedString(privateKey)
+	token := jwt.NewWithClaims(method, options.claims(projectID))
+	pass, err := token.SignedString(signer)
 
 	if err != nil {
 		log.Errorln(err.Error())
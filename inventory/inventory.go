@@ -0,0 +1,293 @@
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+// RegistryConnector is the part of the registry admin connector an export needs,
+// HTTPIotRegistryConnectorInterface implements it. ListRegistries returns every page.
+type RegistryConnector interface {
+	ListRegistries() ([]*cloudiot.DeviceRegistry, error)
+}
+
+// DeviceConnector is the part of the device admin connector an export needs, HTTPIotDeviceConnectorInterface
+// implements it. ListDevices and ListBoundDevices return every page.
+type DeviceConnector interface {
+	ListDevices() ([]*cloudiot.Device, error)
+	ListBoundDevices(gatewayID string) ([]*cloudiot.Device, error)
+	GetDevice(deviceID string) (*cloudiot.Device, error)
+}
+
+// Credential is the public part of a device credential, Cloud IoT never returns private keys.
+type Credential struct {
+	Format         string `json:"format"`
+	Key            string `json:"key"`
+	ExpirationTime string `json:"expirationTime,omitempty"`
+}
+
+// Record is one device of a snapshot, flat so it loads as a BigQuery table. Timestamps are nil when the
+// device never did it.
+type Record struct {
+	SnapshotTime       *time.Time        `json:"snapshotTime"`
+	Registry           string            `json:"registry"`
+	DeviceID           string            `json:"deviceId"`
+	NumID              uint64            `json:"numId,string"`
+	Blocked            bool              `json:"blocked"`
+	Metadata           map[string]string `json:"metadata"`
+	Credentials        []Credential      `json:"credentials"`
+	LastHeartbeatTime  *time.Time        `json:"lastHeartbeatTime"`
+	LastEventTime      *time.Time        `json:"lastEventTime"`
+	LastStateTime      *time.Time        `json:"lastStateTime"`
+	LastConfigAckTime  *time.Time        `json:"lastConfigAckTime"`
+	LastConfigSendTime *time.Time        `json:"lastConfigSendTime"`
+	LastErrorTime      *time.Time        `json:"lastErrorTime"`
+	LastErrorMessage   string            `json:"lastErrorMessage,omitempty"`
+	// ConfigVersion is the latest config version of the device, zero when it never had one.
+	ConfigVersion int64 `json:"configVersion,string"`
+	// GatewayType is GATEWAY or NON_GATEWAY, empty when Cloud IoT does not report it.
+	GatewayType string `json:"gatewayType,omitempty"`
+	// Gateways are the gateways the device is bound to, BoundDevices the devices bound to a gateway.
+	Gateways     []string `json:"gateways"`
+	BoundDevices []string `json:"boundDevices"`
+}
+
+// gateway is the GatewayType of a gateway device.
+const gateway = "GATEWAY"
+
+// NewRecord flatten a device read at snapshotTime.
+func NewRecord(registryID string, device *cloudiot.Device, snapshotTime time.Time) (*Record, error) {
+	snapshot := snapshotTime.UTC()
+	record := &Record{
+		SnapshotTime: &snapshot,
+		Registry:     registryID,
+		DeviceID:     device.Id,
+		NumID:        device.NumId,
+		Blocked:      device.Blocked,
+		Metadata:     device.Metadata,
+		Credentials:  []Credential{},
+		Gateways:     []string{},
+		BoundDevices: []string{},
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]string{}
+	}
+	for _, credential := range device.Credentials {
+		if credential.PublicKey != nil {
+			record.Credentials = append(record.Credentials, Credential{
+				Format:         credential.PublicKey.Format,
+				Key:            credential.PublicKey.Key,
+				ExpirationTime: credential.ExpirationTime,
+			})
+		}
+	}
+	if device.Config != nil {
+		record.ConfigVersion = device.Config.Version
+	}
+	if device.GatewayConfig != nil {
+		record.GatewayType = device.GatewayConfig.GatewayType
+	}
+	if device.LastErrorStatus != nil {
+		record.LastErrorMessage = device.LastErrorStatus.Message
+	}
+
+	var err error
+	for _, timestamp := range []struct {
+		value  string
+		target **time.Time
+	}{
+		{device.LastHeartbeatTime, &record.LastHeartbeatTime},
+		{device.LastEventTime, &record.LastEventTime},
+		{device.LastStateTime, &record.LastStateTime},
+		{device.LastConfigAckTime, &record.LastConfigAckTime},
+		{device.LastConfigSendTime, &record.LastConfigSendTime},
+		{device.LastErrorTime, &record.LastErrorTime},
+	} {
+		if *timestamp.target, err = parseTime(timestamp.value); err != nil {
+			return nil, fmt.Errorf("device %s: %s", device.Id, err.Error())
+		}
+	}
+	return record, nil
+}
+
+// Options tune an export.
+type Options struct {
+	// Registries restrict the export, every registry of the project when empty.
+	Registries []string
+	// Concurrency is the number of devices read at once, one when not positive.
+	Concurrency int
+}
+
+// Report count what an export wrote.
+type Report struct {
+	SnapshotTime time.Time     `json:"snapshotTime"`
+	Registries   int           `json:"registries"`
+	Devices      int           `json:"devices"`
+	Failed       int           `json:"failed"`
+	Elapsed      time.Duration `json:"elapsed"`
+}
+
+// Exporter walk the registries of a project and the devices of every registry.
+type Exporter struct {
+	Registries RegistryConnector
+	// Devices returns the device connector of a registry.
+	Devices func(registryID string) (DeviceConnector, error)
+}
+
+// Export write a snapshot of every device, sorted by registry and device ID, all stamped with the time the
+// export started. Devices that could not be read are logged and counted in the report, the snapshot is then
+// incomplete and an error is returned once the others are written.
+func (exporter *Exporter) Export(writer Writer, options Options) (Report, error) {
+	started := time.Now()
+	report := Report{SnapshotTime: started.UTC()}
+
+	registries, err := exporter.Registries.ListRegistries()
+	if err != nil {
+		return report, err
+	}
+	selected := map[string]bool{}
+	for _, registryID := range options.Registries {
+		selected[registryID] = true
+	}
+	registryIDs := []string{}
+	for _, registry := range registries {
+		if len(options.Registries) == 0 || selected[registry.Id] {
+			registryIDs = append(registryIDs, registry.Id)
+			selected[registry.Id] = false
+		}
+	}
+	missing := []string{}
+	for registryID, notFound := range selected {
+		if notFound {
+			missing = append(missing, registryID)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return report, fmt.Errorf("registries not found: %s", strings.Join(missing, ", "))
+	}
+	sort.Strings(registryIDs)
+
+	for _, registryID := range registryIDs {
+		records, failed, err := exporter.exportRegistry(registryID, report.SnapshotTime, options.Concurrency)
+		if err != nil {
+			return report, fmt.Errorf("registry %s: %s", registryID, err.Error())
+		}
+		for _, record := range records {
+			if err = writer.Write(record); err != nil {
+				return report, err
+			}
+		}
+		report.Registries++
+		report.Devices += len(records)
+		report.Failed += failed
+	}
+
+	if err = writer.Close(); err != nil {
+		return report, err
+	}
+	report.Elapsed = time.Since(started)
+	if report.Failed > 0 {
+		return report, fmt.Errorf("%d device(s) could not be read, the snapshot is incomplete", report.Failed)
+	}
+	return report, nil
+}
+
+// exportRegistry read the full details of every device of a registry, ListDevices only returns their IDs.
+func (exporter *Exporter) exportRegistry(registryID string, snapshotTime time.Time, concurrency int) (records []*Record, failed int, err error) {
+	connector, err := exporter.Devices(registryID)
+	if err != nil {
+		return
+	}
+	devices, err := connector.ListDevices()
+	if err != nil {
+		return
+	}
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var mutex sync.Mutex
+	deviceIDs := make(chan string)
+	var wait sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for deviceID := range deviceIDs {
+				record, readErr := readDevice(connector, registryID, deviceID, snapshotTime)
+				mutex.Lock()
+				if readErr != nil {
+					log.Errorln(registryID + "/" + deviceID + ": " + readErr.Error())
+					failed++
+				} else {
+					records = append(records, record)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, device := range devices {
+		deviceIDs <- device.Id
+	}
+	close(deviceIDs)
+	wait.Wait()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].DeviceID < records[j].DeviceID })
+	failed += bindGateways(connector, registryID, records)
+	return
+}
+
+// bindGateways fill the bindings of both ends, the devices bound to every gateway and the gateways of every
+// bound device. Gateways whose bindings could not be read are logged and counted.
+func bindGateways(connector DeviceConnector, registryID string, records []*Record) (failed int) {
+	byID := map[string]*Record{}
+	for _, record := range records {
+		byID[record.DeviceID] = record
+	}
+	for _, record := range records {
+		if record.GatewayType != gateway {
+			continue
+		}
+		bound, err := connector.ListBoundDevices(record.DeviceID)
+		if err != nil {
+			log.Errorln(registryID + "/" + record.DeviceID + ": " + err.Error())
+			failed++
+			continue
+		}
+		for _, device := range bound {
+			record.BoundDevices = append(record.BoundDevices, device.Id)
+			if boundRecord := byID[device.Id]; boundRecord != nil {
+				boundRecord.Gateways = append(boundRecord.Gateways, record.DeviceID)
+			}
+		}
+		sort.Strings(record.BoundDevices)
+	}
+	return
+}
+
+func readDevice(connector DeviceConnector, registryID, deviceID string, snapshotTime time.Time) (*Record, error) {
+	device, err := connector.GetDevice(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecord(registryID, device, snapshotTime)
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, err
+	}
+	parsed = parsed.UTC()
+	return &parsed, nil
+}
@@ -0,0 +1,214 @@
+package inventory_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/inventory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type InventoryTestSuite struct {
+	suite.Suite
+	exporter *inventory.Exporter
+	devices  map[string]*fakeDeviceConnector
+}
+
+type fakeRegistryConnector struct{}
+
+func (fake *fakeRegistryConnector) ListRegistries() ([]*cloudiot.DeviceRegistry, error) {
+	return []*cloudiot.DeviceRegistry{{Id: "registry-b"}, {Id: "registry-a"}}, nil
+}
+
+type fakeDeviceConnector struct {
+	devices  map[string]*cloudiot.Device
+	bindings map[string][]string
+}
+
+func (fake *fakeDeviceConnector) ListDevices() ([]*cloudiot.Device, error) {
+	devices := []*cloudiot.Device{}
+	for id := range fake.devices {
+		devices = append(devices, &cloudiot.Device{Id: id})
+	}
+	return devices, nil
+}
+
+func (fake *fakeDeviceConnector) ListBoundDevices(gatewayID string) ([]*cloudiot.Device, error) {
+	devices := []*cloudiot.Device{}
+	for _, id := range fake.bindings[gatewayID] {
+		devices = append(devices, &cloudiot.Device{Id: id})
+	}
+	return devices, nil
+}
+
+func (fake *fakeDeviceConnector) GetDevice(deviceID string) (*cloudiot.Device, error) {
+	if device := fake.devices[deviceID]; device != nil {
+		return device, nil
+	}
+	return nil, errors.New("device deleted while exporting")
+}
+
+func (suite *InventoryTestSuite) SetupTest() {
+	suite.devices = map[string]*fakeDeviceConnector{
+		"registry-a": {devices: map[string]*cloudiot.Device{
+			"sensor-2": {Id: "sensor-2", NumId: 2, Blocked: true},
+			"sensor-1": {
+				Id:       "sensor-1",
+				NumId:    1,
+				Metadata: map[string]string{"site": "lab"},
+				Credentials: []*cloudiot.DeviceCredential{
+					{PublicKey: &cloudiot.PublicKeyCredential{Format: "ES256_PEM", Key: "public key"}, ExpirationTime: "2030-01-01T00:00:00Z"},
+				},
+				LastHeartbeatTime: "2018-03-01T12:00:00.5Z",
+				LastEventTime:     "2018-03-01T12:01:00Z",
+				Config:            &cloudiot.DeviceConfig{Version: 7},
+				LastErrorStatus:   &cloudiot.Status{Message: "mqtt: bad payload"},
+			},
+		}},
+		"registry-b": {
+			devices: map[string]*cloudiot.Device{
+				"sensor-3": {Id: "sensor-3", NumId: 3},
+			},
+		},
+	}
+	suite.exporter = &inventory.Exporter{
+		Registries: &fakeRegistryConnector{},
+		Devices: func(registryID string) (inventory.DeviceConnector, error) {
+			return suite.devices[registryID], nil
+		},
+	}
+}
+
+func (suite *InventoryTestSuite) TestExportJSONL() {
+	output := &bytes.Buffer{}
+	writer, err := inventory.NewWriter(inventory.JSONL, output)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	report, err := suite.exporter.Export(writer, inventory.Options{Concurrency: 2})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), 2, report.Registries)
+	assert.Equal(suite.T(), 3, report.Devices)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(suite.T(), lines, 3)
+	records := []inventory.Record{}
+	for _, line := range lines {
+		record := inventory.Record{}
+		assert.NoError(suite.T(), json.Unmarshal([]byte(line), &record), "UnexpectedError")
+		records = append(records, record)
+	}
+	assert.Equal(suite.T(), []string{"sensor-1", "sensor-2", "sensor-3"}, []string{records[0].DeviceID, records[1].DeviceID, records[2].DeviceID})
+	assert.Equal(suite.T(), "registry-b", records[2].Registry)
+	assert.Equal(suite.T(), int64(7), records[0].ConfigVersion)
+	assert.Equal(suite.T(), []inventory.Credential{{Format: "ES256_PEM", Key: "public key", ExpirationTime: "2030-01-01T00:00:00Z"}}, records[0].Credentials)
+	assert.Equal(suite.T(), "2018-03-01T12:00:00.5Z", records[0].LastHeartbeatTime.Format("2006-01-02T15:04:05.999Z07:00"))
+	assert.Nil(suite.T(), records[0].LastStateTime)
+	assert.Equal(suite.T(), report.SnapshotTime, *records[2].SnapshotTime)
+	assert.Contains(suite.T(), lines[1], "\"lastStateTime\":null")
+	assert.Contains(suite.T(), lines[1], "\"metadata\":{}")
+}
+
+func (suite *InventoryTestSuite) TestExportCSV() {
+	output := &bytes.Buffer{}
+	writer, err := inventory.NewWriter(inventory.CSV, output)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	_, err = suite.exporter.Export(writer, inventory.Options{Registries: []string{"registry-a"}})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	rows, err := csv.NewReader(output).ReadAll()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), rows, 3)
+	assert.Equal(suite.T(), "deviceId", rows[0][2])
+	assert.Equal(suite.T(), []string{"registry-a", "sensor-1", "1", "false", "{\"site\":\"lab\"}"}, rows[1][1:6])
+	assert.Equal(suite.T(), "", rows[2][9])
+	assert.Equal(suite.T(), "7", rows[1][14])
+	assert.Equal(suite.T(), []string{"", "[]", "[]"}, rows[1][15:])
+}
+
+func (suite *InventoryTestSuite) TestGatewayBindings() {
+	suite.devices["registry-b"].devices["gateway-1"] = &cloudiot.Device{Id: "gateway-1", NumId: 4, GatewayConfig: &cloudiot.GatewayConfig{GatewayType: "GATEWAY"}}
+	suite.devices["registry-b"].bindings = map[string][]string{"gateway-1": {"sensor-3"}}
+	output := &bytes.Buffer{}
+	writer, _ := inventory.NewWriter(inventory.JSONL, output)
+
+	_, err := suite.exporter.Export(writer, inventory.Options{Registries: []string{"registry-b"}})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(suite.T(), lines, 2)
+	gateway, sensor := inventory.Record{}, inventory.Record{}
+	assert.NoError(suite.T(), json.Unmarshal([]byte(lines[0]), &gateway), "UnexpectedError")
+	assert.NoError(suite.T(), json.Unmarshal([]byte(lines[1]), &sensor), "UnexpectedError")
+	assert.Equal(suite.T(), "GATEWAY", gateway.GatewayType)
+	assert.Equal(suite.T(), []string{"sensor-3"}, gateway.BoundDevices)
+	assert.Equal(suite.T(), []string{}, gateway.Gateways)
+	assert.Equal(suite.T(), []string{"gateway-1"}, sensor.Gateways)
+}
+
+func (suite *InventoryTestSuite) TestExportParquet() {
+	output := &bytes.Buffer{}
+	writer, err := inventory.NewWriter(inventory.Parquet, output)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	report, err := suite.exporter.Export(writer, inventory.Options{Registries: []string{"registry-a"}})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), 2, report.Devices)
+
+	file, err := buffer.NewBufferFile(output.Bytes())
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	parquet, err := reader.NewParquetColumnReader(file, 1)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	defer parquet.ReadStop()
+	assert.Equal(suite.T(), int64(2), parquet.GetNumRows())
+
+	deviceIDs, _, _, err := parquet.ReadColumnByPath("parquet_go_root.deviceId", 2)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), []interface{}{"sensor-1", "sensor-2"}, deviceIDs)
+	heartbeats, _, _, err := parquet.ReadColumnByPath("parquet_go_root.lastHeartbeatTime", 2)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), []interface{}{time.Date(2018, 3, 1, 12, 0, 0, 5e8, time.UTC).UnixNano() / int64(time.Microsecond), nil}, heartbeats)
+}
+
+func (suite *InventoryTestSuite) TestIncompleteSnapshot() {
+	suite.devices["registry-b"].devices["sensor-4"] = nil
+	output := &bytes.Buffer{}
+	writer, _ := inventory.NewWriter(inventory.JSONL, output)
+
+	report, err := suite.exporter.Export(writer, inventory.Options{})
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), 3, report.Devices)
+	assert.Equal(suite.T(), 1, report.Failed)
+	assert.Equal(suite.T(), 3, strings.Count(output.String(), "\n"))
+}
+
+func (suite *InventoryTestSuite) TestUnknownRegistry() {
+	writer, _ := inventory.NewWriter(inventory.JSONL, &bytes.Buffer{})
+	_, err := suite.exporter.Export(writer, inventory.Options{Registries: []string{"registry-a", "registry-z"}})
+	assert.EqualError(suite.T(), err, "registries not found: registry-z")
+}
+
+func (suite *InventoryTestSuite) TestParseFormat() {
+	format, err := inventory.ParseFormat("CSV")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), inventory.CSV, format)
+
+	format, err = inventory.ParseFormat("parquet")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), inventory.Parquet, format)
+
+	_, err = inventory.ParseFormat("avro")
+	assert.EqualError(suite.T(), err, `unknown export format "avro", must be jsonl, csv or parquet`)
+}
+
+func TestInventoryTestSuite(t *testing.T) {
+	suite.Run(t, new(InventoryTestSuite))
+}
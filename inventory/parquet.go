@@ -0,0 +1,101 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is a Record as a Parquet row, in the JSONL field names. Never seen timestamps are NULL.
+type parquetRow struct {
+	SnapshotTime       int64   `parquet:"name=snapshotTime, type=TIMESTAMP_MICROS"`
+	Registry           string  `parquet:"name=registry, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	DeviceID           string  `parquet:"name=deviceId, type=UTF8"`
+	NumID              string  `parquet:"name=numId, type=UTF8"`
+	Blocked            bool    `parquet:"name=blocked, type=BOOLEAN"`
+	Metadata           string  `parquet:"name=metadata, type=UTF8"`
+	Credentials        string  `parquet:"name=credentials, type=UTF8"`
+	LastHeartbeatTime  *int64  `parquet:"name=lastHeartbeatTime, type=TIMESTAMP_MICROS, repetitiontype=OPTIONAL"`
+	LastEventTime      *int64  `parquet:"name=lastEventTime, type=TIMESTAMP_MICROS, repetitiontype=OPTIONAL"`
+	LastStateTime      *int64  `parquet:"name=lastStateTime, type=TIMESTAMP_MICROS, repetitiontype=OPTIONAL"`
+	LastConfigAckTime  *int64  `parquet:"name=lastConfigAckTime, type=TIMESTAMP_MICROS, repetitiontype=OPTIONAL"`
+	LastConfigSendTime *int64  `parquet:"name=lastConfigSendTime, type=TIMESTAMP_MICROS, repetitiontype=OPTIONAL"`
+	LastErrorTime      *int64  `parquet:"name=lastErrorTime, type=TIMESTAMP_MICROS, repetitiontype=OPTIONAL"`
+	LastErrorMessage   *string `parquet:"name=lastErrorMessage, type=UTF8, repetitiontype=OPTIONAL"`
+	ConfigVersion      int64   `parquet:"name=configVersion, type=INT64"`
+	GatewayType        *string `parquet:"name=gatewayType, type=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Gateways           string  `parquet:"name=gateways, type=UTF8"`
+	BoundDevices       string  `parquet:"name=boundDevices, type=UTF8"`
+}
+
+type parquetWriter struct {
+	writer *writer.ParquetWriter
+}
+
+func newParquetWriter(output io.Writer) (Writer, error) {
+	parquet, err := writer.NewParquetWriterFromWriter(output, new(parquetRow), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetWriter{writer: parquet}, nil
+}
+
+func (writer *parquetWriter) Write(record *Record) error {
+	row := parquetRow{
+		SnapshotTime:       *parquetTime(record.SnapshotTime),
+		Registry:           record.Registry,
+		DeviceID:           record.DeviceID,
+		NumID:              strconv.FormatUint(record.NumID, 10),
+		Blocked:            record.Blocked,
+		LastHeartbeatTime:  parquetTime(record.LastHeartbeatTime),
+		LastEventTime:      parquetTime(record.LastEventTime),
+		LastStateTime:      parquetTime(record.LastStateTime),
+		LastConfigAckTime:  parquetTime(record.LastConfigAckTime),
+		LastConfigSendTime: parquetTime(record.LastConfigSendTime),
+		LastErrorTime:      parquetTime(record.LastErrorTime),
+		LastErrorMessage:   parquetString(record.LastErrorMessage),
+		ConfigVersion:      record.ConfigVersion,
+		GatewayType:        parquetString(record.GatewayType),
+	}
+	for _, column := range []struct {
+		value  interface{}
+		target *string
+	}{
+		{record.Metadata, &row.Metadata},
+		{record.Credentials, &row.Credentials},
+		{record.Gateways, &row.Gateways},
+		{record.BoundDevices, &row.BoundDevices},
+	} {
+		value, err := json.Marshal(column.value)
+		if err != nil {
+			return err
+		}
+		*column.target = string(value)
+	}
+	return writer.writer.Write(row)
+}
+
+// Close write the buffered row group and the footer, the file is unreadable without it.
+func (writer *parquetWriter) Close() error {
+	return writer.writer.WriteStop()
+}
+
+// parquetTime returns value as microseconds since the epoch, nil when never seen.
+func parquetTime(value *time.Time) *int64 {
+	if value == nil {
+		return nil
+	}
+	micros := value.UnixNano() / int64(time.Microsecond)
+	return &micros
+}
+
+// parquetString leave empty strings NULL, like csvTime does for timestamps.
+func parquetString(value string) *string {
+	if len(value) == 0 {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,132 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the file format of an export.
+type Format int
+
+const (
+	// JSONL writes one JSON record per line, loadable as BigQuery newline delimited JSON.
+	JSONL Format = 1 + iota
+	// CSV writes a header and one row per record, metadata, credentials and bindings as JSON columns.
+	CSV
+	// Parquet writes a Snappy compressed file, metadata, credentials and bindings as JSON columns like CSV.
+	Parquet
+)
+
+var formatName = [...]string{
+	"jsonl",
+	"csv",
+	"parquet",
+}
+
+func (format Format) String() string {
+	return formatName[format-1]
+}
+
+// ParseFormat returns the Format of a name, case insensitive.
+func ParseFormat(name string) (Format, error) {
+	for i, formatName := range formatName {
+		if strings.EqualFold(formatName, name) {
+			return Format(i + 1), nil
+		}
+	}
+	return 0, errors.New("unknown export format " + strconv.Quote(name) + ", must be jsonl, csv or parquet")
+}
+
+// Writer write the records of an export, Close flushes the buffered ones.
+type Writer interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// NewWriter create a Writer of format on writer.
+func NewWriter(format Format, writer io.Writer) (Writer, error) {
+	switch format {
+	case JSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(writer)}, nil
+	case CSV:
+		return &csvWriter{writer: csv.NewWriter(writer)}, nil
+	case Parquet:
+		return newParquetWriter(writer)
+	}
+	return nil, errors.New("unknown export format")
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (writer *jsonlWriter) Write(record *Record) error {
+	return writer.encoder.Encode(record)
+}
+
+func (writer *jsonlWriter) Close() error {
+	return nil
+}
+
+// csvColumns are the CSV header, in the JSONL field names.
+var csvColumns = []string{
+	"snapshotTime", "registry", "deviceId", "numId", "blocked", "metadata", "credentials", "lastHeartbeatTime",
+	"lastEventTime", "lastStateTime", "lastConfigAckTime", "lastConfigSendTime", "lastErrorTime", "lastErrorMessage",
+	"configVersion", "gatewayType", "gateways", "boundDevices",
+}
+
+type csvWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (writer *csvWriter) Write(record *Record) error {
+	if !writer.headerWritten {
+		if err := writer.writer.Write(csvColumns); err != nil {
+			return err
+		}
+		writer.headerWritten = true
+	}
+
+	metadata, err := json.Marshal(record.Metadata)
+	if err != nil {
+		return err
+	}
+	credentials, err := json.Marshal(record.Credentials)
+	if err != nil {
+		return err
+	}
+	gateways, err := json.Marshal(record.Gateways)
+	if err != nil {
+		return err
+	}
+	boundDevices, err := json.Marshal(record.BoundDevices)
+	if err != nil {
+		return err
+	}
+	return writer.writer.Write([]string{
+		csvTime(record.SnapshotTime), record.Registry, record.DeviceID, strconv.FormatUint(record.NumID, 10),
+		strconv.FormatBool(record.Blocked), string(metadata), string(credentials), csvTime(record.LastHeartbeatTime),
+		csvTime(record.LastEventTime), csvTime(record.LastStateTime), csvTime(record.LastConfigAckTime),
+		csvTime(record.LastConfigSendTime), csvTime(record.LastErrorTime), record.LastErrorMessage,
+		strconv.FormatInt(record.ConfigVersion, 10), record.GatewayType, string(gateways), string(boundDevices),
+	})
+}
+
+func (writer *csvWriter) Close() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+// csvTime leave never seen timestamps empty, BigQuery loads them as NULL.
+func csvTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,111 @@
+package keygen
+
+import (
+	"crypto"
+	"path/filepath"
+	"time"
+)
+
+const defaultValidity = 3650 * 24 * time.Hour
+
+// DeviceFilesRequest define the key material generated for a device, the same set the makefile used to
+// produce with openssl.
+type DeviceFilesRequest struct {
+	Dir        string
+	CommonName string
+	Validity   time.Duration
+	Format     KeyFormat
+	// CACertPath and CAKeyPath sign the device certificates when set, otherwise they are self-signed.
+	CACertPath string
+	CAKeyPath  string
+	// CAKeyPassphrase decrypt an encrypted CA private key.
+	CAKeyPassphrase []byte
+}
+
+// DeviceFiles are the paths written by GenerateDeviceFiles.
+type DeviceFiles struct {
+	RSAPrivate string
+	RSAPublic  string
+	RSACert    string
+	ECPrivate  string
+	ECPublic   string
+	ECCert     string
+}
+
+// GenerateDeviceFiles write an RSA-2048 and a P-256 key pair with their X.509 certificates.
+func GenerateDeviceFiles(request DeviceFilesRequest) (files DeviceFiles, err error) {
+	if request.Format == 0 {
+		request.Format = PKCS8
+	}
+	if request.Validity == 0 {
+		request.Validity = defaultValidity
+	}
+
+	files = DeviceFiles{
+		RSAPrivate: filepath.Join(request.Dir, "rsa_private.pem"),
+		RSAPublic:  filepath.Join(request.Dir, "rsa_public.pem"),
+		RSACert:    filepath.Join(request.Dir, "rsa_cert.pem"),
+		ECPrivate:  filepath.Join(request.Dir, "ec_private.pem"),
+		ECPublic:   filepath.Join(request.Dir, "ec_public.pem"),
+		ECCert:     filepath.Join(request.Dir, "ec_cert.pem"),
+	}
+
+	rsaKey, err := GenerateRSA(DefaultRSABits)
+	if err != nil {
+		return
+	}
+	if err = writeKeyPair(request, rsaKey, files.RSAPrivate, files.RSAPublic, files.RSACert); err != nil {
+		return
+	}
+
+	ecKey, err := GenerateP256()
+	if err != nil {
+		return
+	}
+	err = writeKeyPair(request, ecKey, files.ECPrivate, files.ECPublic, files.ECCert)
+
+	return
+}
+
+func writeKeyPair(request DeviceFilesRequest, key crypto.Signer, privatePath, publicPath, certPath string) error {
+	privatePEM, err := PrivateKeyPEM(key, request.Format)
+	if err != nil {
+		return err
+	}
+	if err = WritePrivateKey(privatePath, privatePEM); err != nil {
+		return err
+	}
+
+	publicPEM, err := PublicKeyPEM(key.Public())
+	if err != nil {
+		return err
+	}
+	if err = WritePublic(publicPath, publicPEM); err != nil {
+		return err
+	}
+
+	certificateRequest := CertificateRequest{CommonName: request.CommonName, Validity: request.Validity}
+	if len(request.CACertPath) == 0 {
+		selfSigned, err := SelfSignedCertificate(key, certificateRequest)
+		if err != nil {
+			return err
+		}
+		return WritePublic(certPath, CertificatePEM(selfSigned))
+	}
+
+	caCert, err := LoadCertificate(request.CACertPath)
+	if err != nil {
+		return err
+	}
+	caKey, err := LoadPrivateKey(request.CAKeyPath, request.CAKeyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	signed, err := CASignedCertificate(key.Public(), certificateRequest, caCert, caKey)
+	if err != nil {
+		return err
+	}
+
+	return WritePublic(certPath, CertificatePEM(signed))
+}
@@ -0,0 +1,223 @@
+package keygen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pjgg/iotPlayground/keystore"
+)
+
+// KeyFormat define the PEM encoding of a private key.
+type KeyFormat int
+
+const (
+	// PKCS1 is the traditional openssl format, RSA PRIVATE KEY for RSA keys and EC PRIVATE KEY (SEC 1) for EC keys.
+	PKCS1 KeyFormat = 1 + iota
+	// PKCS8 is the algorithm agnostic PRIVATE KEY format.
+	PKCS8
+)
+
+var keyFormatName = [...]string{
+	"PKCS1",
+	"PKCS8",
+}
+
+func (format KeyFormat) String() string {
+	return keyFormatName[format-1]
+}
+
+// DefaultRSABits is the RSA key size accepted by Cloud IoT.
+const DefaultRSABits = 2048
+
+// PrivateKeyPerm is the file mode of private keys, only readable by its owner.
+const PrivateKeyPerm os.FileMode = 0600
+
+// PublicPerm is the file mode of public keys and certificates.
+const PublicPerm os.FileMode = 0644
+
+// GenerateRSA generate an RSA key pair, DefaultRSABits when bits is zero.
+func GenerateRSA(bits int) (*rsa.PrivateKey, error) {
+	if bits == 0 {
+		bits = DefaultRSABits
+	}
+	return rsa.GenerateKey(rand.Reader, bits)
+}
+
+// GenerateP256 generate an ECDSA key pair over the P-256 curve, as used by ES256.
+func GenerateP256() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// CertificateRequest define the subject and validity of a certificate to issue.
+type CertificateRequest struct {
+	CommonName string
+	Validity   time.Duration
+	IsCA       bool
+}
+
+// SelfSignedCertificate issue a certificate signed by its own key, like openssl req -x509.
+func SelfSignedCertificate(key crypto.Signer, request CertificateRequest) (*x509.Certificate, error) {
+	template, err := newTemplate(key.Public(), request)
+	if err != nil {
+		return nil, err
+	}
+
+	return createCertificate(template, template, key.Public(), key)
+}
+
+// CASignedCertificate issue a certificate for publicKey signed by a CA.
+func CASignedCertificate(publicKey crypto.PublicKey, request CertificateRequest, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("certificate %q is not a CA", caCert.Subject.CommonName)
+	}
+
+	template, err := newTemplate(publicKey, request)
+	if err != nil {
+		return nil, err
+	}
+	template.AuthorityKeyId = caCert.SubjectKeyId
+
+	return createCertificate(template, caCert, publicKey, caKey)
+}
+
+// PrivateKeyPEM encode an RSA or ECDSA private key.
+func PrivateKeyPEM(key crypto.Signer, format KeyFormat) ([]byte, error) {
+	var block *pem.Block
+
+	switch {
+	case format == PKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case format == PKCS1:
+		switch typedKey := key.(type) {
+		case *rsa.PrivateKey:
+			block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(typedKey)}
+		case *ecdsa.PrivateKey:
+			der, err := x509.MarshalECPrivateKey(typedKey)
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+	default:
+		return nil, fmt.Errorf("unknown key format %d", format)
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// PublicKeyPEM encode a public key as PKIX PUBLIC KEY, the format Cloud IoT expects for RSA_PEM and ES256_PEM.
+func PublicKeyPEM(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// CertificatePEM encode a certificate, the format Cloud IoT expects for RSA_X509_PEM and ES256_X509_PEM.
+func CertificatePEM(certificate *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+}
+
+// LoadPrivateKey read a PKCS1, SEC 1 or PKCS8 private key, see keystore.ParsePrivateKeyPEM.
+func LoadPrivateKey(path string, passphrase []byte) (crypto.Signer, error) {
+	return keystore.NewFileKeyStore(path, passphrase).Signer()
+}
+
+// LoadCertificate read the first certificate of a PEM file.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM certificate", path)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// WritePrivateKey write a PEM private key only readable by its owner, an existing file mode is tightened too.
+func WritePrivateKey(path string, pemBytes []byte) error {
+	return writeFile(path, pemBytes, PrivateKeyPerm)
+}
+
+// WritePublic write a PEM public key or certificate.
+func WritePublic(path string, pemBytes []byte) error {
+	return writeFile(path, pemBytes, PublicPerm)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of existing files and applies the umask to new ones
+	return os.Chmod(path, perm)
+}
+
+func newTemplate(publicKey crypto.PublicKey, request CertificateRequest) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	subjectKeyID := sha1.Sum(publicKeyDER)
+
+	notBefore := time.Now().Add(-time.Minute)
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: request.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(request.Validity),
+		SubjectKeyId:          subjectKeyID[:],
+		BasicConstraintsValid: true,
+		IsCA:                  request.IsCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if request.IsCA {
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		template.ExtKeyUsage = nil
+	}
+
+	return template, nil
+}
+
+func createCertificate(template, parent *x509.Certificate, publicKey crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
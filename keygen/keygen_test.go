@@ -0,0 +1,82 @@
+package keygen_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/keygen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type KeygenTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *KeygenTestSuite) TestGenerateDeviceFiles() {
+	files, err := keygen.GenerateDeviceFiles(keygen.DeviceFilesRequest{Dir: suite.dir, CommonName: "test-device", Validity: time.Hour})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	info, err := os.Stat(files.RSAPrivate)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), keygen.PrivateKeyPerm, info.Mode().Perm())
+	info, _ = os.Stat(files.ECPublic)
+	assert.EqualValues(suite.T(), keygen.PublicPerm, info.Mode().Perm())
+
+	rsaKey, err := keygen.LoadPrivateKey(files.RSAPrivate, nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), keygen.DefaultRSABits, rsaKey.(*rsa.PrivateKey).N.BitLen())
+
+	ecKey, err := keygen.LoadPrivateKey(files.ECPrivate, nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), "P-256", ecKey.(*ecdsa.PrivateKey).Curve.Params().Name)
+
+	certificate, err := keygen.LoadCertificate(files.ECCert)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), "test-device", certificate.Subject.CommonName)
+}
+
+func (suite *KeygenTestSuite) TestCASignedDeviceFiles() {
+	caKey, _ := keygen.GenerateP256()
+	caCert, err := keygen.SelfSignedCertificate(caKey, keygen.CertificateRequest{CommonName: "test-root", Validity: time.Hour, IsCA: true})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	caKeyPEM, _ := keygen.PrivateKeyPEM(caKey, keygen.PKCS1)
+	caKeyPath := filepath.Join(suite.dir, "ca", "ca_private.pem")
+	caCertPath := filepath.Join(suite.dir, "ca", "ca_cert.pem")
+	assert.NoError(suite.T(), keygen.WritePrivateKey(caKeyPath, caKeyPEM))
+	assert.NoError(suite.T(), keygen.WritePublic(caCertPath, keygen.CertificatePEM(caCert)))
+
+	files, err := keygen.GenerateDeviceFiles(keygen.DeviceFilesRequest{
+		Dir:        filepath.Join(suite.dir, "device"),
+		CommonName: "test-device",
+		Validity:   time.Hour,
+		Format:     keygen.PKCS1,
+		CACertPath: caCertPath,
+		CAKeyPath:  caKeyPath,
+	})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	deviceCert, _ := ioutil.ReadFile(files.RSACert)
+	err = connectors.VerifyCertificateChain(deviceCert, []string{string(keygen.CertificatePEM(caCert))})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+}
+
+func (suite *KeygenTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "keygen")
+}
+
+func (suite *KeygenTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func TestKeygenTestSuite(t *testing.T) {
+	suite.Run(t, new(KeygenTestSuite))
+}
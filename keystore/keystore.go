@@ -0,0 +1,237 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pjgg/iotPlayground/configuration"
+)
+
+// KeyStore provide the device private key signing JWTs. Implementations return a crypto.Signer so keys
+// kept in a secure element never have to leave it.
+type KeyStore interface {
+	Signer() (crypto.Signer, error)
+}
+
+// FileKeyStore read a PEM private key from disk, the parsed key is cached until the file changes.
+type FileKeyStore struct {
+	path       string
+	passphrase []byte
+	mutex      sync.Mutex
+	signer     crypto.Signer
+	modTime    time.Time
+}
+
+// NewFileKeyStore create a FileKeyStore, passphrase is only used for encrypted PEM blocks.
+func NewFileKeyStore(path string, passphrase []byte) *FileKeyStore {
+	return &FileKeyStore{path: path, passphrase: passphrase}
+}
+
+// Signer returns the cached key, reloaded when the file modification time changes.
+func (store *FileKeyStore) Signer() (crypto.Signer, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.signer != nil && info.ModTime().Equal(store.modTime) {
+		return store.signer, nil
+	}
+
+	pemBytes, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ParsePrivateKeyPEM(pemBytes, store.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", store.path, err.Error())
+	}
+
+	store.signer = signer
+	store.modTime = info.ModTime()
+	return signer, nil
+}
+
+// EnvKeyStore read a PEM private key, raw or base64 encoded, from an environment variable.
+type EnvKeyStore struct {
+	variable   string
+	passphrase []byte
+	once       sync.Once
+	signer     crypto.Signer
+	err        error
+}
+
+// NewEnvKeyStore create an EnvKeyStore, the variable is read and parsed once.
+func NewEnvKeyStore(variable string, passphrase []byte) *EnvKeyStore {
+	return &EnvKeyStore{variable: variable, passphrase: passphrase}
+}
+
+// Signer returns the key held by the environment variable.
+func (store *EnvKeyStore) Signer() (crypto.Signer, error) {
+	store.once.Do(func() {
+		value, exist := os.LookupEnv(store.variable)
+		if !exist || len(value) == 0 {
+			store.err = fmt.Errorf("missing private key ENV %s", store.variable)
+			return
+		}
+
+		pemBytes := []byte(value)
+		if !strings.Contains(value, "-----BEGIN") {
+			if pemBytes, store.err = base64.StdEncoding.DecodeString(strings.TrimSpace(value)); store.err != nil {
+				store.err = fmt.Errorf("ENV %s is neither PEM nor base64 PEM: %s", store.variable, store.err.Error())
+				return
+			}
+		}
+
+		if store.signer, store.err = ParsePrivateKeyPEM(pemBytes, store.passphrase); store.err != nil {
+			store.err = fmt.Errorf("ENV %s: %s", store.variable, store.err.Error())
+		}
+	})
+
+	return store.signer, store.err
+}
+
+// MemoryKeyStore hold an already parsed private key.
+type MemoryKeyStore struct {
+	signer crypto.Signer
+}
+
+// NewMemoryKeyStore parse a PEM private key once and keep it in memory.
+func NewMemoryKeyStore(pemBytes []byte, passphrase []byte) (*MemoryKeyStore, error) {
+	signer, err := ParsePrivateKeyPEM(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryKeyStore{signer: signer}, nil
+}
+
+// Signer returns the in memory key.
+func (store *MemoryKeyStore) Signer() (crypto.Signer, error) {
+	return store.signer, nil
+}
+
+// SignerKeyStore wrap any crypto.Signer, e.g. a PKCS#11 or TPM backed key.
+type SignerKeyStore struct {
+	signer crypto.Signer
+}
+
+// NewSignerKeyStore create a SignerKeyStore, the signer must hold an RSA or ECDSA P-256 public key.
+func NewSignerKeyStore(signer crypto.Signer) *SignerKeyStore {
+	return &SignerKeyStore{signer: signer}
+}
+
+// Signer returns the wrapped signer.
+func (store *SignerKeyStore) Signer() (crypto.Signer, error) {
+	return store.signer, nil
+}
+
+// ParsePrivateKeyPEM parse an RSA or ECDSA private key in PKCS1, SEC 1 or PKCS8 form. Encrypted PKCS8
+// (ENCRYPTED PRIVATE KEY, PBES2) and legacy encrypted PEM blocks (Proc-Type: 4,ENCRYPTED) are decrypted
+// with passphrase. Errors name the format found when it is not supported.
+func ParsePrivateKeyPEM(pemBytes []byte, passphrase []byte) (crypto.Signer, error) {
+	var block *pem.Block
+	for {
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM private key block found")
+		}
+		// openssl ecparam -genkey writes the curve parameters before the key
+		if block.Type != "EC PARAMETERS" {
+			break
+		}
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("%s PEM block is encrypted and no passphrase is set", block.Type)
+		}
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, passphrase); err != nil {
+			if err == x509.IncorrectPasswordError {
+				return nil, ErrWrongPassphrase
+			}
+			return nil, fmt.Errorf("unable to decrypt %s PEM block: %s", block.Type, err.Error())
+		}
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("malformed PKCS1 RSA PRIVATE KEY: %s", err.Error())
+		}
+		return key, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("malformed SEC 1 EC PRIVATE KEY: %s", err.Error())
+		}
+		return key, nil
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("ENCRYPTED PRIVATE KEY (encrypted PKCS8) requires a passphrase")
+		}
+		var err error
+		if der, err = decryptPKCS8(der, passphrase); err != nil {
+			return nil, err
+		}
+		return parsePKCS8(der)
+	case "PRIVATE KEY":
+		return parsePKCS8(der)
+	case "OPENSSH PRIVATE KEY":
+		return nil, fmt.Errorf("unsupported OpenSSH private key format, convert it with ssh-keygen -p -m PEM")
+	case "DSA PRIVATE KEY":
+		return nil, fmt.Errorf("unsupported DSA private key, Cloud IoT only accepts RSA and ES256 keys")
+	case "PUBLIC KEY", "RSA PUBLIC KEY", "CERTIFICATE":
+		return nil, fmt.Errorf("found a %s where a private key is expected", block.Type)
+	}
+
+	return nil, fmt.Errorf("unsupported %s PEM block", block.Type)
+}
+
+func parsePKCS8(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("malformed or unsupported PKCS8 PRIVATE KEY: %s", err.Error())
+	}
+
+	switch typedKey := key.(type) {
+	case *rsa.PrivateKey:
+		return typedKey, nil
+	case *ecdsa.PrivateKey:
+		return typedKey, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PKCS8 %T private key, must be RSA or ECDSA", key)
+}
+
+// FromConfiguration returns the device key store described by the configuration: the device.privateKeyEnv
+// ENV when set, the device private key file otherwise.
+func FromConfiguration(conf *configuration.Configuration) KeyStore {
+	var passphrase []byte
+	if len(conf.DevicePrivateKeyPassphrase) > 0 {
+		passphrase = []byte(conf.DevicePrivateKeyPassphrase)
+	}
+
+	if len(conf.DevicePrivateKeyEnv) > 0 {
+		return NewEnvKeyStore(conf.DevicePrivateKeyEnv, passphrase)
+	}
+
+	return NewFileKeyStore(conf.DevicePrivateKeyPath, passphrase)
+}
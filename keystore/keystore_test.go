@@ -0,0 +1,94 @@
+package keystore_test
+
+import (
+	"crypto"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/keygen"
+	"github.com/pjgg/iotPlayground/keystore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type KeyStoreTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *KeyStoreTestSuite) TestFileKeyStoreCacheAndReload() {
+	path := filepath.Join(suite.dir, "ec_private.pem")
+	key, _ := keygen.GenerateP256()
+	pemBytes, _ := keygen.PrivateKeyPEM(key, keygen.PKCS1)
+	keygen.WritePrivateKey(path, pemBytes)
+
+	store := keystore.NewFileKeyStore(path, nil)
+	first, err := store.Signer()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	second, _ := store.Signer()
+	assert.True(suite.T(), first == second)
+
+	newKey, _ := keygen.GenerateRSA(0)
+	pemBytes, _ = keygen.PrivateKeyPEM(newKey, keygen.PKCS8)
+	keygen.WritePrivateKey(path, pemBytes)
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	reloaded, err := store.Signer()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), newKey.Public(), reloaded.Public())
+}
+
+func (suite *KeyStoreTestSuite) TestEnvKeyStoreBase64() {
+	key, _ := keygen.GenerateP256()
+	pemBytes, _ := keygen.PrivateKeyPEM(key, keygen.PKCS8)
+	os.Setenv("TEST_DEVICE_PRIVATE_KEY", base64.StdEncoding.EncodeToString(pemBytes))
+	defer os.Unsetenv("TEST_DEVICE_PRIVATE_KEY")
+
+	signer, err := keystore.NewEnvKeyStore("TEST_DEVICE_PRIVATE_KEY", nil).Signer()
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.EqualValues(suite.T(), key.Public(), signer.Public())
+
+	_, err = keystore.NewEnvKeyStore("TEST_MISSING_PRIVATE_KEY", nil).Signer()
+	assert.Error(suite.T(), err)
+}
+
+func (suite *KeyStoreTestSuite) TestMemoryAndSignerKeyStore() {
+	key, _ := keygen.GenerateP256()
+	pemBytes, _ := keygen.PrivateKeyPEM(key, keygen.PKCS1)
+
+	memoryStore, err := keystore.NewMemoryKeyStore(pemBytes, nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	signer, _ := memoryStore.Signer()
+	assert.EqualValues(suite.T(), key.Public(), signer.Public())
+
+	signer, _ = keystore.NewSignerKeyStore(key).Signer()
+	assert.True(suite.T(), signer == key)
+}
+
+func (suite *KeyStoreTestSuite) TestRepositoryKeys() {
+	for _, path := range []string{"../rsa_private.pem", "../ec_private.pem"} {
+		_, err := keystore.NewFileKeyStore(path, nil).Signer()
+		assert.NoError(suite.T(), err, path)
+	}
+}
+
+func (suite *KeyStoreTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "keystore")
+}
+
+func (suite *KeyStoreTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func publicKeyPEM(signer crypto.Signer) []byte {
+	pemBytes, _ := keygen.PublicKeyPEM(signer.Public())
+	return pemBytes
+}
+
+func TestKeyStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(KeyStoreTestSuite))
+}
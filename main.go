@@ -1,3 +1,11 @@
 package main
 
-func main(){}
\ No newline at end of file
+import (
+	"os"
+
+	"github.com/pjgg/iotPlayground/cli"
+)
+
+func main() {
+	os.Exit(cli.NewApp().Run(os.Args[1:]))
+}
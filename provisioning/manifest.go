@@ -0,0 +1,201 @@
+package provisioning
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pjgg/iotPlayground/connectors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// metadataColumn prefix the CSV columns holding metadata, e.g. metadata.site.
+const metadataColumn = "metadata."
+
+// Entry is one device of a manifest.
+type Entry struct {
+	DeviceID string `yaml:"id"`
+	// PublicKeyPath registers an existing public key or certificate, in the KeyType format.
+	PublicKeyPath string `yaml:"publicKeyPath"`
+	// KeyType is RSA_PEM, ES256_PEM, RSA_X509_PEM or ES256_X509_PEM, ES256_PEM by default.
+	KeyType string `yaml:"keyType"`
+	// GenerateKey create an ES256_PEM key pair in the key directory instead of reading PublicKeyPath.
+	GenerateKey bool              `yaml:"generateKey"`
+	Metadata    map[string]string `yaml:"metadata"`
+	// Gateway create the device as a gateway, devices bound to it are authorized by association only.
+	Gateway bool `yaml:"gateway"`
+	Blocked bool `yaml:"blocked"`
+}
+
+// Manifest is a batch of devices to provision, loaded from YAML:
+//
+//	devices:
+//	  - id: sensor-001
+//	    generateKey: true
+//	    metadata:
+//	      site: lab
+//	  - id: sensor-002
+//	    publicKeyPath: keys/sensor-002_cert.pem
+//	    keyType: ES256_X509_PEM
+//	    blocked: true
+//	  - id: gateway-001
+//	    generateKey: true
+//	    gateway: true
+//
+// or from CSV with a header, metadata columns are prefixed by metadata.:
+//
+//	id,publicKeyPath,keyType,generateKey,gateway,blocked,metadata.site
+//	sensor-001,,,true,,,lab
+type Manifest struct {
+	Devices []Entry `yaml:"devices"`
+}
+
+// LoadManifest read a CSV manifest when path ends with .csv and a YAML one otherwise, relative key paths
+// are resolved from the manifest directory.
+func LoadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest *Manifest
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		manifest, err = ReadCSV(file)
+	} else {
+		manifest, err = ReadYAML(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %s", path, err.Error())
+	}
+
+	for i := range manifest.Devices {
+		if keyPath := manifest.Devices[i].PublicKeyPath; len(keyPath) > 0 && !filepath.IsAbs(keyPath) {
+			manifest.Devices[i].PublicKeyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+	}
+	if err = manifest.Validate(); err != nil {
+		return nil, fmt.Errorf("manifest %s: %s", path, err.Error())
+	}
+	return manifest, nil
+}
+
+// ReadYAML read a YAML manifest, unknown keys are errors.
+func ReadYAML(reader io.Reader) (*Manifest, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err = yaml.UnmarshalStrict(data, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ReadCSV read a CSV manifest, the header names the columns and unknown columns are errors.
+func ReadCSV(reader io.Reader) (*Manifest, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing CSV header: %s", err.Error())
+	}
+	for _, column := range header {
+		switch column {
+		case "id", "publicKeyPath", "keyType", "generateKey", "gateway", "blocked":
+		default:
+			if !strings.HasPrefix(column, metadataColumn) || len(column) == len(metadataColumn) {
+				return nil, fmt.Errorf("unknown CSV column %q", column)
+			}
+		}
+	}
+
+	manifest := &Manifest{}
+	for line := 2; ; line++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := Entry{}
+		for i, column := range header {
+			value := strings.TrimSpace(record[i])
+			var flag *bool
+			switch column {
+			case "id":
+				entry.DeviceID = value
+			case "publicKeyPath":
+				entry.PublicKeyPath = value
+			case "keyType":
+				entry.KeyType = value
+			case "generateKey":
+				flag = &entry.GenerateKey
+			case "gateway":
+				flag = &entry.Gateway
+			case "blocked":
+				flag = &entry.Blocked
+			default:
+				if len(value) > 0 {
+					if entry.Metadata == nil {
+						entry.Metadata = map[string]string{}
+					}
+					entry.Metadata[strings.TrimPrefix(column, metadataColumn)] = value
+				}
+			}
+			if flag != nil && len(value) > 0 {
+				if *flag, err = strconv.ParseBool(value); err != nil {
+					return nil, fmt.Errorf("line %d: %s must be true or false, got %q", line, column, value)
+				}
+			}
+		}
+		manifest.Devices = append(manifest.Devices, entry)
+	}
+}
+
+// Validate check every entry can be provisioned and fill the default key types, nothing is created when one
+// entry is wrong.
+func (manifest *Manifest) Validate() error {
+	if len(manifest.Devices) == 0 {
+		return fmt.Errorf("no devices")
+	}
+
+	seen := map[string]bool{}
+	for i := range manifest.Devices {
+		entry := &manifest.Devices[i]
+		if len(entry.DeviceID) == 0 {
+			return fmt.Errorf("device %d: id is required", i+1)
+		}
+		if seen[entry.DeviceID] {
+			return fmt.Errorf("device %s: duplicated id", entry.DeviceID)
+		}
+		seen[entry.DeviceID] = true
+
+		if len(entry.KeyType) == 0 {
+			entry.KeyType = connectors.Es256Pem.String()
+		}
+		keyType, err := connectors.ParseKeyType(entry.KeyType)
+		if err != nil {
+			return fmt.Errorf("device %s: %s", entry.DeviceID, err.Error())
+		}
+		entry.KeyType = keyType.String()
+
+		switch {
+		case entry.GenerateKey && len(entry.PublicKeyPath) > 0:
+			return fmt.Errorf("device %s: generateKey and publicKeyPath are exclusive", entry.DeviceID)
+		case !entry.GenerateKey && len(entry.PublicKeyPath) == 0:
+			return fmt.Errorf("device %s: publicKeyPath or generateKey is required", entry.DeviceID)
+		case entry.GenerateKey && keyType != connectors.Es256Pem:
+			return fmt.Errorf("device %s: generated keys are ES256_PEM, got %s", entry.DeviceID, entry.KeyType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/keygen"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Creator is the part of the device admin connector the provisioner needs, HTTPIotDeviceConnectorInterface
+// implements it.
+type Creator interface {
+	CreateDeviceFrom(deviceDef *cloudiot.Device) (*cloudiot.Device, error)
+}
+
+// Options tune a provisioning run.
+type Options struct {
+	// Concurrency is the number of devices created at once, one when not positive.
+	Concurrency int
+	// Rate is the number of devices created per second, unlimited when not positive.
+	Rate float64
+	// KeyDir holds the generated key pairs, <deviceID>_private.pem and <deviceID>_public.pem.
+	KeyDir string
+	// ResultsPath is the JSONL results file, devices already created there are skipped.
+	ResultsPath string
+}
+
+// Report count the devices of a run.
+type Report struct {
+	Created  int           `json:"created"`
+	Existing int           `json:"existing"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// Provision create the manifest devices and append one result per device to the results file. Devices
+// already created by a previous run with the same results file are skipped, so a failed or stopped run is
+// resumed by running it again. Closing stop lets the devices being created finish and leaves the others for
+// the next run.
+func Provision(creator Creator, manifest *Manifest, options Options, stop <-chan struct{}) (Report, error) {
+	report := Report{}
+	started := time.Now()
+
+	previous, err := ReadResults(options.ResultsPath)
+	if err != nil {
+		return report, err
+	}
+	if err = os.MkdirAll(filepath.Dir(options.ResultsPath), 0755); err != nil {
+		return report, err
+	}
+	resultsFile, err := os.OpenFile(options.ResultsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return report, err
+	}
+	defer resultsFile.Close()
+
+	var mutex sync.Mutex
+	encoder := json.NewEncoder(resultsFile)
+	record := func(result Result) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch result.Status {
+		case Created:
+			report.Created++
+		case Existing:
+			report.Existing++
+		default:
+			report.Failed++
+		}
+		if err := encoder.Encode(result); err != nil {
+			log.Errorln("results " + options.ResultsPath + ": " + err.Error())
+		}
+	}
+
+	entries := make(chan Entry)
+	go func() {
+		defer close(entries)
+		var pace <-chan time.Time
+		if options.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / options.Rate))
+			defer ticker.Stop()
+			pace = ticker.C
+		}
+		first := true
+		for _, entry := range manifest.Devices {
+			if result, exist := previous[entry.DeviceID]; exist && result.Status.Done() {
+				mutex.Lock()
+				report.Skipped++
+				mutex.Unlock()
+				continue
+			}
+			if pace != nil && !first {
+				select {
+				case <-pace:
+				case <-stop:
+					return
+				}
+			}
+			first = false
+			select {
+			case entries <- entry:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var wait sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for entry := range entries {
+				record(provision(creator, entry, options.KeyDir))
+			}
+		}()
+	}
+	wait.Wait()
+
+	report.Elapsed = time.Since(started)
+	return report, resultsFile.Sync()
+}
+
+// provision create one device, a device already in the registry counts as provisioned.
+func provision(creator Creator, entry Entry, keyDir string) Result {
+	result := Result{DeviceID: entry.DeviceID, Status: Failed}
+
+	deviceDef, privatePath, err := definition(entry, keyDir)
+	if err == nil {
+		result.PrivateKeyPath = privatePath
+		var device *cloudiot.Device
+		if device, err = creator.CreateDeviceFrom(deviceDef); err == nil {
+			result.Status, result.NumID = Created, device.NumId
+		} else if apiErr, isAPIError := err.(*googleapi.Error); isAPIError && apiErr.Code == http.StatusConflict {
+			result.Status, err = Existing, nil
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	result.Time = time.Now().UTC()
+	return result
+}
+
+// definition build the device resource of an entry, generating its key pair when asked. Generated keys are
+// reused when they exist, a resumed run registers the key written by the failed one.
+func definition(entry Entry, keyDir string) (deviceDef *cloudiot.Device, privatePath string, err error) {
+	var publicPEM []byte
+	if entry.GenerateKey {
+		privatePath = filepath.Join(keyDir, entry.DeviceID+"_private.pem")
+		if publicPEM, err = generatedKey(privatePath, filepath.Join(keyDir, entry.DeviceID+"_public.pem")); err != nil {
+			return
+		}
+	} else if publicPEM, err = ioutil.ReadFile(entry.PublicKeyPath); err != nil {
+		return
+	}
+
+	deviceDef = &cloudiot.Device{
+		Id:       entry.DeviceID,
+		Blocked:  entry.Blocked,
+		Metadata: entry.Metadata,
+		Credentials: []*cloudiot.DeviceCredential{
+			{
+				PublicKey: &cloudiot.PublicKeyCredential{
+					Format: entry.KeyType,
+					Key:    string(publicPEM),
+				},
+			},
+		},
+	}
+	if entry.Gateway {
+		deviceDef.GatewayConfig = &cloudiot.GatewayConfig{GatewayType: "GATEWAY", GatewayAuthMethod: "ASSOCIATION_ONLY"}
+	}
+	return
+}
+
+func generatedKey(privatePath, publicPath string) ([]byte, error) {
+	if _, err := os.Stat(privatePath); err == nil {
+		return ioutil.ReadFile(publicPath)
+	}
+
+	signer, err := keygen.GenerateP256()
+	if err != nil {
+		return nil, err
+	}
+	privatePEM, err := keygen.PrivateKeyPEM(signer, keygen.PKCS8)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := keygen.PublicKeyPEM(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	// the public key goes first, a private key without it would be reused with no key to register
+	if err = keygen.WritePublic(publicPath, publicPEM); err != nil {
+		return nil, err
+	}
+	if err = keygen.WritePrivateKey(privatePath, privatePEM); err != nil {
+		return nil, err
+	}
+	return publicPEM, nil
+}
@@ -0,0 +1,180 @@
+package provisioning_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/provisioning"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+	"google.golang.org/api/googleapi"
+)
+
+type ProvisioningTestSuite struct {
+	suite.Suite
+	dir     string
+	creator *fakeCreator
+}
+
+type fakeCreator struct {
+	mutex   sync.Mutex
+	created map[string]*cloudiot.Device
+	failing map[string]bool
+	calls   []time.Time
+}
+
+func (fake *fakeCreator) CreateDeviceFrom(deviceDef *cloudiot.Device) (*cloudiot.Device, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.calls = append(fake.calls, time.Now())
+	switch {
+	case fake.failing[deviceDef.Id]:
+		return nil, errors.New("quota exceeded")
+	case fake.created[deviceDef.Id] != nil:
+		return nil, &googleapi.Error{Code: 409, Message: "device already exists"}
+	}
+	deviceDef.NumId = uint64(len(fake.created) + 1)
+	fake.created[deviceDef.Id] = deviceDef
+	return deviceDef, nil
+}
+
+func (suite *ProvisioningTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "provisioning")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	suite.dir = dir
+	suite.creator = &fakeCreator{created: map[string]*cloudiot.Device{}, failing: map[string]bool{}}
+}
+
+func (suite *ProvisioningTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *ProvisioningTestSuite) write(name, content string) string {
+	path := filepath.Join(suite.dir, name)
+	assert.NoError(suite.T(), ioutil.WriteFile(path, []byte(content), 0644), "UnexpectedError")
+	return path
+}
+
+func (suite *ProvisioningTestSuite) options() provisioning.Options {
+	return provisioning.Options{
+		Concurrency: 3,
+		KeyDir:      filepath.Join(suite.dir, "keys"),
+		ResultsPath: filepath.Join(suite.dir, "results.jsonl"),
+	}
+}
+
+func (suite *ProvisioningTestSuite) TestLoadCSVManifest() {
+	suite.write("sensor-2.pem", "public key")
+	path := suite.write("manifest.csv", "id,publicKeyPath,keyType,generateKey,blocked,metadata.site,metadata.floor\n"+
+		"sensor-1,,,true,,lab,1\n"+
+		"sensor-2,sensor-2.pem,rsa_pem,,true,,\n")
+
+	manifest, err := provisioning.LoadManifest(path)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), []provisioning.Entry{
+		{DeviceID: "sensor-1", KeyType: "ES256_PEM", GenerateKey: true, Metadata: map[string]string{"site": "lab", "floor": "1"}},
+		{DeviceID: "sensor-2", PublicKeyPath: filepath.Join(suite.dir, "sensor-2.pem"), KeyType: "RSA_PEM", Blocked: true},
+	}, manifest.Devices)
+}
+
+func (suite *ProvisioningTestSuite) TestRejectedManifests() {
+	for name, content := range map[string]string{
+		"unknown column": "id,color\nsensor-1,red\n",
+		"wrong bool":     "id,generateKey\nsensor-1,maybe\n",
+		"duplicated id":  "id,generateKey\nsensor-1,true\nsensor-1,true\n",
+		"no key":         "id,blocked\nsensor-1,true\n",
+	} {
+		_, err := provisioning.LoadManifest(suite.write("manifest.csv", content))
+		assert.Error(suite.T(), err, name)
+	}
+
+	_, err := provisioning.LoadManifest(suite.write("manifest.yaml", "devices:\n  - id: sensor-1\n    generateKey: true\n    keyType: RSA_PEM\n"))
+	assert.Error(suite.T(), err)
+}
+
+func (suite *ProvisioningTestSuite) TestProvision() {
+	manifest, err := provisioning.ReadYAML(bytes.NewBufferString("devices:\n" +
+		"  - id: sensor-1\n    generateKey: true\n    metadata:\n      site: lab\n" +
+		"  - id: sensor-2\n    generateKey: true\n    blocked: true\n" +
+		"  - id: sensor-3\n    generateKey: true\n" +
+		"  - id: gateway-1\n    generateKey: true\n    gateway: true\n"))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.NoError(suite.T(), manifest.Validate(), "UnexpectedError")
+
+	report, err := provisioning.Provision(suite.creator, manifest, suite.options(), nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), 4, report.Created)
+	assert.Equal(suite.T(), map[string]string{"site": "lab"}, suite.creator.created["sensor-1"].Metadata)
+	assert.True(suite.T(), suite.creator.created["sensor-2"].Blocked)
+	assert.Equal(suite.T(), "ES256_PEM", suite.creator.created["sensor-3"].Credentials[0].PublicKey.Format)
+	assert.Contains(suite.T(), suite.creator.created["sensor-3"].Credentials[0].PublicKey.Key, "BEGIN PUBLIC KEY")
+	assert.Nil(suite.T(), suite.creator.created["sensor-3"].GatewayConfig)
+	assert.Equal(suite.T(), "GATEWAY", suite.creator.created["gateway-1"].GatewayConfig.GatewayType)
+
+	results, err := provisioning.ReadResults(suite.options().ResultsPath)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), results, 4)
+	assert.Equal(suite.T(), provisioning.Created, results["sensor-1"].Status)
+	assert.FileExists(suite.T(), results["sensor-1"].PrivateKeyPath)
+}
+
+func (suite *ProvisioningTestSuite) TestResumeAfterPartialFailure() {
+	manifest := &provisioning.Manifest{}
+	for _, id := range []string{"sensor-1", "sensor-2", "sensor-3"} {
+		manifest.Devices = append(manifest.Devices, provisioning.Entry{DeviceID: id, GenerateKey: true})
+	}
+	assert.NoError(suite.T(), manifest.Validate(), "UnexpectedError")
+	suite.creator.failing["sensor-2"] = true
+
+	report, err := provisioning.Provision(suite.creator, manifest, suite.options(), nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), provisioning.Report{Created: 2, Failed: 1, Elapsed: report.Elapsed}, report)
+	results, err := provisioning.ReadResults(suite.options().ResultsPath)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), "quota exceeded", results["sensor-2"].Error)
+	firstKey := suite.keyOf("sensor-2")
+
+	// the device is created but its result is lost, the resumed run must not fail on it
+	delete(suite.creator.failing, "sensor-2")
+	suite.creator.created["sensor-2"] = &cloudiot.Device{Id: "sensor-2"}
+	report, err = provisioning.Provision(suite.creator, manifest, suite.options(), nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), provisioning.Report{Existing: 1, Skipped: 2, Elapsed: report.Elapsed}, report)
+	assert.Equal(suite.T(), firstKey, suite.keyOf("sensor-2"))
+
+	results, err = provisioning.ReadResults(suite.options().ResultsPath)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), provisioning.Existing, results["sensor-2"].Status)
+}
+
+func (suite *ProvisioningTestSuite) TestRateLimit() {
+	manifest := &provisioning.Manifest{}
+	for _, id := range []string{"sensor-1", "sensor-2", "sensor-3", "sensor-4"} {
+		manifest.Devices = append(manifest.Devices, provisioning.Entry{DeviceID: id, GenerateKey: true})
+	}
+	assert.NoError(suite.T(), manifest.Validate(), "UnexpectedError")
+	options := suite.options()
+	options.Rate = 20
+
+	_, err := provisioning.Provision(suite.creator, manifest, options, nil)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), suite.creator.calls, 4)
+	assert.True(suite.T(), suite.creator.calls[3].Sub(suite.creator.calls[0]) >= 140*time.Millisecond)
+}
+
+func (suite *ProvisioningTestSuite) keyOf(deviceID string) string {
+	key, err := ioutil.ReadFile(filepath.Join(suite.options().KeyDir, deviceID+"_public.pem"))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	return string(key)
+}
+
+func TestProvisioningTestSuite(t *testing.T) {
+	suite.Run(t, new(ProvisioningTestSuite))
+}
@@ -0,0 +1,93 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Status of a provisioned device.
+type Status int
+
+const (
+	// Created devices were created by this run.
+	Created Status = 1 + iota
+	// Existing devices were already in the registry, e.g. created by a run whose result was lost.
+	Existing
+	// Failed devices were not created, a resumed run tries them again.
+	Failed
+)
+
+var statusName = [...]string{
+	"created",
+	"existing",
+	"failed",
+}
+
+func (status Status) String() string {
+	return statusName[status-1]
+}
+
+// MarshalJSON write the status name.
+func (status Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(status.String())
+}
+
+// UnmarshalJSON read a status name.
+func (status *Status) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for i, statusName := range statusName {
+		if statusName == name {
+			*status = Status(i + 1)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown provisioning status %q", name)
+}
+
+// Done tells if a resumed run can skip the device.
+func (status Status) Done() bool {
+	return status == Created || status == Existing
+}
+
+// Result is one line of the results file.
+type Result struct {
+	DeviceID string    `json:"deviceId"`
+	Status   Status    `json:"status"`
+	Time     time.Time `json:"time"`
+	NumID    uint64    `json:"numId,omitempty"`
+	// PrivateKeyPath is the generated private key of the device.
+	PrivateKeyPath string `json:"privateKeyPath,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ReadResults returns the latest result of every device in a results file, none when the file does not
+// exist yet.
+func ReadResults(path string) (map[string]Result, error) {
+	results := map[string]Result{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var result Result
+		if err = decoder.Decode(&result); err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("results %s: %s", path, err.Error())
+		}
+		results[result.DeviceID] = result
+	}
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+)
+
+// Recorder is an MQTTIotDeviceConnectorInterface that write every message going through a connector to a
+// session file. Failed publishes are not recorded.
+type Recorder struct {
+	connector device.MQTTIotDeviceConnectorInterface
+	mutex     sync.Mutex
+	encoder   *json.Encoder
+	err       error
+}
+
+// NewRecorder record the session of connector to writer, one JSON record per line.
+func NewRecorder(connector device.MQTTIotDeviceConnectorInterface, writer io.Writer) *Recorder {
+	return &Recorder{connector: connector, encoder: json.NewEncoder(writer)}
+}
+
+// Err returns the first write error, recording stops after it.
+func (recorder *Recorder) Err() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.err
+}
+
+func (recorder *Recorder) record(record *Record) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.err != nil {
+		return
+	}
+	record.Time = time.Now().UTC()
+	if recorder.err = recorder.encoder.Encode(record); recorder.err != nil {
+		log.Errorln("session recording stopped: " + recorder.err.Error())
+	}
+}
+
+func (recorder *Recorder) recordToken(token mqtt.Token, record *Record) {
+	if token.Wait() && token.Error() == nil {
+		recorder.record(record)
+	}
+}
+
+func outgoing(toDeviceID, topicName, msg string, delivery connectors.QoS) *Record {
+	kind, subfolder := kindOf(topicName)
+	return &Record{Direction: Outgoing, Kind: kind, Device: toDeviceID, Topic: topicName, Subfolder: subfolder, QoS: delivery.Value(), Payload: []byte(msg)}
+}
+
+// PublishMsg publish and record a message.
+func (recorder *Recorder) PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) mqtt.Token {
+	token := recorder.connector.PublishMsg(toDeviceID, topicName, msg, delivery)
+	recorder.recordToken(token, outgoing(toDeviceID, topicName, msg, delivery))
+	return token
+}
+
+// PublishTelemetry publish and record a telemetry event, the recorded payload is the stamped envelope.
+func (recorder *Recorder) PublishTelemetry(toDeviceID string, message *telemetry.Telemetry, delivery connectors.QoS) (mqtt.Token, error) {
+	token, err := recorder.connector.PublishTelemetry(toDeviceID, message, delivery)
+	if err != nil {
+		return token, err
+	}
+
+	envelope, err := message.Encode()
+	if err != nil {
+		return token, err
+	}
+	recorder.recordToken(token, outgoing(toDeviceID, message.Topic(), string(envelope), delivery))
+	return token, nil
+}
+
+// PublishState publish and record a device state.
+func (recorder *Recorder) PublishState(toDeviceID, state string, delivery connectors.QoS) mqtt.Token {
+	token := recorder.connector.PublishState(toDeviceID, state, delivery)
+	recorder.recordToken(token, outgoing(toDeviceID, "state", state, delivery))
+	return token
+}
+
+// SubscribeConfig record every config before handing it to handler.
+func (recorder *Recorder) SubscribeConfig(toDeviceID string, handler func(config []byte)) mqtt.Token {
+	return recorder.connector.SubscribeConfig(toDeviceID, func(config []byte) {
+		recorder.record(&Record{Direction: Incoming, Kind: Config, Device: toDeviceID, Topic: "config", QoS: connectors.AtLeastOnce.Value(), Payload: config})
+		handler(config)
+	})
+}
+
+// SubscribeCommands record every command before handing it to handler.
+func (recorder *Recorder) SubscribeCommands(toDeviceID string, handler func(subfolder string, command []byte)) mqtt.Token {
+	return recorder.connector.SubscribeCommands(toDeviceID, func(subfolder string, command []byte) {
+		topic := strings.TrimSuffix("commands/"+subfolder, "/")
+		recorder.record(&Record{Direction: Incoming, Kind: Command, Device: toDeviceID, Topic: topic, Subfolder: subfolder, QoS: connectors.AtMostOnce.Value(), Payload: command})
+		handler(subfolder, command)
+	})
+}
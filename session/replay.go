@@ -0,0 +1,125 @@
+package session
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pjgg/iotPlayground/connectors"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+// Target receive the replayed outgoing records, an MQTTIotDeviceConnectorInterface is a Target.
+type Target interface {
+	PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) mqtt.Token
+}
+
+// ConfigTarget push the replayed incoming configs, an HTTPIotDeviceConnectorInterface is a ConfigTarget.
+type ConfigTarget interface {
+	SetDeviceConfig(deviceID string, configData string) (*cloudiot.DeviceConfig, error)
+}
+
+// ReplayOptions tune a replay.
+type ReplayOptions struct {
+	// Speed scale the recorded delays, 1 replay in real time, 10 ten times faster, 0 without any delay.
+	Speed float64
+	// Device replay every record as this device instead of the recorded one.
+	Device string
+	// Configs push the recorded configs again through the admin API, configs are skipped when nil.
+	// Commands are always skipped.
+	Configs ConfigTarget
+	// Stop interrupt the replay.
+	Stop <-chan struct{}
+}
+
+// ReplayReport count what a replay did.
+type ReplayReport struct {
+	Published int           `json:"published"`
+	Configs   int           `json:"configs"`
+	Skipped   int           `json:"skipped"`
+	Elapsed   time.Duration `json:"elapsed"`
+}
+
+// Replay publish the outgoing records of a session in order, outgoing payloads are sent as recorded so
+// telemetry envelopes keep their session and sequence.
+func Replay(reader *Reader, target Target, options ReplayOptions) (report ReplayReport, err error) {
+	if options.Speed < 0 {
+		return report, fmt.Errorf("replay speed can not be negative, got %g", options.Speed)
+	}
+
+	started := time.Now()
+	defer func() { report.Elapsed = time.Since(started) }()
+
+	var first time.Time
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if first.IsZero() {
+			first = record.Time
+		}
+		if !wait(started, record.Time.Sub(first), options) {
+			return report, nil
+		}
+
+		deviceID := record.Device
+		if len(options.Device) > 0 {
+			deviceID = options.Device
+		}
+
+		switch {
+		case record.Direction == Outgoing:
+			token := target.PublishMsg(deviceID, record.Topic, string(record.Payload), qosOf(record.QoS))
+			if token.Wait(); token.Error() != nil {
+				return report, fmt.Errorf("replay %s at %s: %s", record.Topic, record.Time.Format(time.RFC3339Nano), token.Error().Error())
+			}
+			report.Published++
+		case record.Kind == Config && options.Configs != nil:
+			if _, err = options.Configs.SetDeviceConfig(deviceID, string(record.Payload)); err != nil {
+				return report, fmt.Errorf("replay config at %s: %s", record.Time.Format(time.RFC3339Nano), err.Error())
+			}
+			report.Configs++
+		default:
+			report.Skipped++
+		}
+	}
+}
+
+// wait until the record offset scaled by the speed, false when stopped.
+func wait(started time.Time, offset time.Duration, options ReplayOptions) bool {
+	var delay time.Duration
+	if options.Speed > 0 {
+		delay = time.Duration(float64(offset)/options.Speed) - time.Since(started)
+	}
+
+	if delay <= 0 {
+		select {
+		case <-options.Stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-options.Stop:
+		return false
+	}
+}
+
+func qosOf(value byte) connectors.QoS {
+	if value == 0 {
+		return connectors.AtMostOnce
+	}
+	return connectors.AtLeastOnce
+}
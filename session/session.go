@@ -0,0 +1,136 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+)
+
+// Direction tells if a record was sent or received by the device.
+type Direction int
+
+const (
+	// Outgoing records are published by the device.
+	Outgoing Direction = 1 + iota
+	// Incoming records are delivered to the device.
+	Incoming
+)
+
+var directionName = [...]string{
+	"out",
+	"in",
+}
+
+func (direction Direction) String() string {
+	return directionName[direction-1]
+}
+
+// MarshalJSON write the direction name.
+func (direction Direction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(direction.String())
+}
+
+// UnmarshalJSON read a direction name.
+func (direction *Direction) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for i, directionName := range directionName {
+		if directionName == name {
+			*direction = Direction(i + 1)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown session direction %q", name)
+}
+
+// Kinds of records.
+const (
+	Telemetry = "telemetry"
+	State     = "state"
+	Config    = "config"
+	Command   = "command"
+)
+
+// Record is one message of a device session, a line of the session JSONL file.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	Kind      string    `json:"kind"`
+	Device    string    `json:"device"`
+	// Topic is the topic suffix after /devices/<device>/, e.g. events/alerts or state.
+	Topic     string `json:"topic"`
+	Subfolder string `json:"subfolder,omitempty"`
+	QoS       byte   `json:"qos"`
+	// Payload is the message as sent on the wire, base64 encoded in the file.
+	Payload []byte `json:"payload"`
+}
+
+// kindOf returns the record kind of an outgoing topic suffix.
+func kindOf(topic string) (kind, subfolder string) {
+	switch {
+	case topic == "state":
+		return State, ""
+	case topic == telemetry.EventsTopic:
+		return Telemetry, ""
+	case strings.HasPrefix(topic, telemetry.EventsTopic+"/"):
+		return Telemetry, strings.TrimPrefix(topic, telemetry.EventsTopic+"/")
+	}
+	return topic, ""
+}
+
+// Reader read the records of a session file one by one.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader read a session JSONL stream, empty lines are skipped.
+func NewReader(reader io.Reader) *Reader {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next record, io.EOF at the end of the session.
+func (reader *Reader) Next() (*Record, error) {
+	for reader.scanner.Scan() {
+		reader.line++
+		line := reader.scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		record := &Record{}
+		if err := json.Unmarshal(line, record); err != nil {
+			return nil, fmt.Errorf("session line %d: %s", reader.line, err.Error())
+		}
+		return record, nil
+	}
+
+	if err := reader.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// ReadAll returns every record of a session JSONL stream.
+func ReadAll(reader io.Reader) (records []*Record, err error) {
+	sessionReader := NewReader(reader)
+	for {
+		record, err := sessionReader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
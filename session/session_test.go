@@ -0,0 +1,168 @@
+package session_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pjgg/iotPlayground/connectors"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/pjgg/iotPlayground/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+}
+
+type token struct {
+	mqtt.Token
+	err error
+}
+
+func (token token) Wait() bool {
+	return true
+}
+
+func (token token) Error() error {
+	return token.err
+}
+
+type published struct {
+	device, topic, payload string
+	qos                    connectors.QoS
+	at                     time.Time
+}
+
+type fakeConnector struct {
+	device.MQTTIotDeviceConnectorInterface
+	published []published
+	failing   bool
+	config    func(config []byte)
+	command   func(subfolder string, command []byte)
+}
+
+func (fake *fakeConnector) PublishMsg(toDeviceID, topicName, msg string, delivery connectors.QoS) mqtt.Token {
+	if fake.failing {
+		return token{err: errors.New("not connected")}
+	}
+	fake.published = append(fake.published, published{toDeviceID, topicName, msg, delivery, time.Now()})
+	return token{}
+}
+
+func (fake *fakeConnector) PublishTelemetry(toDeviceID string, message *telemetry.Telemetry, delivery connectors.QoS) (mqtt.Token, error) {
+	message.Session, message.Sequence = "session", 1
+	envelope, err := message.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return fake.PublishMsg(toDeviceID, message.Topic(), string(envelope), delivery), nil
+}
+
+func (fake *fakeConnector) PublishState(toDeviceID, state string, delivery connectors.QoS) mqtt.Token {
+	return fake.PublishMsg(toDeviceID, "state", state, delivery)
+}
+
+func (fake *fakeConnector) SubscribeConfig(toDeviceID string, handler func(config []byte)) mqtt.Token {
+	fake.config = handler
+	return token{}
+}
+
+func (fake *fakeConnector) SubscribeCommands(toDeviceID string, handler func(subfolder string, command []byte)) mqtt.Token {
+	fake.command = handler
+	return token{}
+}
+
+type fakeConfigs struct {
+	configs []string
+}
+
+func (fake *fakeConfigs) SetDeviceConfig(deviceID string, configData string) (*cloudiot.DeviceConfig, error) {
+	fake.configs = append(fake.configs, deviceID+":"+configData)
+	return &cloudiot.DeviceConfig{}, nil
+}
+
+func (suite *SessionTestSuite) record() *bytes.Buffer {
+	file := &bytes.Buffer{}
+	connector := &fakeConnector{}
+	recorder := session.NewRecorder(connector, file)
+
+	var received []string
+	recorder.SubscribeConfig("device-1", func(config []byte) { received = append(received, string(config)) })
+	recorder.SubscribeCommands("device-1", func(subfolder string, command []byte) { received = append(received, subfolder) })
+
+	_, err := recorder.PublishTelemetry("device-1", &telemetry.Telemetry{Subfolder: "alerts", Payload: []byte("hot")}, connectors.AtLeastOnce)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	connector.config([]byte("{\"fan\":\"on\"}"))
+	time.Sleep(50 * time.Millisecond)
+	recorder.PublishState("device-1", "{\"fan\":\"on\"}", connectors.AtMostOnce)
+	connector.command("reboot", []byte("now"))
+
+	connector.failing = true
+	recorder.PublishState("device-1", "lost", connectors.AtMostOnce)
+
+	assert.Equal(suite.T(), []string{"{\"fan\":\"on\"}", "reboot"}, received)
+	assert.NoError(suite.T(), recorder.Err(), "UnexpectedError")
+	return file
+}
+
+func (suite *SessionTestSuite) TestRecord() {
+	records, err := session.ReadAll(suite.record())
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), records, 4)
+
+	assert.Equal(suite.T(), session.Outgoing, records[0].Direction)
+	assert.Equal(suite.T(), session.Telemetry, records[0].Kind)
+	assert.Equal(suite.T(), "events/alerts", records[0].Topic)
+	assert.Equal(suite.T(), "alerts", records[0].Subfolder)
+	event, err := telemetry.Decode(records[0].Payload)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), "hot", string(event.Payload))
+
+	assert.Equal(suite.T(), session.Incoming, records[1].Direction)
+	assert.Equal(suite.T(), session.Config, records[1].Kind)
+	assert.Equal(suite.T(), session.State, records[2].Kind)
+	assert.EqualValues(suite.T(), 0, records[2].QoS)
+	assert.Equal(suite.T(), "commands/reboot", records[3].Topic)
+	assert.True(suite.T(), records[2].Time.Sub(records[0].Time) >= 50*time.Millisecond)
+}
+
+func (suite *SessionTestSuite) TestReplay() {
+	target := &fakeConnector{}
+	configs := &fakeConfigs{}
+
+	report, err := session.Replay(session.NewReader(suite.record()), target, session.ReplayOptions{Speed: 1, Device: "device-2", Configs: configs})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), session.ReplayReport{Published: 2, Configs: 1, Skipped: 1, Elapsed: report.Elapsed}, report)
+
+	assert.Equal(suite.T(), "device-2", target.published[0].device)
+	assert.Equal(suite.T(), "events/alerts", target.published[0].topic)
+	assert.Equal(suite.T(), "state", target.published[1].topic)
+	assert.Equal(suite.T(), connectors.AtMostOnce, target.published[1].qos)
+	assert.True(suite.T(), target.published[1].at.Sub(target.published[0].at) >= 40*time.Millisecond)
+	assert.Equal(suite.T(), []string{"device-2:{\"fan\":\"on\"}"}, configs.configs)
+}
+
+func (suite *SessionTestSuite) TestReplayAsFastAsPossible() {
+	target := &fakeConnector{}
+
+	report, err := session.Replay(session.NewReader(suite.record()), target, session.ReplayOptions{})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), 2, report.Published)
+	assert.Equal(suite.T(), 2, report.Skipped)
+	assert.True(suite.T(), report.Elapsed < 40*time.Millisecond)
+}
+
+func (suite *SessionTestSuite) TestReplayInvalidLine() {
+	_, err := session.Replay(session.NewReader(bytes.NewBufferString("{\"direction\":\"sideways\"}\n")), &fakeConnector{}, session.ReplayOptions{})
+	assert.Error(suite.T(), err)
+}
+
+func TestSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
@@ -0,0 +1,212 @@
+package simulator
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generator produce the payloads published by the virtual devices, it is shared by every device.
+type Generator interface {
+	// Next returns the payload device publish at time at.
+	Next(device int, at time.Time) ([]byte, error)
+}
+
+// GeneratorFactory create a Generator for a fleet of devices devices.
+type GeneratorFactory func(spec GeneratorSpec, devices int) (Generator, error)
+
+const (
+	// RandomWalk move every device value by a random step up to step, bounded by min and max when max > min.
+	RandomWalk = "randomWalk"
+	// Sine oscillate around offset, each device with its own phase.
+	Sine = "sine"
+	// Replay publish the records of a CSV or JSONL file in a loop, each device starting at its own record.
+	Replay = "replay"
+)
+
+const defaultField = "value"
+
+var generatorsMutex sync.RWMutex
+var generators = map[string]GeneratorFactory{
+	RandomWalk: newRandomWalk,
+	Sine:       newSine,
+	Replay:     newReplay,
+}
+
+// RegisterGenerator plug a payload generator kind, usable from the scenario generator.kind.
+func RegisterGenerator(kind string, factory GeneratorFactory) {
+	generatorsMutex.Lock()
+	defer generatorsMutex.Unlock()
+	generators[kind] = factory
+}
+
+// NewGenerator create the Generator of spec.Kind.
+func NewGenerator(spec GeneratorSpec, devices int) (Generator, error) {
+	generatorsMutex.RLock()
+	factory, exist := generators[spec.Kind]
+	generatorsMutex.RUnlock()
+	if !exist {
+		return nil, fmt.Errorf("unknown generator %q", spec.Kind)
+	}
+
+	return factory(spec, devices)
+}
+
+func numberPayload(field string, value float64) ([]byte, error) {
+	if len(field) == 0 {
+		field = defaultField
+	}
+	return json.Marshal(map[string]float64{field: value})
+}
+
+type randomWalk struct {
+	mutex  sync.Mutex
+	spec   GeneratorSpec
+	random *rand.Rand
+	values []float64
+}
+
+func newRandomWalk(spec GeneratorSpec, devices int) (Generator, error) {
+	if spec.Step < 0 {
+		return nil, fmt.Errorf("randomWalk step can not be negative, got %g", spec.Step)
+	}
+	if spec.Step == 0 {
+		spec.Step = 1
+	}
+
+	walk := &randomWalk{spec: spec, random: rand.New(rand.NewSource(time.Now().UnixNano())), values: make([]float64, devices)}
+	for i := range walk.values {
+		walk.values[i] = spec.Start
+	}
+	return walk, nil
+}
+
+func (walk *randomWalk) Next(device int, at time.Time) ([]byte, error) {
+	walk.mutex.Lock()
+	value := walk.values[device] + (walk.random.Float64()*2-1)*walk.spec.Step
+	if walk.spec.Max > walk.spec.Min {
+		value = math.Max(walk.spec.Min, math.Min(walk.spec.Max, value))
+	}
+	walk.values[device] = value
+	walk.mutex.Unlock()
+
+	return numberPayload(walk.spec.Field, value)
+}
+
+type sine struct {
+	spec    GeneratorSpec
+	devices int
+}
+
+func newSine(spec GeneratorSpec, devices int) (Generator, error) {
+	if spec.Period <= 0 {
+		return nil, fmt.Errorf("sine period must be positive, got %s", spec.Period)
+	}
+	if spec.Amplitude == 0 {
+		spec.Amplitude = 1
+	}
+	return &sine{spec: spec, devices: devices}, nil
+}
+
+func (wave *sine) Next(device int, at time.Time) ([]byte, error) {
+	phase := 2 * math.Pi * float64(device) / float64(wave.devices)
+	angle := 2*math.Pi*float64(at.UnixNano()%int64(wave.spec.Period))/float64(wave.spec.Period) + phase
+	return numberPayload(wave.spec.Field, wave.spec.Offset+wave.spec.Amplitude*math.Sin(angle))
+}
+
+type replay struct {
+	mutex   sync.Mutex
+	records [][]byte
+	cursors []int
+}
+
+func newReplay(spec GeneratorSpec, devices int) (Generator, error) {
+	file, err := os.Open(spec.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records [][]byte
+	switch strings.ToLower(filepath.Ext(spec.File)) {
+	case ".csv":
+		records, err = csvRecords(file)
+	case ".jsonl", ".ndjson":
+		records, err = jsonlRecords(file)
+	default:
+		return nil, fmt.Errorf("replay file %s must be .csv or .jsonl", spec.File)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("replay file %s: %s", spec.File, err.Error())
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("replay file %s has no record", spec.File)
+	}
+
+	cursors := make([]int, devices)
+	for i := range cursors {
+		cursors[i] = i % len(records)
+	}
+	return &replay{records: records, cursors: cursors}, nil
+}
+
+func (player *replay) Next(device int, at time.Time) ([]byte, error) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	record := player.records[player.cursors[device]]
+	player.cursors[device] = (player.cursors[device] + 1) % len(player.records)
+	return record, nil
+}
+
+// csvRecords convert every row to a JSON object keyed by the header, numeric cells become JSON numbers.
+func csvRecords(reader io.Reader) (records [][]byte, err error) {
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil || len(rows) == 0 {
+		return
+	}
+
+	header := rows[0]
+	for _, row := range rows[1:] {
+		object := map[string]interface{}{}
+		for i, cell := range row {
+			if number, parseErr := strconv.ParseFloat(cell, 64); parseErr == nil {
+				object[header[i]] = number
+			} else {
+				object[header[i]] = cell
+			}
+		}
+		record, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+func jsonlRecords(reader io.Reader) (records [][]byte, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := strings.TrimSpace(scanner.Text())
+		if len(record) == 0 {
+			continue
+		}
+		if !json.Valid([]byte(record)) {
+			return nil, fmt.Errorf("line %d is not valid JSON", line)
+		}
+		records = append(records, []byte(record))
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,86 @@
+package simulator_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type GeneratorTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *GeneratorTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "generator")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	suite.dir = dir
+}
+
+func (suite *GeneratorTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *GeneratorTestSuite) TestRandomWalkBounds() {
+	generator, err := simulator.NewGenerator(simulator.GeneratorSpec{Kind: simulator.RandomWalk, Field: "temperature", Start: 20, Step: 5, Min: 18, Max: 22}, 2)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	for i := 0; i < 100; i++ {
+		payload, err := generator.Next(i%2, time.Now())
+		assert.NoError(suite.T(), err, "UnexpectedError")
+
+		var value map[string]float64
+		assert.NoError(suite.T(), json.Unmarshal(payload, &value), "UnexpectedError")
+		assert.True(suite.T(), value["temperature"] >= 18 && value["temperature"] <= 22)
+	}
+}
+
+func (suite *GeneratorTestSuite) TestSinePhase() {
+	generator, err := simulator.NewGenerator(simulator.GeneratorSpec{Kind: simulator.Sine, Amplitude: 10, Offset: 50, Period: time.Minute}, 4)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	at := time.Unix(0, 0)
+	first, _ := generator.Next(0, at)
+	second, _ := generator.Next(1, at)
+	assert.JSONEq(suite.T(), `{"value":50}`, string(first))
+	assert.JSONEq(suite.T(), `{"value":60}`, string(second))
+}
+
+func (suite *GeneratorTestSuite) TestReplayCSV() {
+	path := filepath.Join(suite.dir, "readings.csv")
+	assert.NoError(suite.T(), ioutil.WriteFile(path, []byte("sensor,value\nA,1.5\nB,2\n"), 0644), "UnexpectedError")
+
+	generator, err := simulator.NewGenerator(simulator.GeneratorSpec{Kind: simulator.Replay, File: path}, 3)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	payload, _ := generator.Next(0, time.Now())
+	assert.JSONEq(suite.T(), `{"sensor":"A","value":1.5}`, string(payload))
+	payload, _ = generator.Next(0, time.Now())
+	assert.JSONEq(suite.T(), `{"sensor":"B","value":2}`, string(payload))
+	payload, _ = generator.Next(1, time.Now())
+	assert.JSONEq(suite.T(), `{"sensor":"B","value":2}`, string(payload))
+}
+
+func (suite *GeneratorTestSuite) TestReplayInvalidJSONL() {
+	path := filepath.Join(suite.dir, "readings.jsonl")
+	assert.NoError(suite.T(), ioutil.WriteFile(path, []byte("{\"value\":1}\nnot json\n"), 0644), "UnexpectedError")
+
+	_, err := simulator.NewGenerator(simulator.GeneratorSpec{Kind: simulator.Replay, File: path}, 1)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *GeneratorTestSuite) TestUnknownGenerator() {
+	_, err := simulator.NewGenerator(simulator.GeneratorSpec{Kind: "square"}, 1)
+	assert.Error(suite.T(), err)
+}
+
+func TestGeneratorTestSuite(t *testing.T) {
+	suite.Run(t, new(GeneratorTestSuite))
+}
@@ -0,0 +1,152 @@
+package simulator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Scenario describe a simulated fleet, usually loaded from a YAML file:
+//
+//	registry: load-test
+//	duration: 10m
+//	devices:
+//	  count: 500
+//	  idPrefix: sim-
+//	  keyDir: ./sim-keys
+//	publish:
+//	  interval: 1s
+//	generator:
+//	  kind: randomWalk
+//	  field: temperature
+//	connect:
+//	  rampUp: 30s
+//	churn:
+//	  interval: 1m
+//	  fraction: 0.05
+//	  downtime: 10s
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Profile is the configuration profile of the devices, the active profile when empty.
+	Profile   string        `yaml:"profile"`
+	Registry  string        `yaml:"registry"`
+	Duration  time.Duration `yaml:"duration"`
+	Devices   DevicesSpec   `yaml:"devices"`
+	Publish   PublishSpec   `yaml:"publish"`
+	Generator GeneratorSpec `yaml:"generator"`
+	Connect   ConnectSpec   `yaml:"connect"`
+	Churn     ChurnSpec     `yaml:"churn"`
+}
+
+// DevicesSpec define the virtual devices, <idPrefix><index> from 0 to count-1.
+type DevicesSpec struct {
+	Count    int    `yaml:"count"`
+	IDPrefix string `yaml:"idPrefix"`
+	// KeyDir keep every device P-256 key pair as <deviceID>_private.pem and <deviceID>_public.pem, generated
+	// when missing. Keys are only kept in memory when empty.
+	KeyDir string `yaml:"keyDir"`
+}
+
+// PublishSpec define what every device publish and how often.
+type PublishSpec struct {
+	Interval time.Duration `yaml:"interval"`
+	// Jitter add a random delay up to this duration to every interval, so devices do not publish in lock step.
+	Jitter    time.Duration `yaml:"jitter"`
+	Subfolder string        `yaml:"subfolder"`
+	// State publish device states instead of telemetry.
+	State bool `yaml:"state"`
+}
+
+// GeneratorSpec select a payload generator by kind, see RegisterGenerator.
+type GeneratorSpec struct {
+	Kind string `yaml:"kind"`
+	// Field is the JSON field holding generated numbers, value when empty.
+	Field string `yaml:"field"`
+	// randomWalk
+	Start float64 `yaml:"start"`
+	Step  float64 `yaml:"step"`
+	Min   float64 `yaml:"min"`
+	Max   float64 `yaml:"max"`
+	// sine
+	Amplitude float64       `yaml:"amplitude"`
+	Offset    float64       `yaml:"offset"`
+	Period    time.Duration `yaml:"period"`
+	// replay, a .csv file with a header line or a .jsonl file
+	File string `yaml:"file"`
+}
+
+// ConnectSpec shape the initial connections. A zero RampUp and Concurrency connect every device at once,
+// a connect storm.
+type ConnectSpec struct {
+	RampUp      time.Duration `yaml:"rampUp"`
+	Concurrency int           `yaml:"concurrency"`
+	// RetryDelay is the wait before a failed connection is retried, 5s when zero.
+	RetryDelay time.Duration `yaml:"retryDelay"`
+}
+
+// ChurnSpec disconnect a random fraction of the devices every interval, they reconnect after downtime.
+type ChurnSpec struct {
+	Interval time.Duration `yaml:"interval"`
+	Fraction float64       `yaml:"fraction"`
+	Downtime time.Duration `yaml:"downtime"`
+}
+
+const defaultIDPrefix = "sim-device-"
+const defaultRetryDelay = 5 * time.Second
+
+// LoadScenario read and validate a YAML scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario := &Scenario{}
+	if err = yaml.UnmarshalStrict(data, scenario); err != nil {
+		return nil, fmt.Errorf("scenario %s: %s", path, err.Error())
+	}
+	if err = scenario.Validate(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %s", path, err.Error())
+	}
+
+	return scenario, nil
+}
+
+// Validate check the scenario can run and fill the defaults.
+func (scenario *Scenario) Validate() error {
+	switch {
+	case len(scenario.Registry) == 0:
+		return fmt.Errorf("registry is required")
+	case scenario.Devices.Count <= 0:
+		return fmt.Errorf("devices.count must be positive, got %d", scenario.Devices.Count)
+	case scenario.Publish.Interval <= 0:
+		return fmt.Errorf("publish.interval must be positive, got %s", scenario.Publish.Interval)
+	case scenario.Publish.Jitter < 0 || scenario.Connect.RampUp < 0 || scenario.Duration < 0:
+		return fmt.Errorf("publish.jitter, connect.rampUp and duration can not be negative")
+	case scenario.Connect.Concurrency < 0:
+		return fmt.Errorf("connect.concurrency can not be negative, got %d", scenario.Connect.Concurrency)
+	case scenario.Churn.Fraction < 0 || scenario.Churn.Fraction > 1:
+		return fmt.Errorf("churn.fraction must be between 0 and 1, got %g", scenario.Churn.Fraction)
+	case scenario.Churn.Fraction > 0 && scenario.Churn.Interval <= 0:
+		return fmt.Errorf("churn.interval must be positive when churn.fraction is set")
+	}
+
+	if len(scenario.Devices.IDPrefix) == 0 {
+		scenario.Devices.IDPrefix = defaultIDPrefix
+	}
+	if scenario.Connect.RetryDelay <= 0 {
+		scenario.Connect.RetryDelay = defaultRetryDelay
+	}
+	if len(scenario.Generator.Kind) == 0 {
+		scenario.Generator.Kind = RandomWalk
+	}
+
+	return nil
+}
+
+// DeviceID returns the ID of the device at index.
+func (scenario *Scenario) DeviceID(index int) string {
+	return fmt.Sprintf("%s%d", scenario.Devices.IDPrefix, index)
+}
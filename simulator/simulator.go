@@ -0,0 +1,314 @@
+package simulator
+
+import (
+	"crypto"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/pjgg/iotPlayground/keygen"
+	"github.com/pjgg/iotPlayground/keystore"
+)
+
+// Credentials of a virtual device, PublicKeyPEM is the ES256_PEM key to register the device with.
+type Credentials struct {
+	DeviceID     string
+	KeyStore     keystore.KeyStore
+	PublicKeyPEM []byte
+}
+
+// Dialer connect a virtual device.
+type Dialer func(credentials Credentials) (device.DeviceClient, error)
+
+// ProfileDialer connect devices over MQTT with the settings of a configuration profile.
+func ProfileDialer(profile, registryID string) Dialer {
+	return func(credentials Credentials) (device.DeviceClient, error) {
+		return device.NewProfileMQTTDeviceClient(profile, registryID, credentials.DeviceID, credentials.KeyStore)
+	}
+}
+
+// Simulator run a fleet of virtual devices described by a Scenario.
+type Simulator struct {
+	scenario  *Scenario
+	dial      Dialer
+	generator Generator
+	devices   []Credentials
+	stats     *Stats
+	random    *rand.Rand
+	mutex     sync.Mutex
+}
+
+// New create a Simulator, the device keys are loaded from or generated into scenario.Devices.KeyDir.
+func New(scenario *Scenario, dial Dialer) (*Simulator, error) {
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+
+	generator, err := NewGenerator(scenario.Generator, scenario.Devices.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	simulator := &Simulator{
+		scenario:  scenario,
+		dial:      dial,
+		generator: generator,
+		stats:     NewStats(),
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i := 0; i < scenario.Devices.Count; i++ {
+		credentials, err := deviceCredentials(scenario.DeviceID(i), scenario.Devices.KeyDir)
+		if err != nil {
+			return nil, err
+		}
+		simulator.devices = append(simulator.devices, credentials)
+	}
+
+	return simulator, nil
+}
+
+// deviceCredentials load the P-256 key pair of a device from keyDir, generating it when missing.
+func deviceCredentials(deviceID, keyDir string) (credentials Credentials, err error) {
+	credentials.DeviceID = deviceID
+	privatePath := filepath.Join(keyDir, deviceID+"_private.pem")
+	publicPath := filepath.Join(keyDir, deviceID+"_public.pem")
+
+	var signer crypto.Signer
+	if len(keyDir) > 0 {
+		if _, statErr := os.Stat(privatePath); statErr == nil {
+			if signer, err = keygen.LoadPrivateKey(privatePath, nil); err != nil {
+				return
+			}
+			if credentials.PublicKeyPEM, err = ioutil.ReadFile(publicPath); err != nil {
+				return
+			}
+			credentials.KeyStore = keystore.NewSignerKeyStore(signer)
+			return
+		}
+	}
+
+	if signer, err = keygen.GenerateP256(); err != nil {
+		return
+	}
+	credentials.KeyStore = keystore.NewSignerKeyStore(signer)
+	if credentials.PublicKeyPEM, err = keygen.PublicKeyPEM(signer.Public()); err != nil || len(keyDir) == 0 {
+		return
+	}
+
+	privatePEM, err := keygen.PrivateKeyPEM(signer, keygen.PKCS8)
+	if err != nil {
+		return
+	}
+	if err = keygen.WritePrivateKey(privatePath, privatePEM); err != nil {
+		return
+	}
+	err = keygen.WritePublic(publicPath, credentials.PublicKeyPEM)
+
+	return
+}
+
+// Devices returns the virtual devices credentials, e.g. to register them before Run.
+func (simulator *Simulator) Devices() []Credentials {
+	return simulator.devices
+}
+
+// Stats returns the live fleet statistics.
+func (simulator *Simulator) Stats() *Stats {
+	return simulator.stats
+}
+
+// Run connect the fleet and publish until the scenario duration elapses or stop is closed, every device is
+// disconnected before the final report is returned.
+func (simulator *Simulator) Run(stop <-chan struct{}) Report {
+	simulator.stats.start()
+	done := make(chan struct{})
+	var closeOnce sync.Once
+	finish := func() { closeOnce.Do(func() { close(done) }) }
+
+	go func() {
+		var timeout <-chan time.Time
+		if simulator.scenario.Duration > 0 {
+			timer := time.NewTimer(simulator.scenario.Duration)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-stop:
+		case <-timeout:
+		case <-done:
+		}
+		finish()
+	}()
+
+	var connectSlots chan struct{}
+	if simulator.scenario.Connect.Concurrency > 0 {
+		connectSlots = make(chan struct{}, simulator.scenario.Connect.Concurrency)
+	}
+
+	churns := make([]chan struct{}, len(simulator.devices))
+	var wait sync.WaitGroup
+	for i := range simulator.devices {
+		churns[i] = make(chan struct{}, 1)
+		wait.Add(1)
+		go func(index int) {
+			defer wait.Done()
+			simulator.runDevice(index, connectSlots, churns[index], done)
+		}(i)
+	}
+
+	if simulator.scenario.Churn.Fraction > 0 {
+		go simulator.churn(churns, done)
+	}
+
+	wait.Wait()
+	finish()
+	return simulator.stats.Report()
+}
+
+// runDevice keep one virtual device connected and publishing, reconnecting after churn or failures.
+func (simulator *Simulator) runDevice(index int, connectSlots chan struct{}, churn <-chan struct{}, done <-chan struct{}) {
+	scenario := simulator.scenario
+	if !sleep(scenario.Connect.RampUp*time.Duration(index)/time.Duration(len(simulator.devices)), done) {
+		return
+	}
+
+	for {
+		client, err := simulator.connect(index, connectSlots, done)
+		if client == nil {
+			if err == nil || !sleep(scenario.Connect.RetryDelay, done) {
+				return
+			}
+			continue
+		}
+
+		reconnectAfter := simulator.publishLoop(index, client, churn, done)
+		if err = client.Close(); err != nil {
+			log.Errorln(simulator.devices[index].DeviceID + ": " + err.Error())
+		}
+		simulator.stats.disconnect()
+
+		if reconnectAfter < 0 || !sleep(reconnectAfter, done) {
+			return
+		}
+	}
+}
+
+// connect returns nil without error when the run is over.
+func (simulator *Simulator) connect(index int, connectSlots chan struct{}, done <-chan struct{}) (device.DeviceClient, error) {
+	if connectSlots != nil {
+		select {
+		case connectSlots <- struct{}{}:
+			defer func() { <-connectSlots }()
+		case <-done:
+			return nil, nil
+		}
+	}
+
+	client, err := simulator.dial(simulator.devices[index])
+	simulator.stats.connect(err)
+	if err != nil {
+		log.Errorln(simulator.devices[index].DeviceID + " connection failed: " + err.Error())
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// publishLoop returns the downtime before reconnecting, negative once the run is over.
+func (simulator *Simulator) publishLoop(index int, client device.DeviceClient, churn <-chan struct{}, done <-chan struct{}) time.Duration {
+	publish := simulator.scenario.Publish
+	// a churn picked while the device was offline is dropped
+	select {
+	case <-churn:
+	default:
+	}
+
+	timer := time.NewTimer(simulator.nextInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return -1
+		case <-churn:
+			return simulator.scenario.Churn.Downtime
+		case at := <-timer.C:
+			payload, err := simulator.generator.Next(index, at)
+			if err != nil {
+				simulator.stats.publish(0, 0, err)
+			} else {
+				started := time.Now()
+				if publish.State {
+					err = client.ReportState(payload)
+				} else {
+					err = client.PublishTelemetry(&telemetry.Telemetry{Subfolder: publish.Subfolder, ContentType: "application/json", Payload: payload})
+				}
+				simulator.stats.publish(len(payload), time.Since(started), err)
+			}
+			timer.Reset(simulator.nextInterval())
+		}
+	}
+}
+
+func (simulator *Simulator) nextInterval() time.Duration {
+	interval := simulator.scenario.Publish.Interval
+	if jitter := simulator.scenario.Publish.Jitter; jitter > 0 {
+		simulator.mutex.Lock()
+		interval += time.Duration(simulator.random.Int63n(int64(jitter)))
+		simulator.mutex.Unlock()
+	}
+	return interval
+}
+
+// churn disconnect a random fraction of the devices every churn interval.
+func (simulator *Simulator) churn(churns []chan struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(simulator.scenario.Churn.Interval)
+	defer ticker.Stop()
+
+	count := int(math.Ceil(simulator.scenario.Churn.Fraction * float64(len(churns))))
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			simulator.mutex.Lock()
+			picked := simulator.random.Perm(len(churns))[:count]
+			simulator.mutex.Unlock()
+			for _, index := range picked {
+				select {
+				case churns[index] <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// sleep returns false when done is closed first.
+func sleep(duration time.Duration, done <-chan struct{}) bool {
+	if duration <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,130 @@
+package simulator_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/connectors/device"
+	"github.com/pjgg/iotPlayground/connectors/telemetry"
+	"github.com/pjgg/iotPlayground/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SimulatorTestSuite struct {
+	suite.Suite
+	mutex    sync.Mutex
+	clients  map[string]int
+	messages map[string]int
+	closed   int
+}
+
+type fakeClient struct {
+	device.DeviceClient
+	suite    *SimulatorTestSuite
+	deviceID string
+}
+
+func (client *fakeClient) PublishTelemetry(message *telemetry.Telemetry) error {
+	client.suite.mutex.Lock()
+	defer client.suite.mutex.Unlock()
+	client.suite.messages[client.deviceID]++
+	return nil
+}
+
+func (client *fakeClient) Close() error {
+	client.suite.mutex.Lock()
+	defer client.suite.mutex.Unlock()
+	client.suite.closed++
+	return nil
+}
+
+func (suite *SimulatorTestSuite) SetupTest() {
+	suite.clients = map[string]int{}
+	suite.messages = map[string]int{}
+	suite.closed = 0
+}
+
+func (suite *SimulatorTestSuite) dial(credentials simulator.Credentials) (device.DeviceClient, error) {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	suite.clients[credentials.DeviceID]++
+	return &fakeClient{suite: suite, deviceID: credentials.DeviceID}, nil
+}
+
+func (suite *SimulatorTestSuite) scenario() *simulator.Scenario {
+	return &simulator.Scenario{
+		Registry:  "registry",
+		Duration:  300 * time.Millisecond,
+		Devices:   simulator.DevicesSpec{Count: 4},
+		Publish:   simulator.PublishSpec{Interval: 20 * time.Millisecond},
+		Generator: simulator.GeneratorSpec{Kind: simulator.Sine, Period: time.Second},
+	}
+}
+
+func (suite *SimulatorTestSuite) TestRun() {
+	sim, err := simulator.New(suite.scenario(), suite.dial)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Len(suite.T(), sim.Devices(), 4)
+	assert.Equal(suite.T(), "sim-device-3", sim.Devices()[3].DeviceID)
+	assert.NotEqual(suite.T(), sim.Devices()[0].PublicKeyPEM, sim.Devices()[1].PublicKeyPEM)
+
+	report := sim.Run(nil)
+	assert.EqualValues(suite.T(), 4, report.Connects)
+	assert.EqualValues(suite.T(), 4, report.Disconnects)
+	assert.Equal(suite.T(), 0, report.Connected)
+	assert.True(suite.T(), report.Published > 20, report.String())
+	assert.True(suite.T(), report.Throughput > 0)
+	assert.Len(suite.T(), suite.messages, 4)
+}
+
+func (suite *SimulatorTestSuite) TestChurn() {
+	scenario := suite.scenario()
+	scenario.Churn = simulator.ChurnSpec{Interval: 50 * time.Millisecond, Fraction: 0.5}
+
+	sim, err := simulator.New(scenario, suite.dial)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	report := sim.Run(nil)
+	assert.True(suite.T(), report.Connects > 4, report.String())
+	assert.Equal(suite.T(), report.Connects, report.Disconnects)
+}
+
+func (suite *SimulatorTestSuite) TestConnectErrors() {
+	scenario := suite.scenario()
+	scenario.Connect.RetryDelay = 50 * time.Millisecond
+
+	sim, err := simulator.New(scenario, func(credentials simulator.Credentials) (device.DeviceClient, error) {
+		return nil, errors.New("not authorized")
+	})
+	assert.NoError(suite.T(), err, "UnexpectedError")
+
+	stop := make(chan struct{})
+	time.AfterFunc(120*time.Millisecond, func() { close(stop) })
+	report := sim.Run(stop)
+	assert.True(suite.T(), report.ConnectErrors >= 8, report.String())
+	assert.EqualValues(suite.T(), 0, report.Published)
+	assert.Equal(suite.T(), report.ConnectErrors, report.Errors["connect: not authorized"])
+}
+
+func (suite *SimulatorTestSuite) TestInvalidScenario() {
+	scenario := suite.scenario()
+	scenario.Churn.Fraction = 2
+
+	_, err := simulator.New(scenario, suite.dial)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *SimulatorTestSuite) TestLoadExampleScenario() {
+	scenario, err := simulator.LoadScenario("../simulator_example.yaml")
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), 100, scenario.Devices.Count)
+	assert.Equal(suite.T(), 200*time.Millisecond, scenario.Publish.Jitter)
+	assert.Equal(suite.T(), "sim-device-7", scenario.DeviceID(7))
+}
+
+func TestSimulatorTestSuite(t *testing.T) {
+	suite.Run(t, new(SimulatorTestSuite))
+}
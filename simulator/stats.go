@@ -0,0 +1,171 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencySamples bound the memory kept for the latency percentiles, samples are picked by reservoir sampling.
+const latencySamples = 10000
+
+// Stats aggregate the fleet activity, safe for concurrent use.
+type Stats struct {
+	mutex         sync.Mutex
+	started       time.Time
+	connected     int
+	connects      uint64
+	connectErrors uint64
+	disconnects   uint64
+	published     uint64
+	publishErrors uint64
+	bytes         uint64
+	errors        map[string]uint64
+	latencies     []time.Duration
+	observed      uint64
+	maxLatency    time.Duration
+	random        *rand.Rand
+}
+
+// Report is a snapshot of Stats.
+type Report struct {
+	Elapsed       time.Duration     `json:"elapsed"`
+	Connected     int               `json:"connected"`
+	Connects      uint64            `json:"connects"`
+	ConnectErrors uint64            `json:"connectErrors"`
+	Disconnects   uint64            `json:"disconnects"`
+	Published     uint64            `json:"published"`
+	PublishErrors uint64            `json:"publishErrors"`
+	Bytes         uint64            `json:"bytes"`
+	Throughput    float64           `json:"throughput"`
+	LatencyP50    time.Duration     `json:"latencyP50"`
+	LatencyP95    time.Duration     `json:"latencyP95"`
+	LatencyP99    time.Duration     `json:"latencyP99"`
+	LatencyMax    time.Duration     `json:"latencyMax"`
+	Errors        map[string]uint64 `json:"errors,omitempty"`
+}
+
+// NewStats create empty Stats, the elapsed time starts now.
+func NewStats() *Stats {
+	return &Stats{
+		started: time.Now(),
+		errors:  map[string]uint64{},
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// start reset the elapsed time, the throughput only counts the run.
+func (stats *Stats) start() {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.started = time.Now()
+}
+
+func (stats *Stats) connect(err error) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	if err != nil {
+		stats.connectErrors++
+		stats.errors["connect: "+err.Error()]++
+		return
+	}
+	stats.connects++
+	stats.connected++
+}
+
+func (stats *Stats) disconnect() {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	stats.disconnects++
+	stats.connected--
+}
+
+func (stats *Stats) publish(size int, latency time.Duration, err error) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	if err != nil {
+		stats.publishErrors++
+		stats.errors["publish: "+err.Error()]++
+		return
+	}
+
+	stats.published++
+	stats.bytes += uint64(size)
+	if latency > stats.maxLatency {
+		stats.maxLatency = latency
+	}
+
+	stats.observed++
+	if len(stats.latencies) < latencySamples {
+		stats.latencies = append(stats.latencies, latency)
+	} else if slot := stats.random.Int63n(int64(stats.observed)); slot < latencySamples {
+		stats.latencies[slot] = latency
+	}
+}
+
+// Report returns the current totals, throughput is the published messages per second since the run started.
+func (stats *Stats) Report() Report {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	report := Report{
+		Elapsed:       time.Since(stats.started),
+		Connected:     stats.connected,
+		Connects:      stats.connects,
+		ConnectErrors: stats.connectErrors,
+		Disconnects:   stats.disconnects,
+		Published:     stats.published,
+		PublishErrors: stats.publishErrors,
+		Bytes:         stats.bytes,
+		LatencyMax:    stats.maxLatency,
+	}
+	if seconds := report.Elapsed.Seconds(); seconds > 0 {
+		report.Throughput = float64(stats.published) / seconds
+	}
+	if len(stats.errors) > 0 {
+		report.Errors = map[string]uint64{}
+		for message, count := range stats.errors {
+			report.Errors[message] = count
+		}
+	}
+
+	sorted := make([]time.Duration, len(stats.latencies))
+	copy(sorted, stats.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	report.LatencyP50 = percentile(sorted, 50)
+	report.LatencyP95 = percentile(sorted, 95)
+	report.LatencyP99 = percentile(sorted, 99)
+
+	return report
+}
+
+func percentile(sorted []time.Duration, rank int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*rank/100]
+}
+
+// String returns a single line summary, suitable for progress logs.
+func (report Report) String() string {
+	summary := fmt.Sprintf("elapsed=%s connected=%d connects=%d connectErrors=%d disconnects=%d published=%d publishErrors=%d throughput=%.1f/s p50=%s p95=%s p99=%s max=%s",
+		report.Elapsed.Truncate(time.Millisecond), report.Connected, report.Connects, report.ConnectErrors, report.Disconnects,
+		report.Published, report.PublishErrors, report.Throughput, report.LatencyP50, report.LatencyP95, report.LatencyP99, report.LatencyMax)
+
+	if len(report.Errors) > 0 {
+		messages := make([]string, 0, len(report.Errors))
+		for message, count := range report.Errors {
+			messages = append(messages, fmt.Sprintf("%dx %s", count, message))
+		}
+		sort.Strings(messages)
+		summary += " errors=[" + strings.Join(messages, "; ") + "]"
+	}
+
+	return summary
+}
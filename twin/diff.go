@@ -0,0 +1,109 @@
+package twin
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ChangeKind tells how a reported value differ from the desired one.
+type ChangeKind int
+
+const (
+	// Missing values are desired but not reported.
+	Missing ChangeKind = 1 + iota
+	// Unexpected values are reported but not desired.
+	Unexpected
+	// Different values are desired and reported with another value.
+	Different
+)
+
+var changeKindName = [...]string{
+	"missing",
+	"unexpected",
+	"different",
+}
+
+func (kind ChangeKind) String() string {
+	return changeKindName[kind-1]
+}
+
+// MarshalJSON write the change kind name.
+func (kind ChangeKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(kind.String())
+}
+
+// Change is one difference between the desired and the reported documents.
+type Change struct {
+	// Path locate the value, e.g. fan.speed or zones[2].
+	Path     string      `json:"path"`
+	Kind     ChangeKind  `json:"kind"`
+	Desired  interface{} `json:"desired,omitempty"`
+	Reported interface{} `json:"reported,omitempty"`
+}
+
+// Diff compare two JSON documents. Objects are compared key by key and arrays index by index, changes
+// are sorted by path.
+func Diff(desired, reported []byte) ([]Change, error) {
+	var desiredDocument, reportedDocument interface{}
+	if err := json.Unmarshal(desired, &desiredDocument); err != nil {
+		return nil, fmt.Errorf("desired document is not JSON: %s", err.Error())
+	}
+	if err := json.Unmarshal(reported, &reportedDocument); err != nil {
+		return nil, fmt.Errorf("reported document is not JSON: %s", err.Error())
+	}
+
+	changes := []Change{}
+	diffValues("", desiredDocument, reportedDocument, &changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func diffValues(path string, desired, reported interface{}, changes *[]Change) {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		if reportedValue, isObject := reported.(map[string]interface{}); isObject {
+			for key, value := range desiredValue {
+				if other, exist := reportedValue[key]; exist {
+					diffValues(join(path, key), value, other, changes)
+				} else {
+					*changes = append(*changes, Change{Path: join(path, key), Kind: Missing, Desired: value})
+				}
+			}
+			for key, value := range reportedValue {
+				if _, exist := desiredValue[key]; !exist {
+					*changes = append(*changes, Change{Path: join(path, key), Kind: Unexpected, Reported: value})
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if reportedValue, isArray := reported.([]interface{}); isArray {
+			for i := 0; i < len(desiredValue) || i < len(reportedValue); i++ {
+				indexPath := path + "[" + strconv.Itoa(i) + "]"
+				switch {
+				case i >= len(reportedValue):
+					*changes = append(*changes, Change{Path: indexPath, Kind: Missing, Desired: desiredValue[i]})
+				case i >= len(desiredValue):
+					*changes = append(*changes, Change{Path: indexPath, Kind: Unexpected, Reported: reportedValue[i]})
+				default:
+					diffValues(indexPath, desiredValue[i], reportedValue[i], changes)
+				}
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(desired, reported) {
+		*changes = append(*changes, Change{Path: path, Kind: Different, Desired: desired, Reported: reported})
+	}
+}
+
+func join(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,204 @@
+package twin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+// Status summarize how far a device is from its desired config.
+type Status int
+
+const (
+	// InSync devices acknowledged the latest config and report the desired values.
+	InSync Status = 1 + iota
+	// Pending devices did not acknowledge the latest config, or did not report a state since.
+	Pending
+	// Stale devices report nothing, nothing recent, or values still different from an acknowledged config.
+	Stale
+)
+
+var statusName = [...]string{
+	"inSync",
+	"pending",
+	"stale",
+}
+
+func (status Status) String() string {
+	return statusName[status-1]
+}
+
+// MarshalJSON write the status name.
+func (status Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(status.String())
+}
+
+// Connector is the part of the device admin connector the Twin view needs, HTTPIotDeviceConnectorInterface
+// implements it.
+type Connector interface {
+	GetDevice(deviceID string) (*cloudiot.Device, error)
+	GetDeviceConfigs(deviceID string) ([]*cloudiot.DeviceConfig, error)
+	GetDeviceStates(deviceID string) ([]*cloudiot.DeviceState, error)
+}
+
+// Options tune the Twin status.
+type Options struct {
+	// StaleAfter mark devices without state for longer as Stale, disabled when zero.
+	StaleAfter time.Duration
+	// Ignore skip reported paths, and everything below them, e.g. the status fields of device agents.
+	Ignore []string
+}
+
+// Twin combine the desired config and the reported state of a device. Desired and Reported hold the decoded
+// JSON documents, or the raw text of non JSON payloads.
+type Twin struct {
+	DeviceID           string      `json:"deviceId"`
+	Status             Status      `json:"status"`
+	DesiredVersion     int64       `json:"desiredVersion"`
+	Desired            interface{} `json:"desired,omitempty"`
+	Reported           interface{} `json:"reported,omitempty"`
+	ConfigUpdateTime   time.Time   `json:"configUpdateTime,omitempty"`
+	LastConfigAckTime  time.Time   `json:"lastConfigAckTime,omitempty"`
+	LastConfigSendTime time.Time   `json:"lastConfigSendTime,omitempty"`
+	LastStateTime      time.Time   `json:"lastStateTime,omitempty"`
+	// Diff is empty when in sync, nil when a payload is not JSON and only the bytes could be compared.
+	Diff []Change `json:"diff"`
+}
+
+// Get read the device, its configs and its states and compute its Twin.
+func Get(connector Connector, deviceID string, options Options) (*Twin, error) {
+	device, err := connector.GetDevice(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := connector.GetDeviceConfigs(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	states, err := connector.GetDeviceStates(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Compute(device, configs, states, time.Now(), options)
+}
+
+// Compute the Twin of a device at now. The desired config is the highest config version and the reported
+// state the most recent one.
+func Compute(device *cloudiot.Device, configs []*cloudiot.DeviceConfig, states []*cloudiot.DeviceState, now time.Time, options Options) (*Twin, error) {
+	twin := &Twin{DeviceID: device.Id}
+
+	var err error
+	if twin.LastConfigAckTime, err = parseTime(device.LastConfigAckTime); err != nil {
+		return nil, err
+	}
+	if twin.LastConfigSendTime, err = parseTime(device.LastConfigSendTime); err != nil {
+		return nil, err
+	}
+	if twin.LastStateTime, err = parseTime(device.LastStateTime); err != nil {
+		return nil, err
+	}
+
+	var desired *cloudiot.DeviceConfig
+	for _, config := range configs {
+		if desired == nil || config.Version > desired.Version {
+			desired = config
+		}
+	}
+	var reported *cloudiot.DeviceState
+	var reportedTime time.Time
+	for _, state := range states {
+		updateTime, err := parseTime(state.UpdateTime)
+		if err != nil {
+			return nil, err
+		}
+		if reported == nil || updateTime.After(reportedTime) {
+			reported, reportedTime = state, updateTime
+		}
+	}
+	if reportedTime.After(twin.LastStateTime) {
+		twin.LastStateTime = reportedTime
+	}
+
+	var desiredData, reportedData []byte
+	if desired != nil {
+		twin.DesiredVersion = desired.Version
+		if twin.ConfigUpdateTime, err = parseTime(desired.CloudUpdateTime); err != nil {
+			return nil, err
+		}
+		if desiredData, err = base64.StdEncoding.DecodeString(desired.BinaryData); err != nil {
+			return nil, fmt.Errorf("config version %d: %s", desired.Version, err.Error())
+		}
+		twin.Desired = document(desiredData)
+		// the config version records its own acknowledgement
+		if ackTime, err := parseTime(desired.DeviceAckTime); err == nil && ackTime.After(twin.LastConfigAckTime) {
+			twin.LastConfigAckTime = ackTime
+		}
+	}
+	if reported != nil {
+		if reportedData, err = base64.StdEncoding.DecodeString(reported.BinaryData); err != nil {
+			return nil, fmt.Errorf("state of %s: %s", reported.UpdateTime, err.Error())
+		}
+		twin.Reported = document(reportedData)
+	}
+
+	inSync := bytes.Equal(desiredData, reportedData)
+	if desired != nil && reported != nil {
+		if changes, diffErr := Diff(desiredData, reportedData); diffErr == nil {
+			twin.Diff = ignore(changes, options.Ignore)
+			inSync = len(twin.Diff) == 0
+		}
+	}
+
+	acknowledged := desired == nil || (!twin.LastConfigAckTime.IsZero() && !twin.LastConfigAckTime.Before(twin.ConfigUpdateTime))
+	reportedSince := reported != nil && !twin.LastStateTime.Before(twin.ConfigUpdateTime)
+	switch {
+	case reported == nil || (options.StaleAfter > 0 && now.Sub(twin.LastStateTime) > options.StaleAfter):
+		twin.Status = Stale
+	case !acknowledged || !reportedSince:
+		twin.Status = Pending
+	case inSync:
+		twin.Status = InSync
+	default:
+		twin.Status = Stale
+	}
+
+	return twin, nil
+}
+
+func ignore(changes []Change, paths []string) []Change {
+	kept := []Change{}
+	for _, change := range changes {
+		ignored := false
+		for _, path := range paths {
+			if change.Path == path || strings.HasPrefix(change.Path, path+".") || strings.HasPrefix(change.Path, path+"[") {
+				ignored = true
+				break
+			}
+		}
+		if !ignored {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
+
+func document(data []byte) interface{} {
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err == nil {
+		return decoded
+	}
+	return string(data)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,100 @@
+package twin_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/pjgg/iotPlayground/twin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cloudiot "google.golang.org/api/cloudiot/v1"
+)
+
+type TwinTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func (suite *TwinTestSuite) SetupTest() {
+	suite.now = time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func (suite *TwinTestSuite) at(minutes int) string {
+	return suite.now.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339Nano)
+}
+
+func encode(data string) string {
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+func (suite *TwinTestSuite) compute(device *cloudiot.Device, desired, reported string, options twin.Options) *twin.Twin {
+	configs := []*cloudiot.DeviceConfig{
+		{Version: 1, CloudUpdateTime: suite.at(-60), BinaryData: encode(`{"fan":"off"}`)},
+		{Version: 2, CloudUpdateTime: suite.at(-10), BinaryData: encode(desired)},
+	}
+	states := []*cloudiot.DeviceState{
+		{UpdateTime: suite.at(-50), BinaryData: encode(`{"fan":"off"}`)},
+		{UpdateTime: device.LastStateTime, BinaryData: encode(reported)},
+	}
+
+	result, err := twin.Compute(device, configs, states, suite.now, options)
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	return result
+}
+
+func (suite *TwinTestSuite) TestInSync() {
+	device := &cloudiot.Device{Id: "device-1", LastConfigAckTime: suite.at(-9), LastStateTime: suite.at(-8)}
+	result := suite.compute(device, `{"fan":"on","speed":3}`, `{"speed":3,"fan":"on"}`, twin.Options{})
+
+	assert.Equal(suite.T(), twin.InSync, result.Status)
+	assert.EqualValues(suite.T(), 2, result.DesiredVersion)
+	assert.Empty(suite.T(), result.Diff)
+	assert.Equal(suite.T(), map[string]interface{}{"fan": "on", "speed": float64(3)}, result.Desired)
+}
+
+func (suite *TwinTestSuite) TestPending() {
+	device := &cloudiot.Device{Id: "device-1", LastConfigAckTime: suite.at(-50), LastStateTime: suite.at(-20)}
+	result := suite.compute(device, `{"fan":"on"}`, `{"fan":"off"}`, twin.Options{})
+
+	assert.Equal(suite.T(), twin.Pending, result.Status)
+	assert.Equal(suite.T(), []twin.Change{{Path: "fan", Kind: twin.Different, Desired: "on", Reported: "off"}}, result.Diff)
+}
+
+func (suite *TwinTestSuite) TestStale() {
+	device := &cloudiot.Device{Id: "device-1", LastConfigAckTime: suite.at(-9), LastStateTime: suite.at(-8)}
+	result := suite.compute(device, `{"fan":"on"}`, `{"fan":"off"}`, twin.Options{})
+	assert.Equal(suite.T(), twin.Stale, result.Status)
+
+	result = suite.compute(device, `{"fan":"on"}`, `{"fan":"on"}`, twin.Options{StaleAfter: 5 * time.Minute})
+	assert.Equal(suite.T(), twin.Stale, result.Status)
+}
+
+func (suite *TwinTestSuite) TestIgnore() {
+	device := &cloudiot.Device{Id: "device-1", LastConfigAckTime: suite.at(-9), LastStateTime: suite.at(-8)}
+	result := suite.compute(device, `{"fan":"on"}`, `{"fan":"on","agent":{"status":"applied"}}`, twin.Options{Ignore: []string{"agent"}})
+	assert.Equal(suite.T(), twin.InSync, result.Status)
+}
+
+func (suite *TwinTestSuite) TestNotJSON() {
+	device := &cloudiot.Device{Id: "device-1", LastConfigAckTime: suite.at(-9), LastStateTime: suite.at(-8)}
+	result := suite.compute(device, `fan=on`, `fan=on`, twin.Options{})
+	assert.Equal(suite.T(), twin.InSync, result.Status)
+	assert.Nil(suite.T(), result.Diff)
+	assert.Equal(suite.T(), "fan=on", result.Reported)
+}
+
+func (suite *TwinTestSuite) TestDiff() {
+	changes, err := twin.Diff([]byte(`{"zones":[1,2,3],"fan":{"speed":3,"mode":"auto"}}`), []byte(`{"zones":[1,5],"fan":{"speed":3},"extra":true}`))
+	assert.NoError(suite.T(), err, "UnexpectedError")
+	assert.Equal(suite.T(), []twin.Change{
+		{Path: "extra", Kind: twin.Unexpected, Reported: true},
+		{Path: "fan.mode", Kind: twin.Missing, Desired: "auto"},
+		{Path: "zones[1]", Kind: twin.Different, Desired: float64(2), Reported: float64(5)},
+		{Path: "zones[2]", Kind: twin.Missing, Desired: float64(3)},
+	}, changes)
+}
+
+func TestTwinTestSuite(t *testing.T) {
+	suite.Run(t, new(TwinTestSuite))
+}